@@ -0,0 +1,102 @@
+package weathermetrics
+
+import (
+	"fmt"
+	"time"
+)
+
+/*
+ * Config
+ */
+type AlertConfig struct {
+	BatteryHysteresis int `envconfig:"BATTERY_HYSTERESIS" default:"3"`
+
+	// AlertCooldown is the minimum time between repeats of an active alert
+	AlertCooldown time.Duration `envconfig:"ALERT_COOLDOWN" default:"1h"`
+}
+
+func (c AlertConfig) Validate() error {
+	if c.BatteryHysteresis < 1 {
+		return fmt.Errorf("BATTERY_HYSTERESIS must be at least 1, got %d", c.BatteryHysteresis)
+	}
+
+	if c.AlertCooldown < 0 {
+		return fmt.Errorf("ALERT_COOLDOWN must not be negative, got %s", c.AlertCooldown)
+	}
+
+	return nil
+}
+
+/*
+ * Battery
+ *
+ * battery_ok flickers near end of life, so the monitor only changes state once
+ * it has seen threshold consecutive readings disagreeing with the current one.
+ */
+type BatteryMonitor struct {
+	threshold int
+	low       bool
+	streak    int
+}
+
+func NewBatteryMonitor(threshold int) *BatteryMonitor {
+	return &BatteryMonitor{threshold: threshold}
+}
+
+// Observe records a battery_ok reading and reports whether the alert state
+// changed as a result
+func (b *BatteryMonitor) Observe(batteryOK int) bool {
+	if (batteryOK == 0) == b.low {
+		b.streak = 0
+		return false
+	}
+
+	b.streak++
+	if b.streak < b.threshold {
+		return false
+	}
+
+	b.low = !b.low
+	b.streak = 0
+	return true
+}
+
+func (b *BatteryMonitor) Low() bool {
+	return b.low
+}
+
+/*
+ * Cooldown
+ *
+ * Alert conditions are re-evaluated on every message. AlertLimiter lets an
+ * active alert through at most once per cooldown, keyed by alert name; once
+ * the alert is cleared the next firing goes through immediately.
+ *
+ * AlertLimiter is not safe for concurrent use; callers hold their own lock.
+ */
+type AlertLimiter struct {
+	cooldown  time.Duration
+	lastFired map[string]time.Time
+}
+
+func NewAlertLimiter(cooldown time.Duration) *AlertLimiter {
+	return &AlertLimiter{cooldown: cooldown, lastFired: make(map[string]time.Time)}
+}
+
+// Fire reports whether the alert named key should be sent now
+func (a *AlertLimiter) Fire(key string, now time.Time) bool {
+	last, active := a.lastFired[key]
+	if active && now.Sub(last) < a.cooldown {
+		return false
+	}
+
+	a.lastFired[key] = now
+	return true
+}
+
+// Clear marks the alert named key as resolved and reports whether it was active
+func (a *AlertLimiter) Clear(key string) bool {
+	_, active := a.lastFired[key]
+	delete(a.lastFired, key)
+	return active
+}
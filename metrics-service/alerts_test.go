@@ -0,0 +1,111 @@
+package weathermetrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBatteryMonitorIgnoresFlicker(t *testing.T) {
+	b := NewBatteryMonitor(3)
+
+	// Near end of life: never three of a kind in a row
+	for i, ok := range []int{0, 1, 0, 0, 1, 0, 1, 1, 0} {
+		if b.Observe(ok) {
+			t.Fatalf("reading %d (%d) changed state on a flickering sequence", i, ok)
+		}
+	}
+	if b.Low() {
+		t.Error("low after flicker")
+	}
+}
+
+func TestBatteryMonitorHysteresis(t *testing.T) {
+	b := NewBatteryMonitor(3)
+
+	steps := []struct {
+		batteryOK int
+		changed   bool
+		low       bool
+	}{
+		{0, false, false},
+		{0, false, false},
+		{0, true, true},
+		// Still low; a single ok reading doesn't recover it
+		{0, false, true},
+		{1, false, true},
+		{0, false, true},
+		{1, false, true},
+		{1, false, true},
+		{1, true, false},
+	}
+
+	for i, step := range steps {
+		if changed := b.Observe(step.batteryOK); changed != step.changed {
+			t.Errorf("step %d: Observe(%d) = %v, want %v", i, step.batteryOK, changed, step.changed)
+		}
+		if b.Low() != step.low {
+			t.Errorf("step %d: Low() = %v, want %v", i, b.Low(), step.low)
+		}
+	}
+}
+
+func TestBatteryMonitorThresholdOne(t *testing.T) {
+	b := NewBatteryMonitor(1)
+
+	if !b.Observe(0) || !b.Low() {
+		t.Error("threshold 1 should go low on the first 0")
+	}
+	if !b.Observe(1) || b.Low() {
+		t.Error("threshold 1 should recover on the first 1")
+	}
+}
+
+func TestAlertConfigValidate(t *testing.T) {
+	if err := (AlertConfig{BatteryHysteresis: 0}).Validate(); err == nil {
+		t.Error("BATTERY_HYSTERESIS 0 accepted")
+	}
+}
+
+func TestAlertLimiterSuppressesWithinCooldown(t *testing.T) {
+	at := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewAlertLimiter(10 * time.Minute)
+
+	if !limiter.Fire("battery", at) {
+		t.Fatal("first firing suppressed")
+	}
+	if limiter.Fire("battery", at.Add(time.Minute)) {
+		t.Error("repeat within cooldown sent")
+	}
+	if limiter.Fire("battery", at.Add(10*time.Minute-time.Second)) {
+		t.Error("repeat just inside cooldown sent")
+	}
+
+	// Alerts are limited independently
+	if !limiter.Fire("freeze", at.Add(time.Minute)) {
+		t.Error("a different alert was suppressed")
+	}
+
+	if !limiter.Fire("battery", at.Add(10*time.Minute)) {
+		t.Error("still suppressed once the cooldown passed")
+	}
+	if limiter.Fire("battery", at.Add(11*time.Minute)) {
+		t.Error("cooldown did not restart after re-firing")
+	}
+}
+
+func TestAlertLimiterRefiresAfterClear(t *testing.T) {
+	at := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewAlertLimiter(10 * time.Minute)
+
+	limiter.Fire("battery", at)
+	if !limiter.Clear("battery") {
+		t.Error("Clear of an active alert reported inactive")
+	}
+	if limiter.Clear("battery") {
+		t.Error("second Clear reported active")
+	}
+
+	if !limiter.Fire("battery", at.Add(time.Minute)) {
+		t.Error("cleared alert suppressed on re-firing")
+	}
+}
@@ -0,0 +1,79 @@
+package weathermetrics
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+/*
+ * Field aliases
+ *
+ * rtl_433 names a field after its unit, so devices that report in other
+ * units send the same reading under another name. FieldAliases lists each
+ * known alternate with the canonical field the measurements decode and the
+ * conversion to the canonical unit. An alias is only used when the payload
+ * lacks the canonical field, and is replaced by it. When a payload carries
+ * two aliases of one field, the one listed first wins and the other is left
+ * alone, so the result doesn't depend on map order.
+ *
+ * Alternates that differ in format rather than unit, such as wind_dir as a
+ * compass point, stay in the measurement's UnmarshalJSON.
+ */
+type FieldAlias struct {
+	Name      string
+	Canonical string
+	Convert   func(float32) float32
+}
+
+var FieldAliases = []FieldAlias{
+	{Name: "temperature_C", Canonical: "temperature_F", Convert: CelsiusToFahrenheit},
+	{Name: "pressure_kPa", Canonical: "pressure_hPa", Convert: KPaToHPa},
+	{Name: "pressure_inHg", Canonical: "pressure_hPa", Convert: InHgToHPa},
+	{Name: "wind_avg_m_s", Canonical: "wind_avg_km_h", Convert: MsToKmh},
+	{Name: "wind_max_m_s", Canonical: "wind_max_km_h", Convert: MsToKmh},
+	{Name: "wind_avg_mi_h", Canonical: "wind_avg_km_h", Convert: MphToKmh},
+	{Name: "wind_max_mi_h", Canonical: "wind_max_km_h", Convert: MphToKmh},
+	{Name: "rain_mm", Canonical: "rain_in", Convert: MMToInches},
+}
+
+// ApplyAliases rewrites any aliased fields in event to their canonical
+// names and units. An event without aliases is returned unchanged.
+func ApplyAliases(event json.RawMessage) (json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(event, &fields); err != nil {
+		return nil, err
+	}
+
+	changed := false
+	for _, alias := range FieldAliases {
+		raw, ok := fields[alias.Name]
+		if !ok {
+			continue
+		}
+
+		// Present in the payload or filled in by an earlier alias
+		if _, ok := fields[alias.Canonical]; ok {
+			continue
+		}
+
+		var v float32
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, fmt.Errorf("could not decode %s %s: %w", alias.Name, raw, err)
+		}
+
+		converted, err := json.Marshal(alias.Convert(v))
+		if err != nil {
+			return nil, err
+		}
+
+		fields[alias.Canonical] = converted
+		delete(fields, alias.Name)
+		changed = true
+	}
+
+	if !changed {
+		return event, nil
+	}
+
+	return json.Marshal(fields)
+}
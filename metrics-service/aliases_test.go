@@ -0,0 +1,83 @@
+package weathermetrics
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// aliased applies the aliases to payload and decodes the result
+func aliased(t *testing.T, payload string) map[string]float32 {
+	t.Helper()
+
+	event, err := ApplyAliases(json.RawMessage(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fields map[string]float32
+	if err := json.Unmarshal(event, &fields); err != nil {
+		t.Fatal(err)
+	}
+	return fields
+}
+
+func TestApplyAliasesConverts(t *testing.T) {
+	for _, tc := range []struct {
+		payload   string
+		canonical string
+		want      float32
+	}{
+		{payload: `{"temperature_C":20}`, canonical: "temperature_F", want: 68},
+		{payload: `{"pressure_kPa":101.325}`, canonical: "pressure_hPa", want: 1013.25},
+		{payload: `{"pressure_inHg":29.92}`, canonical: "pressure_hPa", want: 1013.21},
+		{payload: `{"wind_avg_m_s":10}`, canonical: "wind_avg_km_h", want: 36},
+		{payload: `{"wind_max_mi_h":10}`, canonical: "wind_max_km_h", want: 16.0934},
+		{payload: `{"rain_mm":25.4}`, canonical: "rain_in", want: 1},
+	} {
+		fields := aliased(t, tc.payload)
+		if got, ok := fields[tc.canonical]; !ok || !approxEqual(got, tc.want) {
+			t.Errorf("%s: %s = %v, want %v", tc.payload, tc.canonical, got, tc.want)
+		}
+		if len(fields) != 1 {
+			t.Errorf("%s: alias kept alongside its canonical field: %v", tc.payload, fields)
+		}
+	}
+}
+
+func TestApplyAliasesCanonicalWins(t *testing.T) {
+	fields := aliased(t, `{"temperature_F":70,"temperature_C":20}`)
+	if fields["temperature_F"] != 70 {
+		t.Errorf("temperature_F = %v, want the payload's own 70", fields["temperature_F"])
+	}
+}
+
+func TestApplyAliasesFirstListedWins(t *testing.T) {
+	// pressure_kPa is listed before pressure_inHg. Run it a few times, as
+	// map iteration order used to decide this.
+	for range 20 {
+		fields := aliased(t, `{"pressure_inHg":30.50,"pressure_kPa":100}`)
+		if !approxEqual(fields["pressure_hPa"], 1000) {
+			t.Fatalf("pressure_hPa = %v, want 1000 from pressure_kPa", fields["pressure_hPa"])
+		}
+		if _, ok := fields["pressure_inHg"]; !ok {
+			t.Fatalf("the losing alias was dropped: %v", fields)
+		}
+	}
+}
+
+func TestApplyAliasesLeavesPlainEventsAlone(t *testing.T) {
+	event := json.RawMessage(`{"temperature_F": 70}`)
+	got, err := ApplyAliases(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(event) {
+		t.Errorf("event rewritten to %s", got)
+	}
+}
+
+func TestApplyAliasesRejectsNonNumbers(t *testing.T) {
+	if _, err := ApplyAliases(json.RawMessage(`{"rain_mm":"lots"}`)); err == nil {
+		t.Error("string rain_mm accepted")
+	}
+}
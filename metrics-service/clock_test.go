@@ -0,0 +1,44 @@
+package weathermetrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClockTicksOnAdvance(t *testing.T) {
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	ticker := clock.NewTicker(time.Minute)
+
+	clock.Advance(30 * time.Second)
+	select {
+	case <-ticker.C():
+		t.Fatal("ticked before the period elapsed")
+	default:
+	}
+
+	clock.Advance(30 * time.Second)
+	select {
+	case at := <-ticker.C():
+		if !at.Equal(start.Add(time.Minute)) {
+			t.Errorf("tick at %s, want %s", at, start.Add(time.Minute))
+		}
+	default:
+		t.Fatal("no tick after the period elapsed")
+	}
+
+	if got := clock.Now(); !got.Equal(start.Add(time.Minute)) {
+		t.Errorf("Now() = %s, want %s", got, start.Add(time.Minute))
+	}
+
+	ticker.Stop()
+	clock.Advance(time.Hour)
+	select {
+	case <-ticker.C():
+		t.Fatal("stopped ticker ticked")
+	default:
+	}
+	if n := clock.Tickers(); n != 0 {
+		t.Errorf("Tickers() = %d after Stop, want 0", n)
+	}
+}
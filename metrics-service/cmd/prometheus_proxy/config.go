@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	weathermetrics "github.com/mckeowbc/weather-metrics"
+)
+
+/*
+ * Config
+ */
+
+// Wind policies for when only temp/humidity messages are arriving. "hold"
+// keeps reporting the last wind reading indefinitely; "stale" stops emitting
+// wind series once they are older than WindStaleAfter.
+const (
+	WIND_HOLD_LAST  = "hold"
+	WIND_MARK_STALE = "stale"
+)
+
+// Unit systems for /metrics. Imperial is the historical output; scientific
+// additionally emits temperature in Kelvin; both additionally emits
+// temperature, wind, rain and pressure in imperial and metric units side by
+// side, with the unit in each series name.
+const (
+	UNITS_IMPERIAL   = "imperial"
+	UNITS_SCIENTIFIC = "scientific"
+	UNITS_BOTH       = "both"
+)
+
+// Wind direction output. "raw" is the sensor's degrees; "sector" snaps
+// wind_direction to the nearest of the 16 compass points; "both" keeps the
+// raw wind_direction and adds weather_wind_direction_sector.
+const (
+	WIND_DIRECTION_RAW    = "raw"
+	WIND_DIRECTION_SECTOR = "sector"
+	WIND_DIRECTION_BOTH   = "both"
+)
+
+type Config struct {
+	weathermetrics.AlertConfig
+	weathermetrics.RemoteWriteConfig
+	weathermetrics.StatsdConfig
+	weathermetrics.WebhookConfig
+	weathermetrics.ValidationConfig
+	weathermetrics.TrendConfig
+	weathermetrics.ModbusConfig
+	weathermetrics.MQTTPublishConfig
+	weathermetrics.DecimationConfig
+	weathermetrics.ComfortConfig
+	weathermetrics.SunConfig
+	weathermetrics.TimezoneConfig
+	weathermetrics.SequenceConfig
+	weathermetrics.PairConfig
+
+	// RequireTopic makes an empty MQTT_TOPIC fatal rather than a warning
+	RequireTopic bool `envconfig:"REQUIRE_TOPIC" default:"false"`
+
+	// BasePath prefixes every endpoint, e.g. "/weather"
+	BasePath    string `envconfig:"BASE_PATH"`
+	MetricsPath string `envconfig:"METRICS_PATH" default:"/metrics"`
+
+	// Metrics limits which series MetricsHandler writes. Empty means all.
+	Metrics []string `envconfig:"METRICS"`
+
+	// MetricsStaleAfter withholds readings from /metrics once nothing has
+	// arrived for this long. HealthStaleAfter is the (usually more
+	// forgiving) age at which /healthz starts failing.
+	MetricsStaleAfter time.Duration `envconfig:"METRICS_STALE_AFTER" default:"10m"`
+	HealthStaleAfter  time.Duration `envconfig:"HEALTH_STALE_AFTER" default:"30m"`
+
+	WindStalePolicy string        `envconfig:"WIND_STALE_POLICY" default:"hold"`
+	WindStaleAfter  time.Duration `envconfig:"WIND_STALE_AFTER" default:"5m"`
+
+	WindDirectionMode string `envconfig:"WIND_DIRECTION_MODE" default:"raw"`
+
+	// OmitCalmDirection leaves wind direction out while the wind speed is
+	// zero. Direction is undefined in a calm and some sensors report a
+	// leftover or default heading.
+	OmitCalmDirection bool `envconfig:"OMIT_CALM_DIRECTION" default:"false"`
+
+	// MaxStations caps how many distinct id/channel pairs are tracked
+	MaxStations int `envconfig:"MAX_STATIONS" default:"16"`
+
+	// MaxModels caps the distinct model labels on
+	// weather_model_messages_total; further models are counted as "other"
+	MaxModels int `envconfig:"MAX_MODELS" default:"32"`
+
+	// StationUp emits weather_station_up per station, 0 once a station has
+	// been silent for longer than StationTTL
+	StationUp  bool          `envconfig:"STATION_UP" default:"false"`
+	StationTTL time.Duration `envconfig:"STATION_TTL" default:"5m"`
+
+	Units string `envconfig:"UNITS" default:"imperial"`
+
+	// TemperatureHistogram adds a weather_temperature_fahrenheit histogram of
+	// every reading, bucketed by TemperatureBuckets, for quantiles over time.
+	// Off by default as each bucket is its own series.
+	TemperatureHistogram bool      `envconfig:"TEMPERATURE_HISTOGRAM" default:"false"`
+	TemperatureBuckets   []float64 `envconfig:"TEMPERATURE_BUCKETS" default:"0,10,20,30,40,50,60,70,80,90,100"`
+
+	// AltitudeM is the station's height above sea level, used to derive
+	// weather_pressure_sealevel_hpa
+	AltitudeM float64 `envconfig:"ALTITUDE_M" default:"0"`
+
+	// MetarStation is the identifier /metar reports as the station
+	MetarStation string `envconfig:"METAR_STATION" default:"XXXX"`
+
+	// SummaryInterval is how often current conditions are logged. Zero
+	// disables the summary.
+	SummaryInterval time.Duration `envconfig:"SUMMARY_INTERVAL" default:"15m"`
+
+	// OutputInterval writes the conditions to the sinks (history,
+	// remote_write, statsd, MQTT publish) on this fixed cadence instead of
+	// on every message. A tick with no new reading repeats the last one,
+	// marked held. Zero writes on every message.
+	OutputInterval time.Duration `envconfig:"OUTPUT_INTERVAL" default:"0"`
+
+	// RainDeadband is the largest drop in the rain counter treated as noise
+	// rather than a reset
+	RainDeadband float32 `envconfig:"RAIN_DEADBAND" default:"0.02"`
+
+	// RainResetTime is the local time of day, HH:MM, at which the daily
+	// rain total starts over
+	RainResetTime string `envconfig:"RAIN_RESET_TIME" default:"00:00"`
+
+	// StateFile keeps the latest conditions, daily rain, daily high/low and
+	// trend window across restarts, saved every StateSaveInterval and on
+	// shutdown
+	StateFile         string        `envconfig:"STATE_FILE"`
+	StateSaveInterval time.Duration `envconfig:"STATE_SAVE_INTERVAL" default:"1m"`
+
+	// ConfigEndpoint serves the effective config, secrets redacted, at
+	// /config
+	ConfigEndpoint bool `envconfig:"CONFIG_ENDPOINT" default:"false"`
+
+	// Dashboard serves a minimal HTML page at /dashboard
+	Dashboard bool `envconfig:"DASHBOARD" default:"true"`
+
+	// HistoryFile enables the sample history behind /rain/daily
+	HistoryFile string `envconfig:"HISTORY_FILE"`
+
+	// UserAgent is sent on outbound requests such as remote_write. Empty
+	// means weather-station/<version>.
+	UserAgent string `envconfig:"USER_AGENT"`
+
+	// HTTP server timeouts. Zero disables a timeout.
+	HTTPReadTimeout  time.Duration `envconfig:"HTTP_READ_TIMEOUT" default:"10s"`
+	HTTPWriteTimeout time.Duration `envconfig:"HTTP_WRITE_TIMEOUT" default:"30s"`
+	HTTPIdleTimeout  time.Duration `envconfig:"HTTP_IDLE_TIMEOUT" default:"2m"`
+}
+
+func (c Config) Validate() error {
+	if err := c.AlertConfig.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.RemoteWriteConfig.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.WebhookConfig.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.PairConfig.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.MQTTPublishConfig.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.DecimationConfig.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.ComfortConfig.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.SunConfig.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.TimezoneConfig.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.SequenceConfig.Validate(); err != nil {
+		return err
+	}
+
+	if c.MetricsStaleAfter <= 0 {
+		return fmt.Errorf("METRICS_STALE_AFTER must be positive, got %s", c.MetricsStaleAfter)
+	}
+
+	if c.HealthStaleAfter <= 0 {
+		return fmt.Errorf("HEALTH_STALE_AFTER must be positive, got %s", c.HealthStaleAfter)
+	}
+
+	if c.TrendWindow <= 0 {
+		return fmt.Errorf("TREND_WINDOW must be positive, got %s", c.TrendWindow)
+	}
+
+	if c.StatsdConfig.Interval < 0 {
+		return fmt.Errorf("STATSD_INTERVAL must not be negative, got %s", c.StatsdConfig.Interval)
+	}
+
+	if c.BasePath != "" && (!strings.HasPrefix(c.BasePath, "/") || strings.HasSuffix(c.BasePath, "/")) {
+		return fmt.Errorf("BASE_PATH must start and not end with /, got %q", c.BasePath)
+	}
+
+	if !strings.HasPrefix(c.MetricsPath, "/") {
+		return fmt.Errorf("METRICS_PATH must start with /, got %q", c.MetricsPath)
+	}
+
+	for _, name := range c.Metrics {
+		if !isKnownMetric(name) {
+			return fmt.Errorf("unknown metric %q in METRICS", name)
+		}
+	}
+
+	if c.MaxStations < 1 {
+		return fmt.Errorf("MAX_STATIONS must be at least 1, got %d", c.MaxStations)
+	}
+
+	if c.MaxModels < 1 {
+		return fmt.Errorf("MAX_MODELS must be at least 1, got %d", c.MaxModels)
+	}
+
+	if c.WindStalePolicy != WIND_HOLD_LAST && c.WindStalePolicy != WIND_MARK_STALE {
+		return fmt.Errorf("WIND_STALE_POLICY must be %q or %q, got %q",
+			WIND_HOLD_LAST, WIND_MARK_STALE, c.WindStalePolicy)
+	}
+
+	switch c.WindDirectionMode {
+	case WIND_DIRECTION_RAW, WIND_DIRECTION_SECTOR, WIND_DIRECTION_BOTH:
+	default:
+		return fmt.Errorf("WIND_DIRECTION_MODE must be %q, %q or %q, got %q",
+			WIND_DIRECTION_RAW, WIND_DIRECTION_SECTOR, WIND_DIRECTION_BOTH, c.WindDirectionMode)
+	}
+
+	if c.SummaryInterval < 0 {
+		return fmt.Errorf("SUMMARY_INTERVAL must not be negative, got %s", c.SummaryInterval)
+	}
+
+	if c.OutputInterval < 0 {
+		return fmt.Errorf("OUTPUT_INTERVAL must not be negative, got %s", c.OutputInterval)
+	}
+
+	if c.PairMessages && c.OutputInterval > 0 {
+		return fmt.Errorf("PAIR_MESSAGES can't be used with OUTPUT_INTERVAL, which already writes on its own cadence")
+	}
+
+	if c.StateFile != "" && c.StateSaveInterval <= 0 {
+		return fmt.Errorf("STATE_SAVE_INTERVAL must be positive, got %s", c.StateSaveInterval)
+	}
+
+	if c.RainDeadband < 0 {
+		return fmt.Errorf("RAIN_DEADBAND must not be negative, got %v", c.RainDeadband)
+	}
+
+	if _, err := weathermetrics.ParseResetTime(c.RainResetTime); err != nil {
+		return fmt.Errorf("RAIN_RESET_TIME: %w", err)
+	}
+
+	if c.AltitudeM < -500 || c.AltitudeM > 9000 {
+		return fmt.Errorf("ALTITUDE_M must be between -500 and 9000, got %v", c.AltitudeM)
+	}
+
+	if c.HTTPReadTimeout < 0 || c.HTTPWriteTimeout < 0 || c.HTTPIdleTimeout < 0 {
+		return fmt.Errorf("HTTP_READ_TIMEOUT, HTTP_WRITE_TIMEOUT and HTTP_IDLE_TIMEOUT must not be negative")
+	}
+
+	if c.Units != UNITS_IMPERIAL && c.Units != UNITS_SCIENTIFIC && c.Units != UNITS_BOTH {
+		return fmt.Errorf("UNITS must be %q, %q or %q, got %q",
+			UNITS_IMPERIAL, UNITS_SCIENTIFIC, UNITS_BOTH, c.Units)
+	}
+
+	if c.TemperatureHistogram {
+		if len(c.TemperatureBuckets) == 0 {
+			return fmt.Errorf("TEMPERATURE_BUCKETS must not be empty when TEMPERATURE_HISTOGRAM is set")
+		}
+
+		if c.Units == UNITS_BOTH {
+			return fmt.Errorf("TEMPERATURE_HISTOGRAM can't be used with UNITS=%q, which already has a weather_temperature_fahrenheit gauge", UNITS_BOTH)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"embed"
+	"log"
+	"net/http"
+)
+
+// The dashboard is a single dependency-free page that polls /conditions,
+// embedded so the binary stays self-contained
+//
+//go:embed static/dashboard.html
+var static embed.FS
+
+func DashboardHandler(w http.ResponseWriter, r *http.Request) {
+	page, err := static.ReadFile("static/dashboard.html")
+	if err != nil {
+		log.Printf("Could not read dashboard: %s", err)
+		http.Error(w, "dashboard unavailable", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(page)
+}
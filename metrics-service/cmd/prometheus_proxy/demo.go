@@ -0,0 +1,56 @@
+package main
+
+import (
+	"time"
+
+	weathermetrics "github.com/mckeowbc/weather-metrics"
+)
+
+/*
+ * Demo mode
+ *
+ * --demo feeds synthetic weather through the same setters MQTT messages go
+ * through, so every endpoint shows live looking data without a radio or a
+ * broker. Like the 5n1 it alternates temperature/humidity and wind/rain
+ * readings, one per tick. Time comes from the app clock and the weather
+ * from a seeded generator, so a run can be reproduced.
+ */
+const (
+	DEMO_ID      = 65534
+	DEMO_CHANNEL = "demo"
+)
+
+// RunDemo injects a synthetic reading every interval until stop is closed
+func (app *App) RunDemo(interval time.Duration, seed int64, stop <-chan struct{}) {
+	weather := weathermetrics.NewSyntheticWeather(seed)
+	ticker := app.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	tempHumidity := true
+	for {
+		select {
+		case <-ticker.C():
+			app.demoReading(weather, tempHumidity)
+			tempHumidity = !tempHumidity
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (app *App) demoReading(weather *weathermetrics.SyntheticWeather, tempHumidity bool) {
+	now := app.clock.Now().In(app.TZ)
+
+	if tempHumidity {
+		tempF, humidity := weather.TempHumidity(now)
+		app.SetTempHumidityConditions(
+			weathermetrics.SyntheticTempHumidityMeasurement(now, DEMO_ID, DEMO_CHANNEL, tempF, humidity))
+		return
+	}
+
+	wind, gust, direction, rain := weather.WindRain()
+	measurement := weathermetrics.SyntheticWindRainMeasurement(now, DEMO_ID, DEMO_CHANNEL, wind, direction, rain)
+	measurement.WindGust = gust
+	measurement.HasGust = true
+	app.SetWindRainConditions(measurement)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	weathermetrics "github.com/mckeowbc/weather-metrics"
+)
+
+// runDemo runs demo mode on app's fake clock for two ticks, one reading of
+// each kind, and returns the conditions it left
+func runDemo(t *testing.T, app *App, clock *weathermetrics.FakeClock, seed int64) weathermetrics.CurrentConditions {
+	t.Helper()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go app.RunDemo(18*time.Second, seed, stop)
+	waitFor(t, "demo ticker", func() bool { return clock.Tickers() == 1 })
+
+	clock.Advance(18 * time.Second)
+	waitFor(t, "temperature/humidity reading", func() bool { return app.GetCurrentConditions().Humidity > 0 })
+
+	clock.Advance(18 * time.Second)
+	waitFor(t, "wind/rain reading", func() bool { return app.GetCurrentConditions().WindGust != nil })
+
+	return app.GetCurrentConditions()
+}
+
+func TestDemoPopulatesConditions(t *testing.T) {
+	app, clock := newTestApp(t, nil)
+	c := runDemo(t, app, clock, 1)
+
+	if c.ID != DEMO_ID || c.Channel != DEMO_CHANNEL {
+		t.Errorf("conditions from %d/%s, want the demo station", c.ID, c.Channel)
+	}
+	if c.Temp < -40 || c.Temp > 140 || c.Humidity <= 0 || c.Humidity > 100 {
+		t.Errorf("implausible demo weather %+v", c)
+	}
+
+	// Readings are stamped by the app clock, not the wall clock
+	if want := clock.Now().Format(weathermetrics.RTL433_TIME_FORMAT); c.Timestamp != want {
+		t.Errorf("timestamp %q, want the fake clock's %q", c.Timestamp, want)
+	}
+
+	w := httptest.NewRecorder()
+	app.ConditionsHandler(w, httptest.NewRequest("GET", "/conditions", nil))
+	var resp struct {
+		Observed map[string]any `json:"observed"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"temperature_F", "humidity", "wind_avg_km_h", "wind_max_km_h", "wind_dir_deg", "rain_in"} {
+		if _, ok := resp.Observed[field]; !ok {
+			t.Errorf("/conditions missing %s: %s", field, w.Body)
+		}
+	}
+
+	body := scrape(t, app)
+	for _, series := range []string{"temperature", "humidity", "wind_speed", "weather_wind_gust"} {
+		metricValue(t, body, series)
+	}
+}
+
+func TestDemoIsReproducible(t *testing.T) {
+	first, firstClock := newTestApp(t, nil)
+	second, secondClock := newTestApp(t, nil)
+
+	a := runDemo(t, first, firstClock, 42)
+	b := runDemo(t, second, secondClock, 42)
+	if a.Temp != b.Temp || a.Humidity != b.Humidity || a.WindSpeed != b.WindSpeed ||
+		*a.WindGust != *b.WindGust || a.WindDirection != b.WindDirection || a.RainInches != b.RainInches {
+		t.Errorf("same seed gave %+v and %+v", a, b)
+	}
+}
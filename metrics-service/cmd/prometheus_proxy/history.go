@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	weathermetrics "github.com/mckeowbc/weather-metrics"
+)
+
+const MAX_RAIN_DAYS = 366
+
+// EXPORT_DEFAULT_RANGE is how far back /export.csv goes without a from
+const EXPORT_DEFAULT_RANGE = 24 * time.Hour
+
+// DailyRainHandler serves /rain/daily?days=N, the rain total for each of the
+// last N rain days in the configured timezone, starting at RAIN_RESET_TIME
+func (app *App) DailyRainHandler(w http.ResponseWriter, r *http.Request) {
+	if app.history == nil {
+		http.Error(w, "history is not enabled, set HISTORY_FILE", http.StatusNotFound)
+		return
+	}
+
+	days := 7
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > MAX_RAIN_DAYS {
+			http.Error(w, "days must be between 1 and 366", http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+
+	now := app.clock.Now()
+	rain := weathermetrics.NewRainAccumulator(app.TZ, app.rainDeadband, app.rainReset)
+
+	// Start a day early so the first day's total has a baseline
+	from := rain.DayStart(now).AddDate(0, 0, -days)
+
+	samples := []weathermetrics.HistorySample{}
+	err := app.history.Each(from, now.Add(time.Second), func(s weathermetrics.HistorySample) error {
+		samples = append(samples, s)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Could not read history: %s", err)
+		http.Error(w, "could not read history", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	totals := weathermetrics.DailyRainTotals(samples, rain, days, now)
+	if err := json.NewEncoder(w).Encode(totals); err != nil {
+		log.Printf("Could not encode daily rain: %s", err)
+	}
+}
+
+// ExportCSVHandler serves /export.csv?from=...&to=...&columns=..., the stored
+// samples in [from, to) as CSV with a header row. from and to are RFC 3339
+// and default to the last day; columns is a comma separated subset of
+// weathermetrics.ExportColumns. Rows are written as the history file is
+// read, so a long range isn't held in memory.
+func (app *App) ExportCSVHandler(w http.ResponseWriter, r *http.Request) {
+	if app.history == nil {
+		http.Error(w, "history is not enabled, set HISTORY_FILE", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	to := app.clock.Now().Add(time.Second)
+	if v := query.Get("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "to must be an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+		to = t
+	}
+
+	from := to.Add(-EXPORT_DEFAULT_RANGE)
+	if v := query.Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "from must be an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+		from = t
+	}
+
+	columns := weathermetrics.ExportColumns
+	if v := query.Get("columns"); v != "" {
+		columns = strings.Split(v, ",")
+		if err := weathermetrics.CheckExportColumns(columns); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="export.csv"`)
+	w.WriteHeader(http.StatusOK)
+
+	out := csv.NewWriter(w)
+	if err := out.Write(columns); err != nil {
+		log.Printf("Could not write export: %s", err)
+		return
+	}
+
+	err := app.history.Each(from, to, func(s weathermetrics.HistorySample) error {
+		return out.Write(weathermetrics.ExportRow(s, columns))
+	})
+	if err != nil {
+		// Too late for an error status, the header has gone
+		log.Printf("Could not export history: %s", err)
+	}
+
+	out.Flush()
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	weathermetrics "github.com/mckeowbc/weather-metrics"
+)
+
+func approxEqual(a, b float32) bool {
+	return math.Abs(float64(a-b)) < 0.01
+}
+
+func TestDailyRainHandlerUsesResetTime(t *testing.T) {
+	app, clock := newTestApp(t, map[string]string{
+		"WEATHER_HISTORY_FILE":    filepath.Join(t.TempDir(), "history.jsonl"),
+		"WEATHER_RAIN_RESET_TIME": "09:00",
+	})
+	t.Cleanup(func() { app.history.Close() })
+	at := func(day, hour, minute int) time.Time { return time.Date(2024, 6, day, hour, minute, 0, 0, app.TZ) }
+
+	for _, s := range []struct {
+		at   time.Time
+		rain float32
+	}{
+		{at(0, 10, 0), 1.00}, // 31 May
+		{at(1, 1, 0), 1.05},
+		{at(1, 8, 0), 1.10},
+		{at(1, 9, 30), 1.10},
+		{at(1, 11, 0), 1.25},
+	} {
+		app.history.Write(weathermetrics.CurrentConditions{RainInches: s.rain}, s.at)
+	}
+
+	// The clock is at noon on 1 June, so 1 June's rain day began at 09:00
+	if !clock.Now().Equal(at(1, 12, 0)) {
+		t.Fatalf("clock at %s", clock.Now())
+	}
+
+	w := httptest.NewRecorder()
+	app.DailyRainHandler(w, httptest.NewRequest("GET", "/rain/daily?days=2", nil))
+
+	var totals []weathermetrics.DailyRain
+	if err := json.Unmarshal(w.Body.Bytes(), &totals); err != nil {
+		t.Fatalf("%s: %s", err, w.Body)
+	}
+
+	want := []weathermetrics.DailyRain{
+		{Date: "2024-05-31", Inches: 0.10},
+		{Date: "2024-06-01", Inches: 0.15},
+	}
+	if len(totals) != len(want) {
+		t.Fatalf("got %v, want %v", totals, want)
+	}
+	for i := range want {
+		if totals[i].Date != want[i].Date || !approxEqual(totals[i].Inches, want[i].Inches) {
+			t.Errorf("day %d = %+v, want %+v", i, totals[i], want[i])
+		}
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	app, _ := newTestApp(t, map[string]string{
+		"WEATHER_HISTORY_FILE": filepath.Join(t.TempDir(), "history.jsonl"),
+	})
+	t.Cleanup(func() { app.history.Close() })
+	at := func(day, hour int) time.Time { return time.Date(2024, 6, day, hour, 0, 0, 0, time.UTC) }
+
+	gust := float32(20)
+	app.history.Write(weathermetrics.CurrentConditions{Temp: 60, Humidity: 80}, at(1, 8))
+	app.history.Write(weathermetrics.CurrentConditions{Temp: 65.5, Humidity: 70, WindSpeed: 12, WindGust: &gust, WindDirection: 90, RainInches: 0.25, Battery: 1}, at(1, 9))
+	app.history.Write(weathermetrics.CurrentConditions{Temp: 70, Humidity: 60}, at(1, 10))
+
+	export := func(query string) (*httptest.ResponseRecorder, [][]string) {
+		w := httptest.NewRecorder()
+		app.ExportCSVHandler(w, httptest.NewRequest("GET", "/export.csv?"+query, nil))
+		if w.Code != http.StatusOK {
+			return w, nil
+		}
+		records, err := csv.NewReader(w.Body).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return w, records
+	}
+
+	w, records := export("from=2024-06-01T08:30:00Z&to=2024-06-01T10:00:00Z")
+	if got := w.Header().Get("Content-Type"); got != "text/csv" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	want := [][]string{
+		weathermetrics.ExportColumns,
+		{"2024-06-01T09:00:00Z", "65.5", "70", "", "12", "20", "90", "0.25", "1"},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("got %q, want %q", records, want)
+	}
+
+	_, records = export("from=2024-06-01T00:00:00Z&to=2024-06-02T00:00:00Z&columns=time,temperature_F")
+	want = [][]string{
+		{"time", "temperature_F"},
+		{"2024-06-01T08:00:00Z", "60"},
+		{"2024-06-01T09:00:00Z", "65.5"},
+		{"2024-06-01T10:00:00Z", "70"},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("got %q, want %q", records, want)
+	}
+
+	if w, _ := export("columns=time,dew_point"); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown column gave %d, want 400", w.Code)
+	}
+	if w, _ := export("from=yesterday"); w.Code != http.StatusBadRequest {
+		t.Errorf("bad from gave %d, want 400", w.Code)
+	}
+}
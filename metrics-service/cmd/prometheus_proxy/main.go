@@ -1,56 +1,126 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
+	"maps"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/kelseyhightower/envconfig"
 	weathermetrics "github.com/mckeowbc/weather-metrics"
 )
 
-func weatherPubHandler(app *App) mqtt.MessageHandler {
+func weatherPubHandler(app *App, conf weathermetrics.MQTTConfig) mqtt.MessageHandler {
 	return func(client mqtt.Client, msg mqtt.Message) {
-		log.Printf("Received weather message: %s from topic: %s\n", msg.Payload(), msg.Topic())
-
-		var windRainMeasurement weathermetrics.WindRainMeasurement
+		start := app.clock.Now()
+		defer func() {
+			app.processing.Observe(app.clock.Now().Sub(start).Seconds())
+		}()
 
-		if err := json.Unmarshal(msg.Payload(), &windRainMeasurement); err != nil {
-			log.Printf("Could not decode json data: %s", err)
+		if weathermetrics.EmptyPayload(msg.Payload()) {
+			log.Printf("Ignoring empty message from topic: %s", msg.Topic())
 			return
 		}
 
-		if windRainMeasurement.MessageType == weathermetrics.WIND_RAIN_MESSAGE {
-			app.SetWindRainConditions(windRainMeasurement)
+		if conf.PayloadTooLarge(msg.Payload()) {
+			log.Printf("WARNING: dropping %d byte message from topic %s, over MQTT_MAX_PAYLOAD_SIZE", len(msg.Payload()), msg.Topic())
+			app.MQTTStats.ObserveOversized()
 			return
 		}
 
-		var tempHumidityMeasurement weathermetrics.TempHumidityMeasurement
-		if err := json.Unmarshal(msg.Payload(), &tempHumidityMeasurement); err != nil {
+		log.Printf("Received weather message: %s from topic: %s\n", msg.Payload(), msg.Topic())
+
+		events, err := weathermetrics.DecodePayload(msg.Payload(), conf.PayloadKey)
+		if err != nil {
 			log.Printf("Could not decode json data: %s", err)
 			return
 		}
 
-		if tempHumidityMeasurement.MessageType == weathermetrics.TEMP_HUMIDITY_MESSAGE {
-			app.SetTempHumidityConditions(tempHumidityMeasurement)
+		source := weathermetrics.TopicSegment(msg.Topic(), conf.TopicIDSegment)
+		for _, event := range events {
+			handleEvent(app, event, source, conf.InferMessageType)
+		}
+	}
+}
+
+// handleEvent processes one reading. source is the topic-derived device
+// identifier, used only when the payload has no id. inferType routes
+// payloads without a message_type by their fields.
+func handleEvent(app *App, payload []byte, source string, inferType bool) {
+	var windRainMeasurement weathermetrics.WindRainMeasurement
+
+	if err := json.Unmarshal(payload, &windRainMeasurement); err != nil {
+		log.Printf("Could not decode json data: %s", err)
+		return
+	}
+
+	app.ObserveModel(windRainMeasurement.Model)
+
+	messageType := windRainMeasurement.MessageType
+	if messageType == 0 && inferType {
+		messageType = weathermetrics.InferMessageType(payload)
+	}
+
+	if messageType == weathermetrics.WIND_RAIN_MESSAGE {
+		windRainMeasurement.MessageType = messageType
+		if windRainMeasurement.ID == 0 {
+			windRainMeasurement.Source = source
+		}
+		app.ObserveMic(windRainMeasurement.Mic)
+		if err := app.validator.CheckTimestamp(windRainMeasurement.Timestamp, app.TZ, app.clock.Now()); err != nil {
+			log.Printf("Rejecting measurement: %s", err)
+			return
+		}
+		if err := app.validator.ValidateWindRain(&windRainMeasurement); err != nil {
+			log.Printf("Rejecting measurement: %s", err)
 			return
 		}
+		app.SetWindRainConditions(windRainMeasurement)
+		return
+	}
 
-		log.Printf("Unrecognized message type")
+	var tempHumidityMeasurement weathermetrics.TempHumidityMeasurement
+	if err := json.Unmarshal(payload, &tempHumidityMeasurement); err != nil {
+		log.Printf("Could not decode json data: %s", err)
+		return
 	}
+
+	if messageType == weathermetrics.TEMP_HUMIDITY_MESSAGE {
+		tempHumidityMeasurement.MessageType = messageType
+		if tempHumidityMeasurement.ID == 0 {
+			tempHumidityMeasurement.Source = source
+		}
+		app.ObserveMic(tempHumidityMeasurement.Mic)
+		if err := app.validator.CheckTimestamp(tempHumidityMeasurement.Timestamp, app.TZ, app.clock.Now()); err != nil {
+			log.Printf("Rejecting measurement: %s", err)
+			return
+		}
+		if err := app.validator.ValidateTempHumidity(&tempHumidityMeasurement); err != nil {
+			log.Printf("Rejecting measurement: %s", err)
+			return
+		}
+		app.SetTempHumidityConditions(tempHumidityMeasurement)
+		return
+	}
+
+	log.Printf("Unrecognized message type")
 }
 
 /*
  * Middleware
  */
 
-func logger(next func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+func logger(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		log.Printf("%s %s %s", r.RequestURI, r.RemoteAddr, r.UserAgent())
 		next(w, r)
@@ -60,33 +130,363 @@ func logger(next func(http.ResponseWriter, *http.Request)) func(http.ResponseWri
 type App struct {
 	M                 *sync.Mutex
 	currentConditions weathermetrics.CurrentConditions
+	battery           *weathermetrics.BatteryMonitor
+	alerts            *weathermetrics.AlertLimiter
+	enabledMetrics    map[string]bool
+	windStalePolicy   string
+	windStaleAfter    time.Duration
+	windDirectionMode string
+	omitCalmDirection bool
+	comfort           weathermetrics.ComfortConfig
+	sun               weathermetrics.SunConfig
+	outputInterval    time.Duration
+	metarStation      string
+	effectiveConfig   map[string]string
+	sequence          weathermetrics.SequenceConfig
+	updatedSinceTick  bool
+	windUpdated       time.Time
+	MQTTStats         *weathermetrics.ConnectionStats
+	stations          *weathermetrics.StationTracker
+	units             string
+	altitudeM         float64
+	rain              *weathermetrics.RainAccumulator
+	rainDeadband      float32
+	rainReset         time.Duration
+	decimator         *weathermetrics.Decimator
+	extremes          *weathermetrics.DailyExtremes
+	clock             weathermetrics.Clock
+	stationUp         bool
+	stationTTL        time.Duration
+	sinks             []weathermetrics.Sink
+	history           *weathermetrics.HistoryStore
+	TZ                *time.Location
+	validator         *weathermetrics.Validator
+	clockSkew         *time.Duration
+	trend             *weathermetrics.TemperatureTrend
+	lastUpdate        time.Time
+	metricsStaleAfter time.Duration
+	healthStaleAfter  time.Duration
+	micCounts         map[string]int64
+	modelCounts       map[string]int64
+	maxModels         int
+
+	// processing times each MQTT message from receipt through the last
+	// sink write
+	processing *weathermetrics.Histogram
+
+	// startTime is when NewApp ran, for weather_process_start_time_seconds.
+	// Uptime is time() minus it in PromQL.
+	startTime time.Time
+
+	// pairer holds sink writes back until both halves of a transmission
+	// cycle are in; nil unless PAIR_MESSAGES is set
+	pairer *weathermetrics.MessagePairer
+
+	// temperatureHistogram buckets every temperature reading; nil unless
+	// TEMPERATURE_HISTOGRAM is set
+	temperatureHistogram *weathermetrics.Histogram
+
+	// remoteWrite is kept for weather_remote_write_dropped_total; nil
+	// unless REMOTE_WRITE_URL is set
+	remoteWrite *weathermetrics.RemoteWriteSink
 }
 
-func NewApp() *App {
+func NewApp(conf Config) (*App, error) {
+	timezone, err := conf.Location()
+	if err != nil {
+		return nil, err
+	}
+
+	validator, err := weathermetrics.NewValidator(conf.ValidationConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	rainReset, err := weathermetrics.ParseResetTime(conf.RainResetTime)
+	if err != nil {
+		return nil, err
+	}
+
 	var mutex sync.Mutex
-	app := App{M: &mutex}
+	app := App{
+		M:                 &mutex,
+		battery:           weathermetrics.NewBatteryMonitor(conf.BatteryHysteresis),
+		alerts:            weathermetrics.NewAlertLimiter(conf.AlertCooldown),
+		enabledMetrics:    make(map[string]bool),
+		windStalePolicy:   conf.WindStalePolicy,
+		windStaleAfter:    conf.WindStaleAfter,
+		windDirectionMode: conf.WindDirectionMode,
+		omitCalmDirection: conf.OmitCalmDirection,
+		comfort:           conf.ComfortConfig,
+		sun:               conf.SunConfig,
+		outputInterval:    conf.OutputInterval,
+		metarStation:      conf.MetarStation,
+		sequence:          conf.SequenceConfig,
+		MQTTStats:         &weathermetrics.ConnectionStats{},
+		stations:          weathermetrics.NewStationTracker(conf.MaxStations),
+		units:             conf.Units,
+		altitudeM:         conf.AltitudeM,
+		rain:              weathermetrics.NewRainAccumulator(timezone, conf.RainDeadband, rainReset),
+		rainDeadband:      conf.RainDeadband,
+		rainReset:         rainReset,
+		decimator:         weathermetrics.NewDecimator(conf.DecimationConfig),
+		extremes:          weathermetrics.NewDailyExtremes(timezone),
+		clock:             weathermetrics.RealClock{},
+		stationUp:         conf.StationUp,
+		stationTTL:        conf.StationTTL,
+		TZ:                timezone,
+		validator:         validator,
+		trend:             weathermetrics.NewTemperatureTrend(conf.TrendConfig),
+		metricsStaleAfter: conf.MetricsStaleAfter,
+		healthStaleAfter:  conf.HealthStaleAfter,
+		micCounts:         make(map[string]int64),
+		modelCounts:       make(map[string]int64),
+		maxModels:         conf.MaxModels,
+		processing:        weathermetrics.NewHistogram(PROCESSING_BUCKETS),
+	}
+
+	app.startTime = app.clock.Now()
+
+	for _, name := range conf.Metrics {
+		app.enabledMetrics[name] = true
+	}
+
+	if conf.PairMessages {
+		app.pairer = weathermetrics.NewMessagePairer(conf.PairTimeout)
+	}
+
+	if conf.TemperatureHistogram {
+		app.temperatureHistogram = weathermetrics.NewHistogram(conf.TemperatureBuckets)
+	}
+
+	if conf.HistoryFile != "" {
+		history, err := weathermetrics.OpenHistoryStore(conf.HistoryFile)
+		if err != nil {
+			return nil, err
+		}
+		app.history = history
+		app.AddSink(history)
+	}
+
+	return &app, nil
+}
+
+// AddSink registers s to receive the conditions after every update. Sinks
+// must be added before messages start arriving.
+func (app *App) AddSink(s weathermetrics.Sink) {
+	app.sinks = append(app.sinks, s)
+}
+
+// writeSinks passes the conditions to the sinks after an update, unless they
+// are written on a fixed cadence instead
+func (app *App) writeSinks() {
+	if len(app.sinks) == 0 || app.outputInterval > 0 {
+		return
+	}
+
+	app.writeSinksAt(app.GetCurrentConditions(), app.clock.Now())
+}
+
+func (app *App) writeSinksAt(conditions weathermetrics.CurrentConditions, at time.Time) {
+	for _, s := range app.sinks {
+		s.Write(conditions, at)
+	}
+}
+
+// pairUp records a measurement's half of its station's cycle and reports
+// whether the sinks should be written: always when kept and pairing is off,
+// otherwise once the cycle completes with something kept. expired reports
+// that the station's previous cycle timed out with a kept half before
+// ExpirePairsEvery got to it, and should be written as it stood before this
+// measurement. Must be called with app.M held.
+func (app *App) pairUp(key weathermetrics.StationKey, half weathermetrics.MessageHalf, kept bool) (expired, write bool) {
+	if app.pairer == nil {
+		return false, kept
+	}
+
+	return app.pairer.Observe(key, half, kept, app.clock.Now())
+}
+
+// writeExpiredCycle writes the conditions from before the measurement that
+// replaced a timed-out cycle, unless the sinks are written on a fixed cadence
+func (app *App) writeExpiredCycle(prev weathermetrics.CurrentConditions) {
+	if len(app.sinks) == 0 || app.outputInterval > 0 {
+		return
+	}
+
+	app.writeSinksAt(prev, app.clock.Now())
+}
+
+// ExpirePairsEvery writes the sinks for any cycle whose other half didn't
+// arrive within PAIR_TIMEOUT, checking every interval until stop is closed
+func (app *App) ExpirePairsEvery(interval time.Duration, stop <-chan struct{}) {
+	ticker := app.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			app.M.Lock()
+			expired := app.pairer.Expire(app.clock.Now())
+			app.M.Unlock()
+
+			if expired > 0 {
+				app.writeSinks()
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// WriteSinksEvery writes the conditions to the sinks every interval until
+// stop is closed, whether or not a reading arrived since the last tick
+func (app *App) WriteSinksEvery(interval time.Duration, stop <-chan struct{}) {
+	ticker := app.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			app.M.Lock()
+			conditions := app.currentConditions
+			conditions.Held = !app.updatedSinceTick
+			app.updatedSinceTick = false
+			started := !app.lastUpdate.IsZero()
+			app.M.Unlock()
+
+			// Nothing to hold before the first reading
+			if started {
+				app.writeSinksAt(conditions, app.clock.Now())
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// observeTimestamp records how far the sensor's clock is ahead of ours. A
+// large steady skew points at a wrong clock or timezone on the rtl_433 host.
+// Must be called with app.M held.
+func (app *App) observeTimestamp(timestamp string) {
+	t, err := weathermetrics.ParseMessageTime(timestamp, app.TZ)
+	if err != nil {
+		log.Printf("could not parse timestamp %s: %s", timestamp, err)
+		return
+	}
+
+	skew := t.Sub(app.clock.Now())
+	app.clockSkew = &skew
+}
+
+// ObserveMic counts messages by the integrity check rtl_433 decoded them
+// with, before any REQUIRE_MIC filtering
+func (app *App) ObserveMic(mic string) {
+	if mic == "" {
+		mic = "none"
+	}
+
+	app.M.Lock()
+	app.micCounts[mic]++
+	app.M.Unlock()
+}
+
+// ObserveModel counts every decodable message by rtl_433 model, including
+// ones that are later ignored. Models beyond MAX_MODELS are counted as
+// "other" so a noisy RF neighbourhood can't grow the label set without bound.
+func (app *App) ObserveModel(model string) {
+	if model == "" {
+		model = "none"
+	}
+
+	app.M.Lock()
+	defer app.M.Unlock()
+
+	if _, ok := app.modelCounts[model]; !ok && len(app.modelCounts) >= app.maxModels {
+		model = "other"
+	}
+	app.modelCounts[model]++
+}
 
-	return &app
+// observeBattery must be called with app.M held
+func (app *App) observeBattery(batteryOK int) {
+	app.battery.Observe(batteryOK)
+
+	if app.battery.Low() {
+		if app.alerts.Fire("battery_low", app.clock.Now()) {
+			log.Printf("ALERT: battery low")
+		}
+		return
+	}
+
+	if app.alerts.Clear("battery_low") {
+		log.Printf("ALERT CLEARED: battery recovered")
+	}
 }
 
 func (app *App) SetTempHumidityConditions(measurement weathermetrics.TempHumidityMeasurement) {
 	app.M.Lock()
-	app.currentConditions.Timestamp = measurement.Timestamp
-	app.currentConditions.Temp = measurement.Temp
-	app.currentConditions.Humidity = measurement.Humidity
-	app.currentConditions.Battery = measurement.Battery
+	prev := app.currentConditions
+	app.currentConditions.ApplyTempHumidity(measurement)
+	kept := app.decimator.Filter(prev, &app.currentConditions, app.clock.Now(), "temperature", "humidity")
+	app.observeTimestamp(measurement.Timestamp)
+	app.lastUpdate = app.clock.Now()
+	app.trend.Add(app.clock.Now(), measurement.Temp)
+	app.extremes.Observe(measurement.Temp, app.clock.Now())
+	if app.temperatureHistogram != nil {
+		app.temperatureHistogram.Observe(float64(measurement.Temp))
+	}
+	app.updatedSinceTick = true
+	key := weathermetrics.StationKey{ID: measurement.ID, Channel: string(measurement.Channel), Source: measurement.Source}
+	expired, write := app.pairUp(key, weathermetrics.HALF_TEMP_HUMIDITY, kept)
+	app.stations.Update(key, measurement.Model, app.clock.Now(), func(c *weathermetrics.CurrentConditions) {
+		c.ApplyTempHumidity(measurement)
+	})
+	if measurement.Sequence != nil {
+		app.stations.ObserveSequence(key, *measurement.Sequence, app.clock.Now(), app.sequence)
+	}
+	app.observeBattery(measurement.Battery)
 	app.M.Unlock()
 
+	if expired {
+		app.writeExpiredCycle(prev)
+	}
+
+	// Nothing new for the sinks if decimation held back every field
+	if write {
+		app.writeSinks()
+	}
 }
 
 func (app *App) SetWindRainConditions(measurement weathermetrics.WindRainMeasurement) {
 	app.M.Lock()
-	app.currentConditions.Timestamp = measurement.Timestamp
-	app.currentConditions.Battery = measurement.Battery
-	app.currentConditions.WindDirection = measurement.WindDirection
-	app.currentConditions.WindSpeed = measurement.WindSpeed
-	app.currentConditions.RainInches = measurement.RainInches
+	prev := app.currentConditions
+	app.currentConditions.ApplyWindRain(measurement)
+	kept := app.decimator.Filter(prev, &app.currentConditions, app.clock.Now(), "wind", "rain")
+	app.observeTimestamp(measurement.Timestamp)
+	app.lastUpdate = app.clock.Now()
+	key := weathermetrics.StationKey{ID: measurement.ID, Channel: string(measurement.Channel), Source: measurement.Source}
+	expired, write := app.pairUp(key, weathermetrics.HALF_WIND_RAIN, kept)
+	app.stations.Update(key, measurement.Model, app.clock.Now(), func(c *weathermetrics.CurrentConditions) {
+		c.ApplyWindRain(measurement)
+	})
+	if measurement.Sequence != nil {
+		app.stations.ObserveSequence(key, *measurement.Sequence, app.clock.Now(), app.sequence)
+	}
+	app.windUpdated = app.clock.Now()
+	app.updatedSinceTick = true
+	if !measurement.RainMissing {
+		app.rain.Observe(measurement.RainInches, app.clock.Now())
+	}
+	app.observeBattery(measurement.Battery)
 	app.M.Unlock()
+
+	if expired {
+		app.writeExpiredCycle(prev)
+	}
+	if write {
+		app.writeSinks()
+	}
 }
 
 func (app *App) GetCurrentConditions() weathermetrics.CurrentConditions {
@@ -97,71 +497,392 @@ func (app *App) GetCurrentConditions() weathermetrics.CurrentConditions {
 	return m
 }
 
-func (app *App) MetricsHandler(w http.ResponseWriter, r *http.Request) {
+// appState is everything the renderers read, copied under a single lock so a
+// scrape racing an incoming message never mixes old and new readings
+type appState struct {
+	conditions  weathermetrics.CurrentConditions
+	batteryLow  bool
+	windUpdated time.Time
+	stations    []weathermetrics.Station
+	evictions   int64
+	clockSkew   *time.Duration
+	trend       int
+	lastUpdate  time.Time
+	micCounts   map[string]int64
+	modelCounts map[string]int64
+	dailyRain   float32
+
+	// Today's low and high, if there has been a reading today
+	dailyMin, dailyMax float32
+	haveExtremes       bool
+}
+
+func (app *App) state() appState {
+	app.M.Lock()
+	defer app.M.Unlock()
+
+	state := appState{
+		conditions:  app.currentConditions,
+		batteryLow:  app.battery.Low(),
+		windUpdated: app.windUpdated,
+		stations:    app.stations.Stations(),
+		evictions:   app.stations.Evictions(),
+		clockSkew:   app.clockSkew,
+		trend:       app.trend.Trend(app.clock.Now()),
+		lastUpdate:  app.lastUpdate,
+		micCounts:   maps.Clone(app.micCounts),
+		modelCounts: maps.Clone(app.modelCounts),
+		dailyRain:   app.rain.Daily(app.clock.Now()),
+	}
+	state.dailyMin, state.dailyMax, state.haveExtremes = app.extremes.Range(app.clock.Now())
+
+	return state
+}
+
+// persistedState snapshots everything worth keeping across a restart
+func (app *App) persistedState() weathermetrics.PersistedState {
+	app.M.Lock()
+	defer app.M.Unlock()
+
+	return weathermetrics.PersistedState{
+		SavedAt:    app.clock.Now(),
+		Conditions: app.currentConditions,
+		Rain:       app.rain.State(),
+		Extremes:   app.extremes.State(),
+		Trend:      app.trend.State(),
+	}
+}
+
+func (app *App) restoreState(s weathermetrics.PersistedState) {
+	app.M.Lock()
+	defer app.M.Unlock()
+
+	now := app.clock.Now()
+	app.currentConditions = s.Conditions
+	if s.Rain != nil {
+		app.rain.Restore(*s.Rain, now)
+	}
+	if s.Extremes != nil {
+		app.extremes.Restore(*s.Extremes, now)
+	}
+	app.trend.Restore(s.Trend, now)
+}
+
+// SaveStateEvery writes the state to path every interval until stop is closed
+func (app *App) SaveStateEvery(path string, interval time.Duration, stop <-chan struct{}) {
+	ticker := app.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			if err := weathermetrics.SaveState(path, app.persistedState()); err != nil {
+				log.Print(err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// stale reports whether a reading last updated at updated is older than limit
+func (app *App) stale(updated time.Time, limit time.Duration) bool {
+	return updated.IsZero() || app.clock.Now().Sub(updated) > limit
+}
+
+// windStale reports whether wind series should be withheld because only
+// temp/humidity messages have arrived for longer than the configured window
+func (app *App) windStale(updated time.Time) bool {
+	if app.windStalePolicy != WIND_MARK_STALE {
+		return false
+	}
+
+	return app.stale(updated, app.windStaleAfter)
+}
+
+// LogSummaries logs the current conditions every interval until stop is closed
+func (app *App) LogSummaries(interval time.Duration, stop <-chan struct{}) {
+	ticker := app.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			log.Printf("Current conditions: %s", app.GetCurrentConditions().Summary())
+		case <-stop:
+			return
+		}
+	}
+}
+
+// HealthHandler fails once no measurement has arrived for HEALTH_STALE_AFTER
+func (app *App) HealthHandler(w http.ResponseWriter, r *http.Request) {
+	if app.stale(app.state().lastUpdate, app.healthStaleAfter) {
+		http.Error(w, "no recent measurements", http.StatusServiceUnavailable)
+		return
+	}
+
 	w.Header().Set("Content-Type", "text/plain")
 	w.WriteHeader(http.StatusOK)
-	currentConditions := app.GetCurrentConditions()
-	fmt.Fprintf(w, "temperature %f\n"+
-		"humidity %f\n"+
-		"rain_in %f\n"+
-		"wind_direction %f\n"+
-		"wind_speed %f\n",
-		currentConditions.Temp,
-		currentConditions.Humidity,
-		currentConditions.RainInches,
-		currentConditions.WindDirection,
-		currentConditions.WindSpeed,
-	)
+	fmt.Fprintln(w, "ok")
+}
+
+func (app *App) ConditionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	resp := weathermetrics.NewConditionsResponse(app.GetCurrentConditions(), app.TZ, app.comfort)
+	if app.sun.Enabled() {
+		sun := weathermetrics.SunTimesOn(app.clock.Now().In(app.TZ), *app.sun.Latitude, *app.sun.Longitude)
+		resp.Sun = &sun
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("Could not encode conditions: %s", err)
+	}
+}
+
+// ConfigHandler serves the effective config with secrets redacted
+func (app *App) ConfigHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(app.effectiveConfig); err != nil {
+		log.Printf("Could not encode config: %s", err)
+	}
+}
+
+func VersionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(weathermetrics.GetBuildInfo()); err != nil {
+		log.Printf("Could not encode build info: %s", err)
+	}
 }
 
 func main() {
+	selfTest := flag.Bool("selftest", false, "Publish a test reading, wait for it to be processed and exit")
+	selfTestTimeout := flag.Duration("selftest-timeout", 30*time.Second, "How long --selftest waits")
+	demo := flag.Bool("demo", false, "Serve synthetic weather instead of connecting to MQTT")
+	demoInterval := flag.Duration("demo-interval", 18*time.Second, "How often --demo injects a reading")
+	demoSeed := flag.Int64("demo-seed", 1, "Random seed for --demo's weather")
+	flag.Parse()
+
+	if *demo && *selfTest {
+		log.Fatal("Error: --demo and --selftest can't be used together")
+	}
+
+	buildInfo := weathermetrics.GetBuildInfo()
+	log.Printf("prometheus_proxy %s (commit %s, built %s)",
+		buildInfo.Version, buildInfo.Commit, buildInfo.BuildTime)
+
+	if err := weathermetrics.LoadConfigFile(); err != nil {
+		log.Fatal(err)
+	}
+
 	var conf weathermetrics.MQTTConfig
 	if err := envconfig.Process("weather", &conf); err != nil {
 		log.Fatal(err)
 	}
 
+	if err := conf.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
 	if len(conf.Username) > 0 && len(conf.Password) == 0 ||
 		len(conf.Username) == 0 && len(conf.Password) > 0 {
 		log.Fatal("Error: Must specify both username and password")
 	}
 
-	client, _ := weathermetrics.NewMQTTClient(conf)
+	var proxyConf Config
+	if err := envconfig.Process("weather", &proxyConf); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := proxyConf.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	effectiveConfig := weathermetrics.EffectiveConfig("", conf)
+	maps.Copy(effectiveConfig, weathermetrics.EffectiveConfig("", proxyConf))
+	weathermetrics.LogEffectiveConfig(effectiveConfig)
 
-	app := NewApp()
+	if len(conf.Topic) == 0 {
+		if proxyConf.RequireTopic {
+			log.Fatal("Error: MQTT_TOPIC is empty, nothing to subscribe to")
+		}
+		log.Print("WARNING: MQTT_TOPIC is empty, no measurements will be received and metrics will stay empty")
+	}
 
-	log.Printf("Connecting to %s", fmt.Sprintf("tcp://%s", conf.MQTTServer))
+	if *selfTest || *demo {
+		// Keep synthetic readings out of history and downstream systems
+		proxyConf.HistoryFile = ""
+		proxyConf.RemoteWriteConfig.URL = ""
+		proxyConf.StatsdConfig.Addr = ""
+		proxyConf.WebhookConfig.URL = ""
+		proxyConf.MQTTPublishConfig.PublishTopic = ""
+		proxyConf.ModbusConfig.Addr = ""
+	}
 
-	if token := client.Connect(); token.Wait() && token.Error() != nil {
-		panic(token.Error())
+	app, err := NewApp(proxyConf)
+	if err != nil {
+		log.Fatal(err)
 	}
+	app.effectiveConfig = effectiveConfig
 
+	// stop is closed on shutdown; sinksDone waits for the sinks that
+	// buffer to send what they still hold
+	stop := make(chan struct{})
+	var sinksDone sync.WaitGroup
+
+	if proxyConf.StateFile != "" {
+		state, err := weathermetrics.LoadState(proxyConf.StateFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if state != nil {
+			log.Printf("Restoring state saved at %s", state.SavedAt.Format(time.RFC3339))
+			app.restoreState(*state)
+		}
+		go app.SaveStateEvery(proxyConf.StateFile, proxyConf.StateSaveInterval, stop)
+	}
+
+	if proxyConf.RemoteWriteConfig.Enabled() {
+		remoteWrite := weathermetrics.NewRemoteWriteSink(proxyConf.RemoteWriteConfig, app.clock, proxyConf.UserAgent)
+		app.AddSink(remoteWrite)
+		app.remoteWrite = remoteWrite
+		sinksDone.Add(1)
+		go func() {
+			defer sinksDone.Done()
+			remoteWrite.Run(stop)
+		}()
+	}
+
+	if proxyConf.StatsdConfig.Enabled() {
+		statsd, err := weathermetrics.NewStatsdSink(proxyConf.StatsdConfig, app.clock)
+		if err != nil {
+			log.Fatal(err)
+		}
+		app.AddSink(statsd)
+		if proxyConf.StatsdConfig.Interval > 0 {
+			sinksDone.Add(1)
+			go func() {
+				defer sinksDone.Done()
+				statsd.Run(stop)
+			}()
+		}
+	}
+
+	if proxyConf.WebhookConfig.Enabled() {
+		webhook := weathermetrics.NewWebhookSink(proxyConf.WebhookConfig, proxyConf.UserAgent)
+		app.AddSink(webhook)
+		sinksDone.Add(1)
+		go func() {
+			defer sinksDone.Done()
+			webhook.Run(stop)
+		}()
+	}
+
+	if proxyConf.ModbusConfig.Enabled() {
+		modbus := weathermetrics.NewModbusServer(app.GetCurrentConditions)
+		go func() {
+			log.Fatal(modbus.ListenAndServe(proxyConf.ModbusConfig.Addr))
+		}()
+	}
+
+	subs := []weathermetrics.Subscription{}
 	if len(conf.Topic) > 0 {
-		sub(client, conf.Topic, weatherPubHandler(app))
+		subs = append(subs, weathermetrics.Subscription{
+			Topic:   conf.Topic,
+			Handler: weatherPubHandler(app, conf),
+		})
+	}
+
+	client, _ := weathermetrics.NewMQTTClient(conf, app.MQTTStats, subs...)
+
+	if proxyConf.MQTTPublishConfig.Enabled() {
+		app.AddSink(weathermetrics.NewMQTTPublishSink(proxyConf.MQTTPublishConfig, client))
+	}
+
+	if *demo {
+		log.Printf("Demo mode: injecting synthetic readings every %s instead of connecting to MQTT", *demoInterval)
+		go app.RunDemo(*demoInterval, *demoSeed, stop)
+	} else {
+		log.Printf("Connecting to %s", strings.Join(conf.Brokers(), ", "))
+
+		token := client.Connect()
+		if *selfTest {
+			if err := runSelfTest(app, client, token, conf.Topic, *selfTestTimeout); err != nil {
+				log.Fatalf("Self-test failed: %s", err)
+			}
+			log.Print("Self-test passed")
+			client.Disconnect(250)
+			return
+		}
+
+		if token.Wait() && token.Error() != nil {
+			panic(token.Error())
+		}
 	}
 
-	http.HandleFunc("/metrics", logger(app.MetricsHandler))
+	if proxyConf.SummaryInterval > 0 {
+		go app.LogSummaries(proxyConf.SummaryInterval, stop)
+	}
 
-	log.Print("HTTP Listening on :8080")
-	err := http.ListenAndServe(":8080", nil)
-	log.Fatal(err)
+	if proxyConf.OutputInterval > 0 && len(app.sinks) > 0 {
+		go app.WriteSinksEvery(proxyConf.OutputInterval, stop)
+	}
+
+	if app.pairer != nil && len(app.sinks) > 0 {
+		go app.ExpirePairsEvery(proxyConf.PairTimeout/2, stop)
+	}
+
+	server := NewHTTPServer(NewServer(app, proxyConf), proxyConf)
+	listener, err := weathermetrics.ActivatedListener()
+	if err != nil {
+		log.Fatal(err)
+	}
+	go func() {
+		var err error
+		if listener != nil {
+			log.Printf("HTTP Listening on socket-activated %s", listener.Addr())
+			err = server.Serve(listener)
+		} else {
+			log.Printf("HTTP Listening on %s", server.Addr)
+			err = server.ListenAndServe()
+		}
+		if err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
 
 	// Wait for interrupt signal to gracefully shutdown the subscriber
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 	<-sigChan
 
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("HTTP shutdown: %s", err)
+	}
+	cancel()
+
+	close(stop)
+	sinksDone.Wait()
+
 	// Unsubscribe and disconnect
 	fmt.Println("Unsubscribing and disconnecting...")
 
+	if proxyConf.StateFile != "" {
+		if err := weathermetrics.SaveState(proxyConf.StateFile, app.persistedState()); err != nil {
+			log.Print(err)
+		}
+	}
+
+	if *demo {
+		return
+	}
+
 	if len(conf.Topic) > 0 {
 		client.Unsubscribe(conf.Topic)
 	}
 	client.Disconnect(250)
 
 }
-
-func sub(client mqtt.Client, topic string, handler mqtt.MessageHandler) {
-	token := client.Subscribe(topic, 1, handler)
-	token.Wait()
-	log.Printf("Subscribed to topic: %s", topic)
-}
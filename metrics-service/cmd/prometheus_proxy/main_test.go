@@ -0,0 +1,635 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+	weathermetrics "github.com/mckeowbc/weather-metrics"
+)
+
+// loadConfig processes and validates the proxy config from the defaults
+// plus env, with the timezone pinned to America/New_York
+func loadConfig(t *testing.T, env map[string]string) (Config, error) {
+	t.Helper()
+
+	t.Setenv("WEATHER_TZ", "America/New_York")
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+
+	var conf Config
+	if err := envconfig.Process("weather", &conf); err != nil {
+		return conf, err
+	}
+
+	return conf, conf.Validate()
+}
+
+// newTestApp builds an App from the defaults plus env, on a fake clock
+// starting at noon on 1 June 2024 in America/New_York
+func newTestApp(t *testing.T, env map[string]string) (*App, *weathermetrics.FakeClock) {
+	t.Helper()
+
+	conf, err := loadConfig(t, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app, err := NewApp(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock := weathermetrics.NewFakeClock(time.Date(2024, 6, 1, 12, 0, 0, 0, app.TZ))
+	app.clock = clock
+	app.startTime = clock.Now()
+
+	return app, clock
+}
+
+func tempHumidity(id int, tempF, humidity float32) weathermetrics.TempHumidityMeasurement {
+	return weathermetrics.TempHumidityMeasurement{
+		Model:       weathermetrics.SYNTHETIC_MODEL,
+		ID:          id,
+		Channel:     "A",
+		Temp:        tempF,
+		Humidity:    humidity,
+		Battery:     1,
+		MessageType: weathermetrics.TEMP_HUMIDITY_MESSAGE,
+	}
+}
+
+func windRain(id int, windKmh, windDir, rainIn float32) weathermetrics.WindRainMeasurement {
+	return weathermetrics.WindRainMeasurement{
+		Model:         weathermetrics.SYNTHETIC_MODEL,
+		ID:            id,
+		Channel:       "A",
+		WindSpeed:     windKmh,
+		WindDirection: windDir,
+		RainInches:    rainIn,
+		Battery:       1,
+		MessageType:   weathermetrics.WIND_RAIN_MESSAGE,
+	}
+}
+
+// scrape returns the /metrics body
+func scrape(t *testing.T, app *App) string {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	app.MetricsHandler(w, httptest.NewRequest("GET", "/metrics", nil))
+	return w.Body.String()
+}
+
+// metricLine returns the line for series, e.g. `temperature` or
+// `weather_station_up{id="1",channel="A"}`, and whether it was present
+func metricLine(body, series string) (string, bool) {
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(line, series+" ") {
+			return line, true
+		}
+	}
+
+	return "", false
+}
+
+// metricValue is the value of series in body, failing the test if it is
+// missing
+func metricValue(t *testing.T, body, series string) string {
+	t.Helper()
+
+	line, ok := metricLine(body, series)
+	if !ok {
+		t.Fatalf("%s missing from:\n%s", series, body)
+	}
+	return strings.TrimPrefix(line, series+" ")
+}
+
+// waitFor polls cond until it holds or a second passes
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// logBuffer collects log output written from other goroutines
+type logBuffer struct {
+	m   sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.buf.String()
+}
+
+func captureLog(t *testing.T) *logBuffer {
+	t.Helper()
+
+	buf := &logBuffer{}
+	prev := log.Writer()
+	log.SetOutput(buf)
+	t.Cleanup(func() { log.SetOutput(prev) })
+	return buf
+}
+
+func TestMain(m *testing.M) {
+	// Every message is logged; keep test output readable
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func TestLogSummariesReflectCurrentState(t *testing.T) {
+	app, clock := newTestApp(t, nil)
+	logs := captureLog(t)
+
+	app.SetTempHumidityConditions(tempHumidity(1, 71.5, 40))
+	app.SetWindRainConditions(windRain(1, 12.3, 270, 0.25))
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go app.LogSummaries(time.Minute, stop)
+	waitFor(t, "summary ticker", func() bool { return clock.Tickers() == 1 })
+
+	clock.Advance(time.Minute)
+	want := "Current conditions: temp 71.5F humidity 40% wind 12.3km/h from 270 deg rain 0.25in"
+	waitFor(t, "summary line", func() bool { return strings.Contains(logs.String(), want) })
+
+	app.SetTempHumidityConditions(tempHumidity(1, 65, 55))
+	clock.Advance(time.Minute)
+	waitFor(t, "updated summary line", func() bool {
+		return strings.Contains(logs.String(), "temp 65.0F humidity 55%")
+	})
+}
+
+func TestRequireMicFiltersMessages(t *testing.T) {
+	app, _ := newTestApp(t, map[string]string{"WEATHER_REQUIRE_MIC": "CRC"})
+
+	handleEvent(app, []byte(`{"model":"Acurite-5n1","id":1,"channel":"A","message_type":56,"temperature_F":60,"humidity":50,"mic":"CHECKSUM"}`), "", false)
+	handleEvent(app, []byte(`{"model":"Acurite-5n1","id":1,"channel":"A","message_type":56,"temperature_F":61,"humidity":50}`), "", false)
+	if line, ok := metricLine(scrape(t, app), "temperature"); ok {
+		t.Fatalf("message without a CRC accepted: %s", line)
+	}
+
+	handleEvent(app, []byte(`{"model":"Acurite-5n1","id":1,"channel":"A","message_type":56,"temperature_F":62,"humidity":50,"mic":"CRC"}`), "", false)
+
+	body := scrape(t, app)
+	if got := metricValue(t, body, "temperature"); got != "62.000000" {
+		t.Errorf("temperature = %s, want the CRC message's 62.000000", got)
+	}
+
+	// Every message is counted by mic, filtered or not
+	for series, want := range map[string]string{
+		`weather_messages_by_mic_total{mic="CHECKSUM"}`: "1",
+		`weather_messages_by_mic_total{mic="CRC"}`:      "1",
+		`weather_messages_by_mic_total{mic="none"}`:     "1",
+	} {
+		if got := metricValue(t, body, series); got != want {
+			t.Errorf("%s = %s, want %s", series, got, want)
+		}
+	}
+}
+
+// fakeMessage is an MQTT message as paho hands it to a handler
+type fakeMessage struct {
+	topic   string
+	payload []byte
+}
+
+func (m fakeMessage) Duplicate() bool   { return false }
+func (m fakeMessage) Qos() byte         { return 1 }
+func (m fakeMessage) Retained() bool    { return false }
+func (m fakeMessage) Topic() string     { return m.topic }
+func (m fakeMessage) MessageID() uint16 { return 0 }
+func (m fakeMessage) Payload() []byte   { return m.payload }
+func (m fakeMessage) Ack()              {}
+
+// slowSink takes d of fake time to write
+type slowSink struct {
+	clock *weathermetrics.FakeClock
+	d     time.Duration
+}
+
+func (s slowSink) Write(c weathermetrics.CurrentConditions, at time.Time) {
+	s.clock.Advance(s.d)
+}
+
+func TestMessageProcessingTimeRecorded(t *testing.T) {
+	app, clock := newTestApp(t, nil)
+	app.sinks = append(app.sinks, slowSink{clock: clock, d: 200 * time.Millisecond})
+	handler := weatherPubHandler(app, weathermetrics.MQTTConfig{})
+
+	handler(nil, fakeMessage{
+		topic:   "rtl_433/1/events",
+		payload: []byte(`{"model":"Acurite-5n1","id":1,"channel":"A","message_type":56,"temperature_F":60,"humidity":50}`),
+	})
+
+	body := scrape(t, app)
+	for series, want := range map[string]string{
+		"weather_message_processing_seconds_count":             "1",
+		"weather_message_processing_seconds_sum":               "0.200000",
+		`weather_message_processing_seconds_bucket{le="0.1"}`:  "0",
+		`weather_message_processing_seconds_bucket{le="0.25"}`: "1",
+	} {
+		if got := metricValue(t, body, series); got != want {
+			t.Errorf("%s = %s, want %s", series, got, want)
+		}
+	}
+
+	// Messages dropped early are timed too
+	handler(nil, fakeMessage{topic: "rtl_433/1/events"})
+	if got := metricValue(t, scrape(t, app), "weather_message_processing_seconds_count"); got != "2" {
+		t.Errorf("count after an empty message = %s, want 2", got)
+	}
+}
+
+func TestTopicSegmentIdentifiesPayloadsWithoutID(t *testing.T) {
+	app, _ := newTestApp(t, map[string]string{"WEATHER_STATION_UP": "true"})
+	handler := weatherPubHandler(app, weathermetrics.MQTTConfig{TopicIDSegment: 1})
+
+	handler(nil, fakeMessage{
+		topic:   "rtl_433/backyard/events",
+		payload: []byte(`{"model":"Acurite-5n1","channel":"A","message_type":56,"temperature_F":60,"humidity":50}`),
+	})
+	handler(nil, fakeMessage{
+		topic:   "rtl_433/garage/events",
+		payload: []byte(`{"model":"Acurite-5n1","channel":"A","message_type":56,"temperature_F":50,"humidity":50}`),
+	})
+
+	// A payload with an id keeps it, whatever the topic
+	handler(nil, fakeMessage{
+		topic:   "rtl_433/garage/events",
+		payload: []byte(`{"model":"Acurite-5n1","id":7,"channel":"A","message_type":56,"temperature_F":40,"humidity":50}`),
+	})
+
+	body := scrape(t, app)
+	for _, series := range []string{
+		`weather_station_up{id="0",channel="A",source="backyard"}`,
+		`weather_station_up{id="0",channel="A",source="garage"}`,
+		`weather_station_up{id="7",channel="A"}`,
+	} {
+		metricValue(t, body, series)
+	}
+}
+
+func TestPayloadsWithoutMessageType(t *testing.T) {
+	app, _ := newTestApp(t, nil)
+
+	handleEvent(app, []byte(`{"model":"Acurite-5n1","id":1,"channel":"A","temperature_F":60,"humidity":50}`), "", true)
+	handleEvent(app, []byte(`{"model":"Acurite-5n1","id":1,"channel":"A","wind_avg_km_h":10,"wind_dir_deg":90,"rain_in":0.5}`), "", true)
+
+	body := scrape(t, app)
+	for series, want := range map[string]string{
+		"temperature":    "60.000000",
+		"wind_speed":     "10.000000",
+		"wind_direction": "90",
+	} {
+		if got := metricValue(t, body, series); got != want {
+			t.Errorf("%s = %s, want %s", series, got, want)
+		}
+	}
+}
+
+func TestPayloadsWithoutMessageTypeDroppedWhenNotInferring(t *testing.T) {
+	app, _ := newTestApp(t, nil)
+
+	handleEvent(app, []byte(`{"model":"Acurite-5n1","id":1,"channel":"A","temperature_F":60,"humidity":50}`), "", false)
+
+	if line, ok := metricLine(scrape(t, app), "temperature"); ok {
+		t.Errorf("payload without message_type routed: %s", line)
+	}
+}
+
+func TestEmptyPayloadIgnoredQuietly(t *testing.T) {
+	app, _ := newTestApp(t, nil)
+	handler := weatherPubHandler(app, weathermetrics.MQTTConfig{})
+	logs := captureLog(t)
+
+	handler(nil, fakeMessage{topic: "rtl_433/1/events"})
+	handler(nil, fakeMessage{topic: "rtl_433/1/events", payload: []byte(" \n")})
+
+	if strings.Contains(logs.String(), "Could not decode") {
+		t.Errorf("empty payload logged as a decode error:\n%s", logs)
+	}
+	if got := strings.Count(logs.String(), "Ignoring empty message"); got != 2 {
+		t.Errorf("logged %d empty messages, want 2:\n%s", got, logs)
+	}
+}
+
+func TestModelMessagesCountedBeforeFiltering(t *testing.T) {
+	app, _ := newTestApp(t, map[string]string{"WEATHER_MAX_MODELS": "3"})
+
+	for _, payload := range []string{
+		`{"model":"Acurite-5n1","id":1,"channel":"A","message_type":56,"temperature_F":60,"humidity":50}`,
+		`{"model":"Acurite-5n1","id":1,"channel":"A","message_type":49,"wind_avg_km_h":5,"wind_dir_deg":90,"rain_in":0.1}`,
+
+		// Not a weather reading we understand, but still counted
+		`{"model":"Honeywell-Security","id":7,"channel":8,"event":128}`,
+		`{"model":"Fineoffset-WH2","id":3,"temperature_C":20}`,
+
+		// Past MAX_MODELS, including a payload without a model
+		`{"id":4,"temperature_C":20}`,
+		`{"model":"LaCrosse-TX141THBv2","id":5,"temperature_C":20}`,
+		`{"model":"Oregon-THGR122N","id":6,"temperature_C":20}`,
+	} {
+		handleEvent(app, []byte(payload), "", false)
+	}
+
+	body := scrape(t, app)
+	for series, want := range map[string]string{
+		`weather_model_messages_total{model="Acurite-5n1"}`:        "2",
+		`weather_model_messages_total{model="Honeywell-Security"}`: "1",
+		`weather_model_messages_total{model="Fineoffset-WH2"}`:     "1",
+		`weather_model_messages_total{model="other"}`:              "3",
+	} {
+		if got := metricValue(t, body, series); got != want {
+			t.Errorf("%s = %s, want %s", series, got, want)
+		}
+	}
+	if line, ok := metricLine(body, `weather_model_messages_total{model="LaCrosse-TX141THBv2"}`); ok {
+		t.Errorf("model past MAX_MODELS got its own label: %s", line)
+	}
+}
+
+func TestRestartResumesDailyHigh(t *testing.T) {
+	path := t.TempDir() + "/state.json"
+
+	before, clock := newTestApp(t, nil)
+	before.SetTempHumidityConditions(tempHumidity(1, 60, 50))
+	clock.Advance(time.Hour)
+	before.SetTempHumidityConditions(tempHumidity(1, 75, 50))
+	if err := weathermetrics.SaveState(path, before.persistedState()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The restarted process comes up later the same day
+	after, afterClock := newTestApp(t, nil)
+	afterClock.Advance(2 * time.Hour)
+	state, err := weathermetrics.LoadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after.restoreState(*state)
+	after.SetTempHumidityConditions(tempHumidity(1, 65, 50))
+
+	body := scrape(t, after)
+	for series, want := range map[string]string{
+		"weather_temperature_daily_max_fahrenheit": "75.000000",
+		"weather_temperature_daily_min_fahrenheit": "60.000000",
+	} {
+		if got := metricValue(t, body, series); got != want {
+			t.Errorf("%s = %s, want %s", series, got, want)
+		}
+	}
+}
+
+func TestOversizedPayloadDropped(t *testing.T) {
+	app, _ := newTestApp(t, nil)
+	payload := []byte(`{"model":"Acurite-5n1","id":1,"channel":"A","message_type":56,"temperature_F":60,"humidity":50}`)
+	handler := weatherPubHandler(app, weathermetrics.MQTTConfig{MaxPayloadSize: len(payload) - 1})
+	logs := captureLog(t)
+
+	handler(nil, fakeMessage{topic: "rtl_433/1/events", payload: payload})
+
+	body := scrape(t, app)
+	if line, ok := metricLine(body, "temperature"); ok {
+		t.Errorf("oversized payload decoded: %s", line)
+	}
+	if got := metricValue(t, body, "weather_mqtt_oversized_messages_total"); got != "1" {
+		t.Errorf("weather_mqtt_oversized_messages_total = %s, want 1", got)
+	}
+	if !strings.Contains(logs.String(), "WARNING: dropping") {
+		t.Errorf("no warning logged:\n%s", logs)
+	}
+}
+
+// recordingSink keeps every write
+type recordingSink struct {
+	m      sync.Mutex
+	writes []weathermetrics.CurrentConditions
+}
+
+func (s *recordingSink) Write(c weathermetrics.CurrentConditions, at time.Time) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.writes = append(s.writes, c)
+}
+
+func (s *recordingSink) Writes() []weathermetrics.CurrentConditions {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return append([]weathermetrics.CurrentConditions(nil), s.writes...)
+}
+
+func TestOutputIntervalTicksWithoutNewMessages(t *testing.T) {
+	app, clock := newTestApp(t, map[string]string{"WEATHER_OUTPUT_INTERVAL": "1m"})
+	sink := &recordingSink{}
+	app.sinks = append(app.sinks, sink)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go app.WriteSinksEvery(time.Minute, stop)
+	waitFor(t, "output ticker", func() bool { return clock.Tickers() == 1 })
+
+	// Messages don't write the sinks themselves
+	app.SetTempHumidityConditions(tempHumidity(1, 60, 50))
+	app.SetTempHumidityConditions(tempHumidity(1, 61, 50))
+	if got := len(sink.Writes()); got != 0 {
+		t.Fatalf("%d writes between ticks, want 0", got)
+	}
+
+	clock.Advance(time.Minute)
+	waitFor(t, "first sample", func() bool { return len(sink.Writes()) == 1 })
+
+	// No new reading: the tick repeats the last one, marked held
+	clock.Advance(time.Minute)
+	waitFor(t, "held sample", func() bool { return len(sink.Writes()) == 2 })
+
+	app.SetTempHumidityConditions(tempHumidity(1, 62, 50))
+	clock.Advance(time.Minute)
+	waitFor(t, "fresh sample", func() bool { return len(sink.Writes()) == 3 })
+
+	writes := sink.Writes()
+	for i, want := range []struct {
+		temp float32
+		held bool
+	}{{61, false}, {61, true}, {62, false}} {
+		if writes[i].Temp != want.temp || writes[i].Held != want.held {
+			t.Errorf("sample %d = %vF held %v, want %vF held %v", i, writes[i].Temp, writes[i].Held, want.temp, want.held)
+		}
+	}
+}
+
+func TestPairedMessagesWriteOncePerCycle(t *testing.T) {
+	app, _ := newTestApp(t, map[string]string{"WEATHER_PAIR_MESSAGES": "true"})
+	sink := &recordingSink{}
+	app.sinks = append(app.sinks, sink)
+
+	app.SetTempHumidityConditions(tempHumidity(1, 60, 50))
+	app.SetWindRainConditions(windRain(2, 10, 90, 0))
+	if got := len(sink.Writes()); got != 0 {
+		t.Fatalf("%d writes from halves of two stations, want 0", got)
+	}
+
+	app.SetWindRainConditions(windRain(1, 12, 180, 0))
+	writes := sink.Writes()
+	if len(writes) != 1 {
+		t.Fatalf("%d writes after station 1's cycle completed, want 1", len(writes))
+	}
+	if writes[0].Temp != 60 || writes[0].WindSpeed != 12 {
+		t.Errorf("wrote %+v, want both of station 1's halves", writes[0])
+	}
+}
+
+func TestPairingKeptIsPerStation(t *testing.T) {
+	app, clock := newTestApp(t, map[string]string{
+		"WEATHER_PAIR_MESSAGES":     "true",
+		"WEATHER_DECIMATE_INTERVAL": "1m",
+		"WEATHER_DECIMATE_FIELDS":   "wind,rain,temperature,humidity",
+	})
+	sink := &recordingSink{}
+	app.sinks = append(app.sinks, sink)
+
+	// Station 3 and then station 1 each get a half past decimation
+	app.SetWindRainConditions(windRain(3, 10, 90, 0))
+	clock.Advance(time.Second)
+	app.SetTempHumidityConditions(tempHumidity(1, 60, 50))
+	clock.Advance(time.Second)
+
+	// Both of station 2's halves are decimated away, so its cycle has
+	// nothing to write despite the other stations' kept halves
+	app.SetTempHumidityConditions(tempHumidity(2, 61, 50))
+	app.SetWindRainConditions(windRain(2, 11, 90, 0))
+	if got := len(sink.Writes()); got != 0 {
+		t.Fatalf("%d writes from a cycle with nothing kept, want 0", got)
+	}
+
+	// Station 1's cycle carries its kept temperature
+	app.SetWindRainConditions(windRain(1, 12, 90, 0))
+	if got := len(sink.Writes()); got != 1 {
+		t.Errorf("%d writes after station 1's cycle completed, want 1", got)
+	}
+}
+
+func TestUnpairedHalfWrittenAfterTimeout(t *testing.T) {
+	app, clock := newTestApp(t, map[string]string{
+		"WEATHER_PAIR_MESSAGES": "true",
+		"WEATHER_PAIR_TIMEOUT":  "30s",
+	})
+	sink := &recordingSink{}
+	app.sinks = append(app.sinks, sink)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go app.ExpirePairsEvery(15*time.Second, stop)
+	waitFor(t, "expiry ticker", func() bool { return clock.Tickers() == 1 })
+
+	app.SetTempHumidityConditions(tempHumidity(1, 60, 50))
+	if got := len(sink.Writes()); got != 0 {
+		t.Fatalf("%d writes before the partner or the timeout, want 0", got)
+	}
+
+	clock.Advance(31 * time.Second)
+	waitFor(t, "expired half", func() bool { return len(sink.Writes()) == 1 })
+	if got := sink.Writes()[0].Temp; got != 60 {
+		t.Errorf("wrote %vF, want 60F", got)
+	}
+}
+
+func TestTimedOutHalfWrittenWithoutExpiry(t *testing.T) {
+	app, clock := newTestApp(t, map[string]string{
+		"WEATHER_PAIR_MESSAGES": "true",
+		"WEATHER_PAIR_TIMEOUT":  "30s",
+	})
+	sink := &recordingSink{}
+	app.sinks = append(app.sinks, sink)
+
+	// No ExpirePairsEvery, so the late partner is what notices the timeout
+	app.SetTempHumidityConditions(tempHumidity(1, 60, 50))
+	clock.Advance(31 * time.Second)
+	app.SetWindRainConditions(windRain(1, 12, 90, 0))
+
+	writes := sink.Writes()
+	if len(writes) != 1 {
+		t.Fatalf("%d writes after the timed-out cycle was replaced, want 1", len(writes))
+	}
+	if writes[0].Temp != 60 || writes[0].WindSpeed == 12 {
+		t.Errorf("wrote %+v, want the expired cycle without the new wind", writes[0])
+	}
+}
+
+func TestOverCapWindDropped(t *testing.T) {
+	app, _ := newTestApp(t, nil)
+
+	handleEvent(app, []byte(`{"model":"Acurite-5n1","id":1,"channel":"A","message_type":49,"wind_avg_km_h":12,"wind_dir_deg":90,"rain_in":0.1}`), "", false)
+	handleEvent(app, []byte(`{"model":"Acurite-5n1","id":1,"channel":"A","message_type":49,"wind_avg_km_h":500,"wind_dir_deg":90,"rain_in":0.1}`), "", false)
+
+	body := scrape(t, app)
+	if got := metricValue(t, body, "wind_speed"); got != "12.000000" {
+		t.Errorf("wind_speed = %s, want the last plausible 12.000000", got)
+	}
+	if got := metricValue(t, body, "weather_wind_over_cap_total"); got != "1" {
+		t.Errorf("weather_wind_over_cap_total = %s, want 1", got)
+	}
+}
+
+func TestNegativeRainDoesNotAddToDailyTotal(t *testing.T) {
+	app, clock := newTestApp(t, nil)
+
+	for _, rain := range []string{"0.23", "0.23", "-0.5", "0.23"} {
+		handleEvent(app, []byte(`{"model":"Acurite-5n1","id":1,"channel":"A","message_type":49,"wind_avg_km_h":5,"wind_dir_deg":90,"rain_in":`+rain+`}`), "", false)
+		clock.Advance(time.Minute)
+	}
+
+	body := scrape(t, app)
+	for series, want := range map[string]string{
+		"weather_rain_daily_inches":   "0.000000",
+		"rain_in":                     "0.230000",
+		"weather_rain_negative_total": "1",
+	} {
+		if got := metricValue(t, body, series); got != want {
+			t.Errorf("%s = %s, want %s", series, got, want)
+		}
+	}
+}
+
+func TestRemoteWriteDroppedMetric(t *testing.T) {
+	app, clock := newTestApp(t, nil)
+
+	if _, ok := metricLine(scrape(t, app), "weather_remote_write_dropped_total"); ok {
+		t.Error("weather_remote_write_dropped_total exported without REMOTE_WRITE_URL")
+	}
+
+	app.remoteWrite = weathermetrics.NewRemoteWriteSink(weathermetrics.RemoteWriteConfig{
+		URL:      "http://unused",
+		Interval: time.Minute,
+		MaxQueue: 1,
+	}, clock, "test")
+	app.remoteWrite.Write(weathermetrics.CurrentConditions{}, clock.Now())
+
+	// One slot for five samples
+	if got := metricValue(t, scrape(t, app), "weather_remote_write_dropped_total"); got != "4" {
+		t.Errorf("weather_remote_write_dropped_total = %s, want 4", got)
+	}
+}
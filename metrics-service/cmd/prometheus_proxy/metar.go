@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	weathermetrics "github.com/mckeowbc/weather-metrics"
+)
+
+// MetarHandler renders the current conditions as a METAR-style line, timed
+// by the sensor's observation time where it can be parsed
+func (app *App) MetarHandler(w http.ResponseWriter, r *http.Request) {
+	state := app.state()
+	if state.lastUpdate.IsZero() {
+		http.Error(w, "no measurements received yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	observed := state.lastUpdate
+	if t, err := weathermetrics.ParseMessageTime(state.conditions.Timestamp, app.TZ); err == nil {
+		observed = t
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, weathermetrics.FormatMETAR(app.metarStation, state.conditions, observed, app.altitudeM))
+}
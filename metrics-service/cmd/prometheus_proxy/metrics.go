@@ -0,0 +1,438 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	weathermetrics "github.com/mckeowbc/weather-metrics"
+)
+
+type metric struct {
+	name string
+	// suffix distinguishes the series of a histogram, e.g. "_bucket", while
+	// name stays the family name METRICS filters on
+	suffix string
+	labels string
+	value  string
+}
+
+// PROCESSING_BUCKETS are the upper bounds, in seconds, of
+// weather_message_processing_seconds
+var PROCESSING_BUCKETS = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// knownMetrics is every series MetricsHandler can emit, in output order
+var knownMetrics = []string{
+	"temperature",
+	"weather_temperature_kelvin",
+	"weather_temperature_fahrenheit",
+	"weather_temperature_celsius",
+	"weather_temperature_trend",
+	"weather_apparent_temperature_fahrenheit",
+	"weather_temperature_daily_min_fahrenheit",
+	"weather_temperature_daily_max_fahrenheit",
+	"weather_comfort_level",
+	"humidity",
+	"rain_in",
+	"weather_rain_daily_inches",
+	"weather_rain_inches",
+	"weather_rain_millimeters",
+	"weather_pressure_inhg",
+	"weather_pressure_hpa",
+	"weather_pressure_station_hpa",
+	"weather_pressure_sealevel_hpa",
+	"wind_direction",
+	"weather_wind_direction_sector",
+	"wind_speed",
+	"weather_wind_speed_kmh",
+	"weather_wind_speed_mph",
+	"weather_wind_gust",
+	"battery_low",
+	"weather_battery_ok",
+	"weather_build_info",
+	"weather_process_start_time_seconds",
+	"weather_mqtt_reconnects_total",
+	"weather_mqtt_oversized_messages_total",
+	"weather_mqtt_connected",
+	"weather_station_evictions_total",
+	"weather_stations_tracked",
+	"weather_station_up",
+	"weather_station_last_seen_seconds",
+	"weather_sequence_gaps_total",
+	"weather_reception_quality_percent",
+	"weather_is_daylight",
+	"weather_sensor_clock_skew_seconds",
+	"weather_messages_by_mic_total",
+	"weather_model_messages_total",
+	"weather_message_processing_seconds",
+	"weather_rain_negative_total",
+	"weather_wind_over_cap_total",
+	"weather_timestamp_out_of_range_total",
+	"weather_remote_write_dropped_total",
+}
+
+func isKnownMetric(name string) bool {
+	for i := range knownMetrics {
+		if knownMetrics[i] == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (app *App) metrics() []metric {
+	state := app.state()
+	batteryLow := 0
+	if state.batteryLow {
+		batteryLow = 1
+	}
+	mqttConnected := 0
+	if app.MQTTStats.Connected() {
+		mqttConnected = 1
+	}
+
+	buildInfo := weathermetrics.GetBuildInfo()
+
+	metrics := []metric{}
+
+	// Stop reporting readings that have gone stale so Prometheus marks the
+	// series stale rather than graphing a flat line
+	if !app.stale(state.lastUpdate, app.metricsStaleAfter) {
+		metrics = append(metrics, app.conditionMetrics(state)...)
+	}
+
+	if app.stationUp {
+		metrics = append(metrics, app.stationUpMetrics(state.stations)...)
+	}
+
+	metrics = append(metrics, stationBatteryMetrics(state.stations)...)
+	metrics = append(metrics, app.stationLastSeenMetrics(state.stations)...)
+	metrics = append(metrics, stationSequenceMetrics(state.stations)...)
+
+	if app.sun.Enabled() {
+		daylight := 0
+		if weathermetrics.IsDaylight(app.clock.Now().In(app.TZ), *app.sun.Latitude, *app.sun.Longitude) {
+			daylight = 1
+		}
+		metrics = append(metrics, metric{name: "weather_is_daylight", value: fmt.Sprintf("%d", daylight)})
+	}
+
+	if state.clockSkew != nil {
+		metrics = append(metrics, metric{
+			name:  "weather_sensor_clock_skew_seconds",
+			value: fmt.Sprintf("%f", state.clockSkew.Seconds()),
+		})
+	}
+
+	for _, mic := range slices.Sorted(maps.Keys(state.micCounts)) {
+		metrics = append(metrics, metric{
+			name:   "weather_messages_by_mic_total",
+			labels: fmt.Sprintf("{mic=%q}", mic),
+			value:  fmt.Sprintf("%d", state.micCounts[mic]),
+		})
+	}
+
+	for _, model := range slices.Sorted(maps.Keys(state.modelCounts)) {
+		metrics = append(metrics, metric{
+			name:   "weather_model_messages_total",
+			labels: fmt.Sprintf("{model=%q}", model),
+			value:  fmt.Sprintf("%d", state.modelCounts[model]),
+		})
+	}
+
+	metrics = append(metrics,
+		histogramMetrics("weather_message_processing_seconds", app.processing.Snapshot())...)
+
+	if app.remoteWrite != nil {
+		metrics = append(metrics, metric{
+			name:  "weather_remote_write_dropped_total",
+			value: fmt.Sprintf("%d", app.remoteWrite.Dropped()),
+		})
+	}
+
+	if app.temperatureHistogram != nil {
+		metrics = append(metrics,
+			histogramMetrics("weather_temperature_fahrenheit", app.temperatureHistogram.Snapshot())...)
+	}
+
+	return append(metrics,
+		metric{name: "battery_low", value: fmt.Sprintf("%d", batteryLow)},
+		metric{
+			name: "weather_build_info",
+			labels: fmt.Sprintf("{version=%q,commit=%q,build_time=%q}",
+				buildInfo.Version, buildInfo.Commit, buildInfo.BuildTime),
+			value: "1",
+		},
+		metric{
+			name:  "weather_process_start_time_seconds",
+			value: fmt.Sprintf("%f", float64(app.startTime.UnixMilli())/1000),
+		},
+		metric{name: "weather_mqtt_reconnects_total", value: fmt.Sprintf("%d", app.MQTTStats.Reconnects())},
+		metric{name: "weather_mqtt_oversized_messages_total", value: fmt.Sprintf("%d", app.MQTTStats.Oversized())},
+		metric{name: "weather_mqtt_connected", value: fmt.Sprintf("%d", mqttConnected)},
+		metric{name: "weather_station_evictions_total", value: fmt.Sprintf("%d", state.evictions)},
+		metric{name: "weather_stations_tracked", value: fmt.Sprintf("%d", len(state.stations))},
+		metric{name: "weather_rain_negative_total", value: fmt.Sprintf("%d", app.validator.NegativeRain())},
+		metric{name: "weather_wind_over_cap_total", value: fmt.Sprintf("%d", app.validator.WindOverCap())},
+		metric{name: "weather_timestamp_out_of_range_total", value: fmt.Sprintf("%d", app.validator.BadTimestamps())},
+	)
+}
+
+func (app *App) conditionMetrics(state appState) []metric {
+	currentConditions := state.conditions
+
+	metrics := []metric{
+		{name: "temperature", value: fmt.Sprintf("%f", currentConditions.Temp)},
+	}
+
+	if app.units == UNITS_SCIENTIFIC {
+		metrics = append(metrics, metric{
+			name:  "weather_temperature_kelvin",
+			value: fmt.Sprintf("%f", weathermetrics.FahrenheitToKelvin(currentConditions.Temp)),
+		})
+	}
+
+	if app.units == UNITS_BOTH {
+		metrics = append(metrics,
+			metric{name: "weather_temperature_fahrenheit", value: fmt.Sprintf("%f", currentConditions.Temp)},
+			metric{
+				name:  "weather_temperature_celsius",
+				value: fmt.Sprintf("%f", weathermetrics.FahrenheitToCelsius(currentConditions.Temp)),
+			},
+		)
+	}
+
+	metrics = append(metrics,
+		metric{name: "weather_temperature_trend", value: fmt.Sprintf("%d", state.trend)},
+		metric{
+			name:  "weather_apparent_temperature_fahrenheit",
+			value: fmt.Sprintf("%f", currentConditions.ApparentTemperatureF()),
+		},
+	)
+
+	if state.haveExtremes {
+		metrics = append(metrics,
+			metric{name: "weather_temperature_daily_min_fahrenheit", value: fmt.Sprintf("%f", state.dailyMin)},
+			metric{name: "weather_temperature_daily_max_fahrenheit", value: fmt.Sprintf("%f", state.dailyMax)},
+		)
+	}
+
+	metrics = append(metrics,
+		metric{name: "humidity", value: weathermetrics.FormatCompact(currentConditions.Humidity)},
+		metric{name: "rain_in", value: fmt.Sprintf("%f", currentConditions.RainInches)},
+		metric{name: "weather_rain_daily_inches", value: fmt.Sprintf("%f", state.dailyRain)},
+	)
+
+	if app.units == UNITS_BOTH {
+		metrics = append(metrics,
+			metric{name: "weather_rain_inches", value: fmt.Sprintf("%f", currentConditions.RainInches)},
+			metric{
+				name:  "weather_rain_millimeters",
+				value: fmt.Sprintf("%f", weathermetrics.InchesToMM(currentConditions.RainInches)),
+			},
+		)
+	}
+
+	// 0 comfortable, 1 too dry, 2 too humid, 3 too cold, 4 too hot
+	if currentConditions.Humidity > 0 {
+		metrics = append(metrics, metric{
+			name:  "weather_comfort_level",
+			value: fmt.Sprintf("%d", currentConditions.ComfortLevel(app.comfort)),
+		})
+	}
+
+	if currentConditions.PressureHPa > 0 {
+		if app.units != UNITS_SCIENTIFIC {
+			metrics = append(metrics, metric{
+				name:  "weather_pressure_inhg",
+				value: fmt.Sprintf("%f", weathermetrics.HPaToInHg(currentConditions.PressureHPa)),
+			})
+		}
+		if app.units != UNITS_IMPERIAL {
+			metrics = append(metrics, metric{
+				name:  "weather_pressure_hpa",
+				value: fmt.Sprintf("%f", currentConditions.PressureHPa),
+			})
+		}
+
+		metrics = append(metrics,
+			metric{name: "weather_pressure_station_hpa", value: fmt.Sprintf("%f", currentConditions.PressureHPa)},
+			metric{
+				name:  "weather_pressure_sealevel_hpa",
+				value: fmt.Sprintf("%f", weathermetrics.SeaLevelPressureHPa(currentConditions.PressureHPa, app.altitudeM)),
+			},
+		)
+	}
+
+	if !app.windStale(state.windUpdated) {
+		if !app.omitCalmDirection || currentConditions.WindSpeed != 0 {
+			metrics = append(metrics, app.windDirectionMetrics(currentConditions.WindDirection)...)
+		}
+		metrics = append(metrics,
+			metric{name: "wind_speed", value: fmt.Sprintf("%f", currentConditions.WindSpeed)},
+		)
+
+		if app.units == UNITS_BOTH {
+			metrics = append(metrics,
+				metric{name: "weather_wind_speed_kmh", value: fmt.Sprintf("%f", currentConditions.WindSpeed)},
+				metric{
+					name:  "weather_wind_speed_mph",
+					value: fmt.Sprintf("%f", weathermetrics.KmhToMph(currentConditions.WindSpeed)),
+				},
+			)
+		}
+
+		// Only sensors that report wind_max_km_h have a gust
+		if currentConditions.WindGust != nil {
+			metrics = append(metrics, metric{
+				name:  "weather_wind_gust",
+				value: fmt.Sprintf("%f", *currentConditions.WindGust),
+			})
+		}
+	}
+
+	return metrics
+}
+
+func (app *App) windDirectionMetrics(degrees float32) []metric {
+	sector := weathermetrics.SnapToSector(degrees)
+
+	switch app.windDirectionMode {
+	case WIND_DIRECTION_SECTOR:
+		return []metric{{name: "wind_direction", value: weathermetrics.FormatCompact(sector)}}
+	case WIND_DIRECTION_BOTH:
+		return []metric{
+			{name: "wind_direction", value: weathermetrics.FormatCompact(degrees)},
+			{
+				name:   "weather_wind_direction_sector",
+				labels: fmt.Sprintf("{point=%q}", weathermetrics.DegreesToCardinal(degrees)),
+				value:  weathermetrics.FormatCompact(sector),
+			},
+		}
+	}
+
+	return []metric{{name: "wind_direction", value: weathermetrics.FormatCompact(degrees)}}
+}
+
+func histogramMetrics(name string, h weathermetrics.HistogramSnapshot) []metric {
+	metrics := []metric{}
+	for i, bound := range h.Bounds {
+		metrics = append(metrics, metric{
+			name:   name,
+			suffix: "_bucket",
+			labels: fmt.Sprintf("{le=%q}", strconv.FormatFloat(bound, 'g', -1, 64)),
+			value:  fmt.Sprintf("%d", h.Counts[i]),
+		})
+	}
+
+	return append(metrics,
+		metric{name: name, suffix: "_bucket", labels: `{le="+Inf"}`, value: fmt.Sprintf("%d", h.Count)},
+		metric{name: name, suffix: "_sum", value: fmt.Sprintf("%f", h.Sum)},
+		metric{name: name, suffix: "_count", value: fmt.Sprintf("%d", h.Count)},
+	)
+}
+
+func stationLabels(station weathermetrics.Station) string {
+	if station.Source != "" {
+		return fmt.Sprintf("{id=\"%d\",channel=%q,source=%q}", station.ID, station.Channel, station.Source)
+	}
+
+	return fmt.Sprintf("{id=\"%d\",channel=%q}", station.ID, station.Channel)
+}
+
+// stationBatteryMetrics reports each station's last battery_ok, with the model
+// so a dashboard can name the sensor that needs a new battery
+func stationBatteryMetrics(stations []weathermetrics.Station) []metric {
+	metrics := []metric{}
+	for _, station := range stations {
+		labels := strings.TrimSuffix(stationLabels(station), "}") + fmt.Sprintf(",model=%q}", station.Model)
+		metrics = append(metrics, metric{
+			name:   "weather_battery_ok",
+			labels: labels,
+			value:  fmt.Sprintf("%d", station.Conditions.Battery),
+		})
+	}
+
+	return metrics
+}
+
+// stationUpMetrics keeps reporting stations that have gone quiet, as 0, so
+// Prometheus can alert on them
+func (app *App) stationUpMetrics(stations []weathermetrics.Station) []metric {
+	now := app.clock.Now()
+	metrics := []metric{}
+	for _, station := range stations {
+		up := "0"
+		if now.Sub(station.LastSeen) <= app.stationTTL {
+			up = "1"
+		}
+		metrics = append(metrics, metric{
+			name:   "weather_station_up",
+			labels: stationLabels(station),
+			value:  up,
+		})
+	}
+
+	return metrics
+}
+
+// stationLastSeenMetrics reports how long ago each station was last heard
+// from, so an alert can name the sensor that went quiet
+func (app *App) stationLastSeenMetrics(stations []weathermetrics.Station) []metric {
+	now := app.clock.Now()
+	metrics := []metric{}
+	for _, station := range stations {
+		metrics = append(metrics, metric{
+			name:   "weather_station_last_seen_seconds",
+			labels: stationLabels(station),
+			value:  fmt.Sprintf("%f", now.Sub(station.LastSeen).Seconds()),
+		})
+	}
+
+	return metrics
+}
+
+// stationSequenceMetrics reports missed transmissions for stations that send
+// a sequence_num
+func stationSequenceMetrics(stations []weathermetrics.Station) []metric {
+	metrics := []metric{}
+	for _, station := range stations {
+		if station.Sequence == nil {
+			continue
+		}
+
+		metrics = append(metrics,
+			metric{
+				name:   "weather_sequence_gaps_total",
+				labels: stationLabels(station),
+				value:  fmt.Sprintf("%d", station.Sequence.Gaps),
+			},
+			metric{
+				name:   "weather_reception_quality_percent",
+				labels: stationLabels(station),
+				value:  fmt.Sprintf("%f", station.Sequence.ReceptionPercent),
+			},
+		)
+	}
+
+	return metrics
+}
+
+func (app *App) metricEnabled(name string) bool {
+	return len(app.enabledMetrics) == 0 || app.enabledMetrics[name]
+}
+
+func (app *App) MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	for _, m := range app.metrics() {
+		if !app.metricEnabled(m.name) {
+			continue
+		}
+		fmt.Fprintf(w, "%s%s%s %s\n", m.name, m.suffix, m.labels, m.value)
+	}
+}
@@ -0,0 +1,639 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	weathermetrics "github.com/mckeowbc/weather-metrics"
+)
+
+func TestDisabledMetricsAreAbsent(t *testing.T) {
+	app, _ := newTestApp(t, map[string]string{"WEATHER_METRICS": "temperature,humidity"})
+	app.SetTempHumidityConditions(tempHumidity(1, 70, 50))
+	app.SetWindRainConditions(windRain(1, 10, 90, 0.1))
+
+	body := scrape(t, app)
+	metricValue(t, body, "temperature")
+	metricValue(t, body, "humidity")
+
+	for _, name := range []string{"wind_speed", "wind_direction", "rain_in", "weather_build_info"} {
+		if strings.Contains(body, name) {
+			t.Errorf("disabled %s in output:\n%s", name, body)
+		}
+	}
+}
+
+func TestAllMetricsEnabledByDefault(t *testing.T) {
+	app, _ := newTestApp(t, nil)
+	app.SetWindRainConditions(windRain(1, 10, 90, 0.1))
+
+	body := scrape(t, app)
+	for _, name := range []string{"wind_speed", "rain_in", "weather_build_info"} {
+		if !strings.Contains(body, name) {
+			t.Errorf("%s missing with METRICS unset:\n%s", name, body)
+		}
+	}
+}
+
+func TestUnknownMetricRejected(t *testing.T) {
+	_, err := loadConfig(t, map[string]string{"WEATHER_METRICS": "temperature,no_such_metric"})
+	if err == nil || !strings.Contains(err.Error(), "no_such_metric") {
+		t.Errorf("unknown metric accepted, err = %v", err)
+	}
+}
+
+// onlyTempHumidity feeds temperature/humidity messages for d, one a minute,
+// as when the wind/rain half stops arriving
+func onlyTempHumidity(app *App, clock *weathermetrics.FakeClock, d time.Duration) {
+	for elapsed := time.Duration(0); elapsed < d; elapsed += time.Minute {
+		clock.Advance(time.Minute)
+		app.SetTempHumidityConditions(tempHumidity(1, 70, 50))
+	}
+}
+
+func TestWindHeldLastByDefault(t *testing.T) {
+	app, clock := newTestApp(t, nil)
+	app.SetWindRainConditions(windRain(1, 10, 90, 0.1))
+
+	onlyTempHumidity(app, clock, 8*time.Minute)
+
+	body := scrape(t, app)
+	if got := metricValue(t, body, "wind_speed"); got != "10.000000" {
+		t.Errorf("held wind_speed = %s, want 10.000000", got)
+	}
+	metricValue(t, body, "wind_direction")
+}
+
+func TestWindMarkedStale(t *testing.T) {
+	app, clock := newTestApp(t, map[string]string{
+		"WEATHER_WIND_STALE_POLICY": "stale",
+		"WEATHER_WIND_STALE_AFTER":  "5m",
+	})
+	app.SetWindRainConditions(windRain(1, 10, 90, 0.1))
+
+	onlyTempHumidity(app, clock, 4*time.Minute)
+	metricValue(t, scrape(t, app), "wind_speed")
+
+	onlyTempHumidity(app, clock, 2*time.Minute)
+	body := scrape(t, app)
+	for _, series := range []string{"wind_speed", "wind_direction"} {
+		if line, ok := metricLine(body, series); ok {
+			t.Errorf("stale wind still exported: %s", line)
+		}
+	}
+	metricValue(t, body, "temperature")
+
+	app.SetWindRainConditions(windRain(1, 12, 180, 0.1))
+	if got := metricValue(t, scrape(t, app), "wind_speed"); got != "12.000000" {
+		t.Errorf("wind_speed after fresh reading = %s, want 12.000000", got)
+	}
+}
+
+func TestScientificUnitsEmitKelvin(t *testing.T) {
+	app, _ := newTestApp(t, map[string]string{"WEATHER_UNITS": "scientific"})
+	app.SetTempHumidityConditions(tempHumidity(1, 68, 50))
+
+	if got := metricValue(t, scrape(t, app), "weather_temperature_kelvin"); got != "293.149994" {
+		t.Errorf("weather_temperature_kelvin = %s, want 293.149994", got)
+	}
+}
+
+func TestImperialUnitsOmitKelvin(t *testing.T) {
+	app, _ := newTestApp(t, nil)
+	app.SetTempHumidityConditions(tempHumidity(1, 68, 50))
+
+	if line, ok := metricLine(scrape(t, app), "weather_temperature_kelvin"); ok {
+		t.Errorf("imperial output has %s", line)
+	}
+}
+
+func TestBothUnitsEmitEverySeries(t *testing.T) {
+	app, _ := newTestApp(t, map[string]string{"WEATHER_UNITS": "both"})
+	app.SetTempHumidityConditions(tempHumidity(1, 68, 50))
+	app.SetWindRainConditions(windRain(1, 10, 90, 1))
+
+	body := scrape(t, app)
+	for series, want := range map[string]string{
+		"weather_temperature_fahrenheit": "68.000000",
+		"weather_temperature_celsius":    "20.000000",
+		"weather_wind_speed_kmh":         "10.000000",
+		"weather_wind_speed_mph":         "6.213712",
+		"weather_rain_inches":            "1.000000",
+		"weather_rain_millimeters":       "25.400000",
+	} {
+		if got := metricValue(t, body, series); got != want {
+			t.Errorf("%s = %s, want %s", series, got, want)
+		}
+	}
+}
+
+func TestSingleUnitsOmitBothSeries(t *testing.T) {
+	for _, units := range []string{"imperial", "scientific"} {
+		app, _ := newTestApp(t, map[string]string{"WEATHER_UNITS": units})
+		app.SetTempHumidityConditions(tempHumidity(1, 68, 50))
+		app.SetWindRainConditions(windRain(1, 10, 90, 1))
+
+		body := scrape(t, app)
+		for _, series := range []string{"weather_temperature_celsius", "weather_wind_speed_mph", "weather_rain_millimeters"} {
+			if line, ok := metricLine(body, series); ok {
+				t.Errorf("%s output has %s", units, line)
+			}
+		}
+	}
+}
+
+func TestIntegerFieldsRenderWithoutDecimals(t *testing.T) {
+	app, _ := newTestApp(t, nil)
+	app.SetTempHumidityConditions(tempHumidity(1, 69.1, 97))
+
+	body := scrape(t, app)
+	if got := metricValue(t, body, "humidity"); got != "97" {
+		t.Errorf("humidity = %s, want 97", got)
+	}
+	if got := metricValue(t, body, "temperature"); !strings.HasPrefix(got, "69.") {
+		t.Errorf("temperature = %s, want 69.1 as a float", got)
+	}
+}
+
+func TestStationUpDropsToZeroAfterTTL(t *testing.T) {
+	app, clock := newTestApp(t, map[string]string{
+		"WEATHER_STATION_UP":  "true",
+		"WEATHER_STATION_TTL": "5m",
+	})
+	app.SetTempHumidityConditions(tempHumidity(1, 70, 50))
+	app.SetTempHumidityConditions(tempHumidity(2, 60, 40))
+
+	body := scrape(t, app)
+	for _, series := range []string{`weather_station_up{id="1",channel="A"}`, `weather_station_up{id="2",channel="A"}`} {
+		if got := metricValue(t, body, series); got != "1" {
+			t.Errorf("%s = %s, want 1", series, got)
+		}
+	}
+
+	clock.Advance(4 * time.Minute)
+	app.SetTempHumidityConditions(tempHumidity(2, 60, 40))
+	clock.Advance(2 * time.Minute)
+
+	body = scrape(t, app)
+	if got := metricValue(t, body, `weather_station_up{id="1",channel="A"}`); got != "0" {
+		t.Errorf("silent station up = %s, want 0", got)
+	}
+	if got := metricValue(t, body, `weather_station_up{id="2",channel="A"}`); got != "1" {
+		t.Errorf("reporting station up = %s, want 1", got)
+	}
+
+	// The series stays at 0 however long the station is silent
+	clock.Advance(time.Hour)
+	if got := metricValue(t, scrape(t, app), `weather_station_up{id="1",channel="A"}`); got != "0" {
+		t.Errorf("long silent station up = %s, want 0", got)
+	}
+}
+
+func TestStationUpOffByDefault(t *testing.T) {
+	app, _ := newTestApp(t, nil)
+	app.SetTempHumidityConditions(tempHumidity(1, 70, 50))
+
+	if strings.Contains(scrape(t, app), "weather_station_up") {
+		t.Error("weather_station_up emitted without STATION_UP")
+	}
+}
+
+// Run with -race; the setters and scrapes share the app state and the
+// per-station maps
+func TestConcurrentUpdatesAndScrapes(t *testing.T) {
+	app, _ := newTestApp(t, map[string]string{
+		"WEATHER_STATION_UP":            "true",
+		"WEATHER_TEMPERATURE_HISTOGRAM": "true",
+	})
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				app.SetTempHumidityConditions(tempHumidity(g%3, float32(60+i%20), float32(30+i%50)))
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				app.SetWindRainConditions(windRain(g%3, float32(i%30), float32(i*10%360), float32(i)/100))
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				w := httptest.NewRecorder()
+				app.MetricsHandler(w, httptest.NewRequest("GET", "/metrics", nil))
+				if w.Code != http.StatusOK {
+					t.Errorf("scrape returned %d", w.Code)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	metricValue(t, scrape(t, app), "temperature")
+}
+
+func TestSensorClockSkew(t *testing.T) {
+	app, clock := newTestApp(t, nil)
+
+	// The fake clock reads 12:00:00 local; the sensor is 90s slow
+	m := tempHumidity(1, 70, 50)
+	m.Timestamp = "2024-06-01 11:58:30"
+	app.SetTempHumidityConditions(m)
+
+	if got := metricValue(t, scrape(t, app), "weather_sensor_clock_skew_seconds"); got != "-90.000000" {
+		t.Errorf("skew = %s, want -90.000000", got)
+	}
+
+	// Later messages replace the skew rather than accumulating it
+	clock.Advance(time.Minute)
+	w := windRain(1, 10, 90, 0.1)
+	w.Timestamp = "2024-06-01 12:01:15"
+	app.SetWindRainConditions(w)
+
+	if got := metricValue(t, scrape(t, app), "weather_sensor_clock_skew_seconds"); got != "15.000000" {
+		t.Errorf("skew = %s, want 15.000000", got)
+	}
+}
+
+func TestSensorClockSkewAbsentWithoutTimestamp(t *testing.T) {
+	app, _ := newTestApp(t, nil)
+	app.SetTempHumidityConditions(tempHumidity(1, 70, 50))
+
+	if line, ok := metricLine(scrape(t, app), "weather_sensor_clock_skew_seconds"); ok {
+		t.Errorf("skew reported without a sensor timestamp: %s", line)
+	}
+}
+
+func TestPressureOutputUnits(t *testing.T) {
+	for _, tc := range []struct {
+		units   string
+		present []string
+		absent  []string
+	}{
+		{units: "imperial", present: []string{"weather_pressure_inhg"}, absent: []string{"weather_pressure_hpa"}},
+		{units: "scientific", present: []string{"weather_pressure_hpa"}, absent: []string{"weather_pressure_inhg"}},
+		{units: "both", present: []string{"weather_pressure_inhg", "weather_pressure_hpa"}},
+	} {
+		t.Run(tc.units, func(t *testing.T) {
+			app, _ := newTestApp(t, map[string]string{"WEATHER_UNITS": tc.units})
+			m := tempHumidity(1, 60, 50)
+			m.PressureHPa = 1013.21
+			app.SetTempHumidityConditions(m)
+
+			body := scrape(t, app)
+			for _, series := range tc.present {
+				metricValue(t, body, series)
+			}
+			for _, series := range tc.absent {
+				if line, ok := metricLine(body, series); ok {
+					t.Errorf("%s output has %s", tc.units, line)
+				}
+			}
+			if got := metricValue(t, body, "weather_pressure_station_hpa"); got != "1013.210022" {
+				t.Errorf("station pressure = %s, want 1013.210022", got)
+			}
+		})
+	}
+}
+
+func TestSeaLevelPressureUsesAltitude(t *testing.T) {
+	app, _ := newTestApp(t, map[string]string{"WEATHER_ALTITUDE_M": "1000"})
+	m := tempHumidity(1, 60, 50)
+	m.PressureHPa = 898.76
+	app.SetTempHumidityConditions(m)
+
+	body := scrape(t, app)
+	if got := metricValue(t, body, "weather_pressure_station_hpa"); !strings.HasPrefix(got, "898.7") {
+		t.Errorf("station pressure = %s, want 898.76", got)
+	}
+	if got := metricValue(t, body, "weather_pressure_sealevel_hpa"); !strings.HasPrefix(got, "1013.") {
+		t.Errorf("sea level pressure = %s, want about 1013.25", got)
+	}
+}
+
+func TestDailyRainMetric(t *testing.T) {
+	app, clock := newTestApp(t, nil)
+
+	app.SetWindRainConditions(windRain(1, 0, 0, 1.00))
+	clock.Advance(time.Hour)
+	app.SetWindRainConditions(windRain(1, 0, 0, 1.25))
+
+	if got := metricValue(t, scrape(t, app), "weather_rain_daily_inches"); got != "0.250000" {
+		t.Errorf("weather_rain_daily_inches = %s, want 0.250000", got)
+	}
+
+	// Noon plus 13 hours is the next day
+	clock.Advance(12 * time.Hour)
+	app.SetTempHumidityConditions(tempHumidity(1, 60, 50))
+	if got := metricValue(t, scrape(t, app), "weather_rain_daily_inches"); got != "0.000000" {
+		t.Errorf("weather_rain_daily_inches after midnight = %s, want 0.000000", got)
+	}
+}
+
+func TestBatteryPerStation(t *testing.T) {
+	app, _ := newTestApp(t, nil)
+
+	app.SetTempHumidityConditions(tempHumidity(1, 70, 50))
+	low := windRain(2, 10, 90, 0.1)
+	low.Battery = 0
+	app.SetWindRainConditions(low)
+
+	body := scrape(t, app)
+	for series, want := range map[string]string{
+		`weather_battery_ok{id="1",channel="A",model="Acurite-5n1"}`: "1",
+		`weather_battery_ok{id="2",channel="A",model="Acurite-5n1"}`: "0",
+	} {
+		if got := metricValue(t, body, series); got != want {
+			t.Errorf("%s = %s, want %s", series, got, want)
+		}
+	}
+}
+
+func TestStationsTrackedFollowsAdditionsAndEvictions(t *testing.T) {
+	app, clock := newTestApp(t, map[string]string{"WEATHER_MAX_STATIONS": "2"})
+
+	tracked := func() string {
+		return metricValue(t, scrape(t, app), "weather_stations_tracked")
+	}
+	if got := tracked(); got != "0" {
+		t.Errorf("tracked before any message = %s, want 0", got)
+	}
+
+	app.SetTempHumidityConditions(tempHumidity(1, 60, 50))
+	app.SetTempHumidityConditions(tempHumidity(1, 61, 50))
+	if got := tracked(); got != "1" {
+		t.Errorf("tracked after one station = %s, want 1", got)
+	}
+
+	clock.Advance(time.Second)
+	app.SetTempHumidityConditions(tempHumidity(2, 60, 50))
+	if got := tracked(); got != "2" {
+		t.Errorf("tracked after two stations = %s, want 2", got)
+	}
+
+	// A third station evicts the least recently updated one
+	clock.Advance(time.Second)
+	app.SetTempHumidityConditions(tempHumidity(3, 60, 50))
+	body := scrape(t, app)
+	if got := metricValue(t, body, "weather_stations_tracked"); got != "2" {
+		t.Errorf("tracked after an eviction = %s, want 2", got)
+	}
+	if got := metricValue(t, body, "weather_station_evictions_total"); got != "1" {
+		t.Errorf("evictions = %s, want 1", got)
+	}
+}
+
+func TestWindDirectionModes(t *testing.T) {
+	for mode, want := range map[string]map[string]string{
+		"raw":    {"wind_direction": "160"},
+		"sector": {"wind_direction": "157.5"},
+		"both": {
+			"wind_direction": "160",
+			`weather_wind_direction_sector{point="SSE"}`: "157.5",
+		},
+	} {
+		app, _ := newTestApp(t, map[string]string{"WEATHER_WIND_DIRECTION_MODE": mode})
+		app.SetWindRainConditions(windRain(1, 10, 160, 0))
+
+		body := scrape(t, app)
+		for series, value := range want {
+			if got := metricValue(t, body, series); got != value {
+				t.Errorf("%s: %s = %s, want %s", mode, series, got, value)
+			}
+		}
+		if _, ok := want[`weather_wind_direction_sector{point="SSE"}`]; !ok {
+			if line, ok := metricLine(body, `weather_wind_direction_sector{point="SSE"}`); ok {
+				t.Errorf("%s: unexpected %s", mode, line)
+			}
+		}
+	}
+}
+
+func TestWindAverageAndGustExposedSeparately(t *testing.T) {
+	app, _ := newTestApp(t, nil)
+
+	handleEvent(app, []byte(`{"model":"Acurite-Atlas","id":1,"channel":"A","message_type":49,"wind_avg_km_h":12,"wind_max_km_h":20,"wind_dir_deg":90,"rain_in":0.1}`), "", false)
+
+	body := scrape(t, app)
+	if got := metricValue(t, body, "wind_speed"); got != "12.000000" {
+		t.Errorf("wind_speed = %s, want 12.000000", got)
+	}
+	if got := metricValue(t, body, "weather_wind_gust"); got != "20.000000" {
+		t.Errorf("weather_wind_gust = %s, want 20.000000", got)
+	}
+}
+
+func TestNumericAndLetterChannelsAreDistinctStations(t *testing.T) {
+	app, _ := newTestApp(t, map[string]string{"WEATHER_STATION_UP": "true"})
+
+	handleEvent(app, []byte(`{"model":"Acurite-5n1","id":1,"channel":"A","message_type":56,"temperature_F":60,"humidity":50}`), "", false)
+	handleEvent(app, []byte(`{"model":"Acurite-5n1","id":1,"channel":3,"message_type":56,"temperature_F":60,"humidity":50}`), "", false)
+	handleEvent(app, []byte(`{"model":"Acurite-5n1","id":1,"channel":"3","message_type":56,"temperature_F":60,"humidity":50}`), "", false)
+
+	body := scrape(t, app)
+	metricValue(t, body, `weather_station_up{id="1",channel="A"}`)
+	metricValue(t, body, `weather_station_up{id="1",channel="3"}`)
+	if got := metricValue(t, body, "weather_stations_tracked"); got != "2" {
+		t.Errorf("tracked = %s, want 2: 3 and \"3\" are the same channel", got)
+	}
+}
+
+func TestApparentTemperatureMetric(t *testing.T) {
+	app, _ := newTestApp(t, nil)
+	app.SetTempHumidityConditions(tempHumidity(1, 30, 50))
+	app.SetWindRainConditions(windRain(1, 16.09, 90, 0))
+
+	want := app.GetCurrentConditions().WindChillF()
+	if got := metricValue(t, scrape(t, app), "weather_apparent_temperature_fahrenheit"); got != fmt.Sprintf("%f", want) {
+		t.Errorf("weather_apparent_temperature_fahrenheit = %s, want the wind chill %f", got, want)
+	}
+}
+
+func TestCalmDirectionOmitted(t *testing.T) {
+	app, _ := newTestApp(t, map[string]string{"WEATHER_OMIT_CALM_DIRECTION": "true"})
+
+	app.SetWindRainConditions(windRain(1, 0, 225, 0))
+	if line, ok := metricLine(scrape(t, app), "wind_direction"); ok {
+		t.Errorf("direction exposed while calm: %s", line)
+	}
+
+	telegraf := httptest.NewRecorder()
+	app.TelegrafHandler(telegraf, httptest.NewRequest("GET", "/telegraf", nil))
+	if strings.Contains(telegraf.Body.String(), "wind_dir_deg") {
+		t.Errorf("telegraf direction while calm: %s", telegraf.Body)
+	}
+
+	// It comes back as soon as there is wind
+	app.SetWindRainConditions(windRain(1, 5, 225, 0))
+	if got := metricValue(t, scrape(t, app), "wind_direction"); got != "225" {
+		t.Errorf("wind_direction = %s, want 225", got)
+	}
+}
+
+func TestCalmDirectionKeptByDefault(t *testing.T) {
+	app, _ := newTestApp(t, nil)
+
+	app.SetWindRainConditions(windRain(1, 0, 225, 0))
+	if got := metricValue(t, scrape(t, app), "wind_direction"); got != "225" {
+		t.Errorf("wind_direction = %s, want 225", got)
+	}
+}
+
+func TestStationLastSeenAges(t *testing.T) {
+	app, clock := newTestApp(t, nil)
+
+	app.SetTempHumidityConditions(tempHumidity(1, 60, 50))
+	clock.Advance(90 * time.Second)
+	app.SetTempHumidityConditions(tempHumidity(2, 60, 50))
+	clock.Advance(30 * time.Second)
+
+	body := scrape(t, app)
+	for series, want := range map[string]string{
+		`weather_station_last_seen_seconds{id="1",channel="A"}`: "120.000000",
+		`weather_station_last_seen_seconds{id="2",channel="A"}`: "30.000000",
+	} {
+		if got := metricValue(t, body, series); got != want {
+			t.Errorf("%s = %s, want %s", series, got, want)
+		}
+	}
+}
+
+func TestSequenceGapMetrics(t *testing.T) {
+	app, clock := newTestApp(t, nil)
+
+	// The 5n1's repeat index runs 0-2: going 0 to 2 misses a 1, and 2 to 1
+	// wraps past a missed 0
+	for _, seq := range []int{0, 2, 1} {
+		m := tempHumidity(1, 60, 50)
+		m.Sequence = &seq
+		app.SetTempHumidityConditions(m)
+		clock.Advance(time.Second)
+	}
+
+	// A station without sequence_num has neither series
+	app.SetTempHumidityConditions(tempHumidity(2, 60, 50))
+
+	body := scrape(t, app)
+	for series, want := range map[string]string{
+		`weather_sequence_gaps_total{id="1",channel="A"}`:       "2",
+		`weather_reception_quality_percent{id="1",channel="A"}`: "60.000000",
+	} {
+		if got := metricValue(t, body, series); got != want {
+			t.Errorf("%s = %s, want %s", series, got, want)
+		}
+	}
+	if line, ok := metricLine(body, `weather_sequence_gaps_total{id="2",channel="A"}`); ok {
+		t.Errorf("gaps reported for a station without sequence numbers: %s", line)
+	}
+}
+
+func TestTemperatureHistogramCounts(t *testing.T) {
+	app, _ := newTestApp(t, map[string]string{
+		"WEATHER_TEMPERATURE_HISTOGRAM": "true",
+		"WEATHER_TEMPERATURE_BUCKETS":   "32,50,70",
+	})
+
+	for _, temp := range []float32{20, 32, 60} {
+		app.SetTempHumidityConditions(tempHumidity(1, temp, 50))
+	}
+
+	want := map[string]string{
+		`weather_temperature_fahrenheit_bucket{le="32"}`:   "2",
+		`weather_temperature_fahrenheit_bucket{le="50"}`:   "2",
+		`weather_temperature_fahrenheit_bucket{le="70"}`:   "3",
+		`weather_temperature_fahrenheit_bucket{le="+Inf"}`: "3",
+		"weather_temperature_fahrenheit_sum":               "112.000000",
+		"weather_temperature_fahrenheit_count":             "3",
+	}
+	body := scrape(t, app)
+	for series, value := range want {
+		if got := metricValue(t, body, series); got != value {
+			t.Errorf("%s = %s, want %s", series, got, value)
+		}
+	}
+
+	// A reading above every bound only counts towards +Inf
+	app.SetTempHumidityConditions(tempHumidity(1, 90, 50))
+	want[`weather_temperature_fahrenheit_bucket{le="+Inf"}`] = "4"
+	want["weather_temperature_fahrenheit_sum"] = "202.000000"
+	want["weather_temperature_fahrenheit_count"] = "4"
+
+	body = scrape(t, app)
+	for series, value := range want {
+		if got := metricValue(t, body, series); got != value {
+			t.Errorf("after 90F: %s = %s, want %s", series, got, value)
+		}
+	}
+}
+
+func TestTemperatureHistogramOffByDefault(t *testing.T) {
+	app, _ := newTestApp(t, nil)
+	app.SetTempHumidityConditions(tempHumidity(1, 60, 50))
+
+	if strings.Contains(scrape(t, app), "weather_temperature_fahrenheit_bucket") {
+		t.Error("histogram emitted without TEMPERATURE_HISTOGRAM")
+	}
+}
+
+func TestIsDaylightMetric(t *testing.T) {
+	app, clock := newTestApp(t, map[string]string{
+		"WEATHER_LATITUDE":  "40.7128",
+		"WEATHER_LONGITUDE": "-74.0060",
+	})
+
+	// Noon, then 22:00, on 1 June in New York
+	if got := metricValue(t, scrape(t, app), "weather_is_daylight"); got != "1" {
+		t.Errorf("weather_is_daylight at noon = %s, want 1", got)
+	}
+	clock.Advance(10 * time.Hour)
+	if got := metricValue(t, scrape(t, app), "weather_is_daylight"); got != "0" {
+		t.Errorf("weather_is_daylight at 22:00 = %s, want 0", got)
+	}
+}
+
+func TestProcessStartTime(t *testing.T) {
+	app, clock := newTestApp(t, nil)
+
+	// Noon in New York on 1 June 2024, and it doesn't move as time passes
+	want := "1717257600.000000"
+	if got := metricValue(t, scrape(t, app), "weather_process_start_time_seconds"); got != want {
+		t.Errorf("weather_process_start_time_seconds = %s, want %s", got, want)
+	}
+	clock.Advance(time.Hour)
+	if got := metricValue(t, scrape(t, app), "weather_process_start_time_seconds"); got != want {
+		t.Errorf("weather_process_start_time_seconds moved to %s", got)
+	}
+}
+
+func TestProcessStartTimeIsNewAppTime(t *testing.T) {
+	conf, err := loadConfig(t, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := time.Now()
+	app, err := NewApp(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := time.Now()
+
+	got, err := strconv.ParseFloat(metricValue(t, scrape(t, app), "weather_process_start_time_seconds"), 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got < float64(before.UnixMilli())/1000 || got > float64(after.UnixMilli())/1000 {
+		t.Errorf("start time %f not between %s and %s", got, before, after)
+	}
+}
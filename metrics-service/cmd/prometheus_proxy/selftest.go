@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	weathermetrics "github.com/mckeowbc/weather-metrics"
+)
+
+/*
+ * Self-test
+ *
+ * --selftest publishes a synthetic reading from a station id no real 5n1
+ * uses to the subscribed topic, and waits for it to come back through the
+ * handler. That covers broker connectivity, the subscription and parsing in
+ * one go. The reading is republished every second because the subscription
+ * is made asynchronously once the connection is up.
+ */
+const (
+	SELFTEST_ID      = 65535
+	SELFTEST_CHANNEL = "selftest"
+)
+
+func runSelfTest(app *App, client mqtt.Client, connect mqtt.Token, topic string, timeout time.Duration) error {
+	if topic == "" {
+		return fmt.Errorf("MQTT_TOPIC is empty, nothing to test")
+	}
+
+	deadline := time.Now().Add(timeout)
+	if !connect.WaitTimeout(timeout) {
+		return fmt.Errorf("could not connect within %s", timeout)
+	}
+	if connect.Error() != nil {
+		return connect.Error()
+	}
+
+	target := weathermetrics.ConcreteTopic(topic, SELFTEST_CHANNEL)
+	key := weathermetrics.StationKey{ID: SELFTEST_ID, Channel: SELFTEST_CHANNEL}
+
+	for time.Now().Before(deadline) {
+		payload := weathermetrics.SyntheticTempHumidity(time.Now().In(app.TZ), SELFTEST_ID, SELFTEST_CHANNEL, 68, 50)
+		log.Printf("Self-test: publishing to %s", target)
+		client.Publish(target, 1, false, payload)
+
+		time.Sleep(time.Second)
+		if app.hasStation(key) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("self-test reading did not come back within %s", timeout)
+}
+
+func (app *App) hasStation(key weathermetrics.StationKey) bool {
+	for _, station := range app.state().stations {
+		if station.ID == key.ID && station.Channel == key.Channel {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	weathermetrics "github.com/mckeowbc/weather-metrics"
+)
+
+// doneToken is an MQTT token that has already completed with err
+type doneToken struct{ err error }
+
+func (t doneToken) Wait() bool                     { return true }
+func (t doneToken) WaitTimeout(time.Duration) bool { return true }
+func (t doneToken) Done() <-chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+func (t doneToken) Error() error { return t.err }
+
+// loopbackClient hands every publish to handler, as a broker would for a
+// matching subscription. With handler nil, publishes go nowhere.
+type loopbackClient struct {
+	mqtt.Client
+	handler mqtt.MessageHandler
+	topics  []string
+}
+
+func (c *loopbackClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	c.topics = append(c.topics, topic)
+	if c.handler != nil {
+		c.handler(c, fakeMessage{topic: topic, payload: payload.([]byte)})
+	}
+	return doneToken{}
+}
+
+func TestSelfTestPassesWhenReadingComesBack(t *testing.T) {
+	app, _ := newTestApp(t, nil)
+	client := &loopbackClient{handler: weatherPubHandler(app, weathermetrics.MQTTConfig{InferMessageType: true})}
+
+	if err := runSelfTest(app, client, doneToken{}, "rtl_433/+/events", 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(client.topics) != 1 || client.topics[0] != "rtl_433/selftest/events" {
+		t.Errorf("published to %v, want the wildcard filled in", client.topics)
+	}
+}
+
+func TestSelfTestFailsWhenReadingIsLost(t *testing.T) {
+	app, _ := newTestApp(t, nil)
+
+	err := runSelfTest(app, &loopbackClient{}, doneToken{}, "rtl_433/+/events", time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "did not come back") {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestSelfTestNeedsATopic(t *testing.T) {
+	app, _ := newTestApp(t, nil)
+
+	if err := runSelfTest(app, &loopbackClient{}, doneToken{}, "", time.Second); err == nil {
+		t.Error("self-test ran with no topic")
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+)
+
+const LISTEN_ADDR = ":8080"
+
+// NewServer registers every endpoint on a dedicated mux, prefixed with
+// basePath so the whole app can sit behind a reverse proxy subpath
+func NewServer(app *App, conf Config) *http.ServeMux {
+	mux := http.NewServeMux()
+	handle := func(path string, handler http.HandlerFunc) {
+		mux.HandleFunc(conf.BasePath+path, logger(handler))
+	}
+
+	handle(conf.MetricsPath, app.MetricsHandler)
+	handle("/conditions", app.ConditionsHandler)
+	handle("/healthz", app.HealthHandler)
+	handle("/version", VersionHandler)
+	handle("/rain/daily", app.DailyRainHandler)
+	handle("/export.csv", app.ExportCSVHandler)
+	handle("/telegraf", app.TelegrafHandler)
+	handle("/stations", app.StationsHandler)
+	handle("/metar", app.MetarHandler)
+	if conf.ConfigEndpoint {
+		handle("/config", app.ConfigHandler)
+	}
+	if conf.Dashboard {
+		handle("/dashboard", DashboardHandler)
+	}
+
+	return mux
+}
+
+// NewHTTPServer wraps handler with the configured timeouts so a slow or idle
+// client can't hold a connection open indefinitely
+func NewHTTPServer(handler http.Handler, conf Config) *http.Server {
+	return &http.Server{
+		Addr:              LISTEN_ADDR,
+		Handler:           handler,
+		ReadHeaderTimeout: conf.HTTPReadTimeout,
+		ReadTimeout:       conf.HTTPReadTimeout,
+		WriteTimeout:      conf.HTTPWriteTimeout,
+		IdleTimeout:       conf.HTTPIdleTimeout,
+	}
+}
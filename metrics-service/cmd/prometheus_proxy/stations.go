@@ -0,0 +1,18 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// StationsHandler serves /stations, every tracked station with its latest
+// conditions, most recently heard first. Useful for working out which
+// physical sensor is which id/channel.
+func (app *App) StationsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(app.state().stations); err != nil {
+		log.Printf("Could not encode stations: %s", err)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	weathermetrics "github.com/mckeowbc/weather-metrics"
+)
+
+func TestStationsListsEveryStation(t *testing.T) {
+	app, clock := newTestApp(t, nil)
+
+	app.SetTempHumidityConditions(tempHumidity(1, 60, 50))
+	first := clock.Now()
+	clock.Advance(time.Minute)
+	second := clock.Now()
+	app.SetWindRainConditions(windRain(2, 10, 90, 0.5))
+
+	w := httptest.NewRecorder()
+	app.StationsHandler(w, httptest.NewRequest("GET", "/stations", nil))
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	var stations []weathermetrics.Station
+	if err := json.Unmarshal(w.Body.Bytes(), &stations); err != nil {
+		t.Fatal(err)
+	}
+	if len(stations) != 2 {
+		t.Fatalf("got %d stations, want 2: %s", len(stations), w.Body)
+	}
+
+	// Most recently heard first
+	latest, earlier := stations[0], stations[1]
+	if latest.ID != 2 || latest.Channel != "A" || latest.Model != weathermetrics.SYNTHETIC_MODEL {
+		t.Errorf("first station = %+v, want id 2", latest)
+	}
+	if !latest.LastSeen.Equal(second) || latest.Conditions.WindSpeed != 10 {
+		t.Errorf("station 2 last seen %s with wind %v", latest.LastSeen, latest.Conditions.WindSpeed)
+	}
+
+	if earlier.ID != 1 || !earlier.LastSeen.Equal(first) || earlier.Conditions.Temp != 60 {
+		t.Errorf("second station = %+v, want id 1 at 60F", earlier)
+	}
+}
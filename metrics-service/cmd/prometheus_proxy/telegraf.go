@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	weathermetrics "github.com/mckeowbc/weather-metrics"
+)
+
+/*
+ * Telegraf
+ *
+ * /telegraf is a flat object of numbers for Telegraf's http input, which
+ * can't reach into the nested /conditions document:
+ *
+ *   [[inputs.http]]
+ *     urls = ["http://weather:8080/telegraf"]
+ *     name_override = "weather"
+ *     data_format = "json"
+ *     json_time_key = "time"
+ *     json_time_format = "unix"
+ *
+ * time is the sensor's observation time in Unix seconds. Dew point and heat
+ * index are only present once humidity has been reported.
+ */
+func (app *App) TelegrafHandler(w http.ResponseWriter, r *http.Request) {
+	state := app.state()
+	if state.lastUpdate.IsZero() {
+		http.Error(w, "no measurements received yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	c := state.conditions
+	fields := map[string]float64{
+		"temperature_F": float64(c.Temp),
+		"humidity":      float64(c.Humidity),
+		"wind_avg_km_h": float64(c.WindSpeed),
+		"wind_dir_deg":  float64(c.WindDirection),
+		"rain_in":       float64(c.RainInches),
+		"rain_daily_in": float64(state.dailyRain),
+		"battery_ok":    float64(c.Battery),
+		"wind_chill_F":  float64(c.WindChillF()),
+		"time":          float64(state.lastUpdate.Unix()),
+	}
+
+	if app.omitCalmDirection && c.WindSpeed == 0 {
+		delete(fields, "wind_dir_deg")
+	}
+
+	if t, err := weathermetrics.ParseMessageTime(c.Timestamp, app.TZ); err == nil {
+		fields["time"] = float64(t.Unix())
+	}
+
+	if c.WindGust != nil {
+		fields["wind_max_km_h"] = float64(*c.WindGust)
+	}
+
+	if c.PressureHPa > 0 {
+		fields["pressure_hPa"] = float64(c.PressureHPa)
+	}
+
+	if c.Humidity > 0 {
+		fields["dew_point_F"] = float64(c.DewPointF())
+		fields["heat_index_F"] = float64(c.HeatIndexF())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(fields); err != nil {
+		log.Printf("Could not encode telegraf fields: %s", err)
+	}
+}
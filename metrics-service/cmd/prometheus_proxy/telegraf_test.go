@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTelegrafFlatNumbers(t *testing.T) {
+	app, clock := newTestApp(t, nil)
+	app.SetTempHumidityConditions(tempHumidity(1, 70, 50))
+	app.SetWindRainConditions(windRain(1, 10, 90, 0.5))
+
+	w := httptest.NewRecorder()
+	app.TelegrafHandler(w, httptest.NewRequest("GET", "/telegraf", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	// Telegraf's json parser only takes top-level numbers, so anything
+	// nested or a string would be silently dropped
+	var fields map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &fields); err != nil {
+		t.Fatal(err)
+	}
+	for name, v := range fields {
+		if _, ok := v.(float64); !ok {
+			t.Errorf("%s = %v (%T), want a number", name, v, v)
+		}
+	}
+
+	for name, want := range map[string]float64{
+		"temperature_F": 70,
+		"humidity":      50,
+		"wind_avg_km_h": 10,
+		"wind_dir_deg":  90,
+		"rain_in":       0.5,
+		"battery_ok":    1,
+		"time":          float64(clock.Now().Unix()),
+	} {
+		if fields[name] != want {
+			t.Errorf("%s = %v, want %v", name, fields[name], want)
+		}
+	}
+
+	for _, name := range []string{"dew_point_F", "heat_index_F", "wind_chill_F", "rain_daily_in"} {
+		if _, ok := fields[name]; !ok {
+			t.Errorf("%s missing from %v", name, fields)
+		}
+	}
+}
+
+func TestTelegrafUnavailableBeforeFirstMeasurement(t *testing.T) {
+	app, _ := newTestApp(t, nil)
+
+	w := httptest.NewRecorder()
+	app.TelegrafHandler(w, httptest.NewRequest("GET", "/telegraf", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503", w.Code)
+	}
+}
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"maps"
 	"net/http"
 	"os"
 	"os/signal"
@@ -21,12 +23,14 @@ import (
 const URL = "https://weatherstation.wunderground.com/weatherstation/updateweatherstation.php"
 
 type RTL433Message struct {
-	Timestamp *time.Time
-	Data      map[string]string
+	Timestamp   *time.Time
+	MessageType int
+	Station     weathermetrics.StationKey
+	Data        map[string]float32
 }
 
 func (a *App) parseMessageTime(timestamp string) (*time.Time, error) {
-	t, err := time.ParseInLocation("2006-01-02 15:04:05", timestamp, a.TZ)
+	t, err := weathermetrics.ParseMessageTime(timestamp, a.TZ)
 
 	if err != nil {
 		return nil, err
@@ -35,147 +39,356 @@ func (a *App) parseMessageTime(timestamp string) (*time.Time, error) {
 	return &t, nil
 }
 
-func (a *App) handleWindRainMeasurement(m weathermetrics.WindRainMeasurement) map[string]string {
-	t := time.Now().In(a.TZ)
+func (a *App) handleWindRainMeasurement(m weathermetrics.WindRainMeasurement) map[string]float32 {
+	data := map[string]float32{}
 
-	if t.Hour() == 0 && t.Minute() == 0 {
-		a.LastRainFall = -1.0
+	if !m.RainMissing {
+		dailyRain := a.Rain.Observe(m.RainInches, time.Now())
+		if !a.RainWaitForReset || a.Rain.Baselined() {
+			data["dailyrainin"] = dailyRain
+		}
+	}
+
+	if !m.SpeedMissing {
+		data["windspeedmph"] = weathermetrics.KmhToMph(m.WindSpeed)
 	}
 
-	if a.LastRainFall < 0 {
-		a.LastRainFall = m.RainInches
+	// Only sensors that report wind_max_km_h have a gust
+	if m.HasGust {
+		data["windgustmph"] = weathermetrics.KmhToMph(m.WindGust)
 	}
 
-	return map[string]string{
-		"windspeedmph": fmt.Sprintf("%0.2f", m.WindSpeed*0.62137119),
-		"wind_dir":     fmt.Sprintf("%0.2f", m.WindDirection),
-		"dailyrainin":  fmt.Sprintf("%0.2f", m.RainInches-a.LastRainFall),
+	if !m.DirectionMissing {
+		data["winddir"] = m.WindDirection
 	}
+
+	return data
 }
 
-func handleTempHumidityMeasurement(m weathermetrics.TempHumidityMeasurement) map[string]string {
-	return map[string]string{
-		"tempf":    fmt.Sprintf("%0.2f", m.Temp),
-		"humidity": fmt.Sprintf("%0.2f", m.Humidity),
+func (a *App) handleTempHumidityMeasurement(m weathermetrics.TempHumidityMeasurement) map[string]float32 {
+	data := map[string]float32{
+		"tempf":    m.Temp,
+		"humidity": m.Humidity,
+	}
+
+	if m.Humidity > 0 {
+		c := weathermetrics.CurrentConditions{Temp: m.Temp, Humidity: m.Humidity}
+		data["dewptf"] = c.DewPointF()
+	}
+
+	// baromin is the sea level pressure, as on a home barometer
+	if m.PressureHPa > 0 {
+		data["baromin"] = weathermetrics.HPaToInHg(weathermetrics.SeaLevelPressureHPa(m.PressureHPa, a.AltitudeM))
 	}
+
+	return data
 }
 
-func (a *App) weatherPubHandler(c chan<- RTL433Message) mqtt.MessageHandler {
+func (a *App) weatherPubHandler(c chan<- RTL433Message, conf weathermetrics.MQTTConfig) mqtt.MessageHandler {
 	return func(client mqtt.Client, msg mqtt.Message) {
-		log.Printf("Received weather message: %s from topic: %s\n", msg.Payload(), msg.Topic())
-
-		var windRainMeasurement weathermetrics.WindRainMeasurement
+		if weathermetrics.EmptyPayload(msg.Payload()) {
+			log.Printf("Ignoring empty message from topic: %s", msg.Topic())
+			return
+		}
 
-		if err := json.Unmarshal(msg.Payload(), &windRainMeasurement); err != nil {
-			log.Printf("Could not decode json data: %s", err)
+		if conf.PayloadTooLarge(msg.Payload()) {
+			log.Printf("WARNING: dropping %d byte message from topic %s, over MQTT_MAX_PAYLOAD_SIZE", len(msg.Payload()), msg.Topic())
 			return
 		}
 
-		timestamp, err := a.parseMessageTime(windRainMeasurement.Timestamp)
+		log.Printf("Received weather message: %s from topic: %s\n", msg.Payload(), msg.Topic())
+
+		events, err := weathermetrics.DecodePayload(msg.Payload(), conf.PayloadKey)
 		if err != nil {
-			log.Printf("could not parse timestamp %s: %s", windRainMeasurement.Timestamp, err)
+			log.Printf("Could not decode json data: %s", err)
 			return
 		}
 
-		if windRainMeasurement.MessageType == weathermetrics.WIND_RAIN_MESSAGE {
-			c <- RTL433Message{
-				Timestamp: timestamp,
-				Data:      a.handleWindRainMeasurement(windRainMeasurement),
-			}
-			return
+		for _, event := range events {
+			a.handleEvent(c, event, conf.InferMessageType)
 		}
+	}
+}
 
-		var tempHumidityMeasurement weathermetrics.TempHumidityMeasurement
-		if err := json.Unmarshal(msg.Payload(), &tempHumidityMeasurement); err != nil {
-			log.Printf("Could not decode json data: %s", err)
+// handleEvent processes one reading. inferType routes payloads without a
+// message_type by their fields.
+func (a *App) handleEvent(c chan<- RTL433Message, payload []byte, inferType bool) {
+	var windRainMeasurement weathermetrics.WindRainMeasurement
+
+	if err := json.Unmarshal(payload, &windRainMeasurement); err != nil {
+		log.Printf("Could not decode json data: %s", err)
+		return
+	}
+
+	timestamp, err := a.parseMessageTime(windRainMeasurement.Timestamp)
+	if err != nil {
+		log.Printf("could not parse timestamp %s: %s", windRainMeasurement.Timestamp, err)
+		return
+	}
+
+	if err := a.Validator.CheckTimestamp(windRainMeasurement.Timestamp, a.TZ, time.Now()); err != nil {
+		log.Printf("Rejecting measurement: %s", err)
+		return
+	}
+
+	messageType := windRainMeasurement.MessageType
+	if messageType == 0 && inferType {
+		messageType = weathermetrics.InferMessageType(payload)
+	}
+
+	if messageType == weathermetrics.WIND_RAIN_MESSAGE {
+		windRainMeasurement.MessageType = messageType
+		if err := a.Validator.ValidateWindRain(&windRainMeasurement); err != nil {
+			log.Printf("Rejecting measurement: %s", err)
 			return
 		}
+		c <- RTL433Message{
+			Timestamp:   timestamp,
+			MessageType: weathermetrics.WIND_RAIN_MESSAGE,
+			Station:     weathermetrics.StationKey{ID: windRainMeasurement.ID, Channel: string(windRainMeasurement.Channel)},
+			Data:        a.handleWindRainMeasurement(windRainMeasurement),
+		}
+		return
+	}
 
-		if tempHumidityMeasurement.MessageType == weathermetrics.TEMP_HUMIDITY_MESSAGE {
-			c <- RTL433Message{
-				Timestamp: timestamp,
-				Data:      handleTempHumidityMeasurement(tempHumidityMeasurement),
-			}
+	var tempHumidityMeasurement weathermetrics.TempHumidityMeasurement
+	if err := json.Unmarshal(payload, &tempHumidityMeasurement); err != nil {
+		log.Printf("Could not decode json data: %s", err)
+		return
+	}
+
+	if messageType == weathermetrics.TEMP_HUMIDITY_MESSAGE {
+		tempHumidityMeasurement.MessageType = messageType
+		if err := a.Validator.ValidateTempHumidity(&tempHumidityMeasurement); err != nil {
+			log.Printf("Rejecting measurement: %s", err)
 			return
 		}
-
-		log.Printf("ERROR: Unrecognized message type")
+		c <- RTL433Message{
+			Timestamp:   timestamp,
+			MessageType: weathermetrics.TEMP_HUMIDITY_MESSAGE,
+			Station:     weathermetrics.StationKey{ID: tempHumidityMeasurement.ID, Channel: string(tempHumidityMeasurement.Channel)},
+			Data:        a.handleTempHumidityMeasurement(tempHumidityMeasurement),
+		}
+		return
 	}
+
+	log.Printf("ERROR: Unrecognized message type")
 }
 
 type App struct {
-	LastRainFall float32
-	TZ           *time.Location
+	Rain      *weathermetrics.RainAccumulator
+	TZ        *time.Location
+	Validator *weathermetrics.Validator
+	AltitudeM float64
+
+	// RainWaitForReset holds back dailyrainin until the rain day has reset
+	// once, so a mid-day start doesn't report a falsely low total
+	RainWaitForReset bool
 }
 
-func NewApp(tz string) (App, error) {
-	timezone, err := time.LoadLocation(tz)
+func NewApp(conf PWSConfig, validationConf weathermetrics.ValidationConfig) (App, error) {
+	timezone, err := conf.Location()
 	if err != nil {
 		return App{}, err
 	}
 
-	return App{LastRainFall: -1.0, TZ: timezone}, nil
+	validator, err := weathermetrics.NewValidator(validationConf)
+	if err != nil {
+		return App{}, err
+	}
+
+	rainReset, err := weathermetrics.ParseResetTime(conf.RainResetTime)
+	if err != nil {
+		return App{}, err
+	}
+
+	return App{
+		Rain:             weathermetrics.NewRainAccumulator(timezone, conf.RainDeadband, rainReset),
+		TZ:               timezone,
+		Validator:        validator,
+		AltitudeM:        conf.AltitudeM,
+		RainWaitForReset: conf.RainWaitForReset,
+	}, nil
 }
 
 type PWSConfig struct {
-	Key string
+	weathermetrics.TimezoneConfig
+
+	Key string `secret:"true"`
 	ID  string
-	TZ  string `default:"America/New_York"`
+
+	// WarmupTimeout bounds how long the first submission waits for both a
+	// temp/humidity and a wind/rain message
+	WarmupTimeout time.Duration `split_words:"true" default:"5m"`
+
+	// FieldMaxAge drops an individual field from a submission once its newest
+	// reading is older than this
+	FieldMaxAge time.Duration `split_words:"true" default:"5m"`
+
+	// RainDeadband is the largest drop in the rain counter treated as noise
+	RainDeadband float32 `split_words:"true" default:"0.02"`
+
+	// AltitudeM is the station's height above sea level in metres, used to
+	// reduce pressure to sea level for baromin
+	AltitudeM float64 `split_words:"true" default:"0"`
+
+	// RainResetTime is the local time of day, HH:MM, at which dailyrainin
+	// starts over. Weather Underground expects midnight.
+	RainResetTime string `split_words:"true" default:"00:00"`
+
+	// RainWaitForReset leaves dailyrainin out of submissions until the
+	// first daily reset after startup
+	RainWaitForReset bool `split_words:"true" default:"false"`
+
+	// FinalSubmit uploads the buffered reading on shutdown if it is fresh
+	FinalSubmit        bool          `split_words:"true" default:"false"`
+	FinalSubmitTimeout time.Duration `split_words:"true" default:"10s"`
+
+	// UserAgent is sent with every upload. Empty means
+	// weather-station/<version>.
+	UserAgent string `split_words:"true"`
+}
+
+/*
+ * Warm-up
+ *
+ * The 5n1 sends temp/humidity and wind/rain in separate messages, so the first
+ * window after startup often only has half a reading. Hold off the first
+ * submission until both have arrived or the deadline passes.
+ */
+type warmup struct {
+	deadline time.Time
+	seen     map[int]bool
+	done     bool
+}
+
+func newWarmup(now time.Time, timeout time.Duration) *warmup {
+	return &warmup{deadline: now.Add(timeout), seen: make(map[int]bool)}
+}
+
+func (w *warmup) Observe(messageType int) {
+	w.seen[messageType] = true
+}
+
+func (w *warmup) Ready(now time.Time) bool {
+	if w.done {
+		return true
+	}
+
+	w.done = (w.seen[weathermetrics.TEMP_HUMIDITY_MESSAGE] && w.seen[weathermetrics.WIND_RAIN_MESSAGE]) ||
+		!now.Before(w.deadline)
+	return w.done
+}
+
+// resolveCredentials picks the station id and key. Each is taken from its
+// flag if the flag is non-empty, otherwise from the environment, where
+// CONFIG_FILE supplies variables that aren't set; it is an error for either
+// to be missing from both.
+func resolveCredentials(flagID, flagKey, envID, envKey string) (id, key string, err error) {
+	id, key = flagID, flagKey
+	if id == "" {
+		id = envID
+	}
+	if key == "" {
+		key = envKey
+	}
+
+	missing := []string{}
+	if id == "" {
+		missing = append(missing, "PWS_ID or --id")
+	}
+	if key == "" {
+		missing = append(missing, "PWS_KEY or --key")
+	}
+	if len(missing) > 0 {
+		return "", "", fmt.Errorf("must set %s", strings.Join(missing, " and "))
+	}
+
+	return id, key, nil
 }
 
 func main() {
+	buildInfo := weathermetrics.GetBuildInfo()
+	log.Printf("pws_publisher %s (commit %s, built %s)",
+		buildInfo.Version, buildInfo.Commit, buildInfo.BuildTime)
+
 	key := flag.String("key", "", "PWS Key")
 	id := flag.String("id", "", "PWS ID")
 	flag.Parse()
 
+	if err := weathermetrics.LoadConfigFile(); err != nil {
+		log.Fatal(err)
+	}
+
 	var mqttConf weathermetrics.MQTTConfig
 	if err := envconfig.Process("weather", &mqttConf); err != nil {
 		log.Fatal(err)
 	}
 
+	if err := mqttConf.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
 	if len(mqttConf.Username) > 0 && len(mqttConf.Password) == 0 ||
 		len(mqttConf.Username) == 0 && len(mqttConf.Password) > 0 {
 		log.Fatal("Error: Must specify both username and password")
 	}
 
+	if len(mqttConf.Topic) == 0 {
+		log.Fatal("Error: MQTT_TOPIC is empty, nothing to subscribe to")
+	}
+
 	var pwsConf PWSConfig
 	if err := envconfig.Process("pws", &pwsConf); err != nil {
 		log.Fatal(err)
 	}
 
-	if *key == "" {
-		*key = pwsConf.Key
+	var err error
+	pwsConf.ID, pwsConf.Key, err = resolveCredentials(*id, *key, pwsConf.ID, pwsConf.Key)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	if *id == "" {
-		*id = pwsConf.ID
+	if err := pwsConf.TimezoneConfig.Validate(); err != nil {
+		log.Fatal(err)
 	}
 
-	if *key == "" || *id == "" {
-		log.Fatal("Must set PWS_KEY and PWS_ID")
+	var validationConf weathermetrics.ValidationConfig
+	if err := envconfig.Process("weather", &validationConf); err != nil {
+		log.Fatal(err)
 	}
 
-	app, err := NewApp(pwsConf.TZ)
+	effectiveConfig := weathermetrics.EffectiveConfig("", mqttConf)
+	maps.Copy(effectiveConfig, weathermetrics.EffectiveConfig("", validationConf))
+	maps.Copy(effectiveConfig, weathermetrics.EffectiveConfig("PWS_", pwsConf))
+	weathermetrics.LogEffectiveConfig(effectiveConfig)
+
+	app, err := NewApp(pwsConf, validationConf)
 
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	client, _ := weathermetrics.NewMQTTClient(mqttConf)
+	httpClient := weathermetrics.NewHTTPClient(pwsConf.UserAgent, 30*time.Second)
+
+	c := make(chan RTL433Message)
+	client, _ := weathermetrics.NewMQTTClient(mqttConf, nil, weathermetrics.Subscription{
+		Topic:   mqttConf.Topic,
+		Handler: app.weatherPubHandler(c, mqttConf),
+	})
 
-	log.Printf("Connecting to %s", fmt.Sprintf("tcp://%s", mqttConf.MQTTServer))
+	log.Printf("Connecting to %s", strings.Join(mqttConf.Brokers(), ", "))
 
 	if token := client.Connect(); token.Wait() && token.Error() != nil {
 		panic(token.Error())
 	}
 
-	c := make(chan RTL433Message)
-	sub(client, mqttConf.Topic, app.weatherPubHandler(c))
 	defer MQTTClose(client, mqttConf.Topic)
 
 	timer := time.After(time.Second * 60)
 
-	data := RTL433Message{Data: make(map[string]string)}
+	data := newWindow()
+	warm := newWarmup(time.Now(), pwsConf.WarmupTimeout)
 
 	// Wait for interrupt signal to gracefully shutdown the subscriber
 	sigChan := make(chan os.Signal, 1)
@@ -185,39 +398,68 @@ outerloop:
 	for {
 		select {
 		case msg := <-c:
-			data.Timestamp = msg.Timestamp
-			for key := range msg.Data {
-				data.Data[key] = msg.Data[key]
-			}
+			warm.Observe(msg.MessageType)
+			data.Add(msg)
 
 		case <-timer:
-			d := time.Since(*data.Timestamp)
+			timer = time.After(time.Second * 60)
 
-			if d.Minutes() > 5 {
-				log.Printf("timestamp is more than 5 minutes out of date: %v",
-					*data.Timestamp,
-				)
+			if !warm.Ready(time.Now()) {
+				log.Printf("waiting for both temp/humidity and wind/rain messages before first submission")
 				continue outerloop
 			}
 
-			resp, err := submitMeasurement(*id, *key, data.Data)
-
-			if err != nil {
+			timestamp, values := data.Take(time.Now(), pwsConf.FieldMaxAge)
+			if err := submit(context.Background(), httpClient, pwsConf.ID, pwsConf.Key, timestamp, values); err != nil {
 				log.Print(err)
-				continue outerloop
 			}
-
-			defer resp.Body.Close()
-			body, _ := io.ReadAll(resp.Body)
-			log.Printf("%d %s", resp.StatusCode, body)
-			timer = time.After(time.Second * 60)
 		case <-sigChan:
+			if pwsConf.FinalSubmit {
+				log.Printf("submitting final measurement before shutdown")
+				if err := finalSubmit(httpClient, pwsConf, data, time.Now()); err != nil {
+					log.Printf("final submission failed: %s", err)
+				}
+			}
 			break outerloop
 		}
 	}
 }
 
-func submitMeasurement(id, key string, values map[string]string) (*http.Response, error) {
+// finalSubmit uploads what the window holds on shutdown, giving up after
+// FinalSubmitTimeout so a slow endpoint can't hold up the exit
+func finalSubmit(client *http.Client, conf PWSConfig, data *window, now time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), conf.FinalSubmitTimeout)
+	defer cancel()
+
+	timestamp, values := data.Take(now, conf.FieldMaxAge)
+	return submit(ctx, client, conf.ID, conf.Key, timestamp, values)
+}
+
+// submit uploads values to PWS unless they are missing or stale
+func submit(ctx context.Context, client *http.Client, id, key string, timestamp *time.Time, values map[string]string) error {
+	if timestamp == nil {
+		return fmt.Errorf("no measurements received in this window")
+	}
+
+	d := time.Since(*timestamp)
+
+	if d.Minutes() > 5 {
+		return fmt.Errorf("timestamp is more than 5 minutes out of date: %v", *timestamp)
+	}
+
+	resp, err := submitMeasurement(ctx, client, id, key, values)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	log.Printf("%d %s", resp.StatusCode, body)
+	return nil
+}
+
+func submitMeasurement(ctx context.Context, client *http.Client, id, key string, values map[string]string) (*http.Response, error) {
 	mdict := map[string]string{
 		"ID":       id,
 		"PASSWORD": key,
@@ -239,14 +481,13 @@ func submitMeasurement(id, key string, values map[string]string) (*http.Response
 	}
 
 	queryString := strings.Join(queryParams, "&")
-	log.Println(URL + "?" + queryString)
-	return http.Get(URL + "?" + queryString)
-}
+	log.Println(URL + "?" + strings.Replace(queryString, "PASSWORD="+key, "PASSWORD="+weathermetrics.REDACTED_VALUE, 1))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, URL+"?"+queryString, nil)
+	if err != nil {
+		return nil, err
+	}
 
-func sub(client mqtt.Client, topic string, handler mqtt.MessageHandler) {
-	token := client.Subscribe(topic, 1, handler)
-	token.Wait()
-	log.Printf("Subscribed to topic: %s", topic)
+	return client.Do(req)
 }
 
 func MQTTClose(client mqtt.Client, topic string) {
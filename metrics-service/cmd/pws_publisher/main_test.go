@@ -0,0 +1,440 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+	weathermetrics "github.com/mckeowbc/weather-metrics"
+)
+
+func TestMain(m *testing.M) {
+	// Every message is logged; keep test output readable
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func TestWarmupWaitsForBothHalves(t *testing.T) {
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	warm := newWarmup(start, 5*time.Minute)
+
+	if warm.Ready(start.Add(time.Minute)) {
+		t.Fatal("ready before any message")
+	}
+
+	warm.Observe(weathermetrics.TEMP_HUMIDITY_MESSAGE)
+	warm.Observe(weathermetrics.TEMP_HUMIDITY_MESSAGE)
+	if warm.Ready(start.Add(2 * time.Minute)) {
+		t.Fatal("ready with only temp/humidity")
+	}
+
+	warm.Observe(weathermetrics.WIND_RAIN_MESSAGE)
+	if !warm.Ready(start.Add(2 * time.Minute)) {
+		t.Fatal("not ready with both halves")
+	}
+}
+
+func TestWarmupGivesUpAtDeadline(t *testing.T) {
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	warm := newWarmup(start, 5*time.Minute)
+	warm.Observe(weathermetrics.WIND_RAIN_MESSAGE)
+
+	if warm.Ready(start.Add(5*time.Minute - time.Second)) {
+		t.Fatal("ready before the deadline with one half")
+	}
+	if !warm.Ready(start.Add(5 * time.Minute)) {
+		t.Fatal("not ready at the deadline")
+	}
+}
+
+func TestWarmupStaysReady(t *testing.T) {
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	warm := newWarmup(start, 5*time.Minute)
+	warm.Observe(weathermetrics.TEMP_HUMIDITY_MESSAGE)
+	warm.Observe(weathermetrics.WIND_RAIN_MESSAGE)
+
+	if !warm.Ready(start) {
+		t.Fatal("not ready with both halves")
+	}
+
+	// Readiness is only checked going forward, but a clock step back must
+	// not hold submissions up again
+	if !warm.Ready(start.Add(-time.Hour)) {
+		t.Error("warm-up gate closed again once open")
+	}
+}
+
+// roundTripFunc stands in for Wunderground
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func okResponse(req *http.Request) *http.Response {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader("success")),
+		Request:    req,
+	}
+}
+
+func finalSubmitConfig() PWSConfig {
+	return PWSConfig{
+		ID:                 "KSTATION1",
+		Key:                "secret",
+		FieldMaxAge:        5 * time.Minute,
+		FinalSubmitTimeout: 100 * time.Millisecond,
+	}
+}
+
+func TestFinalSubmitSendsBufferedReading(t *testing.T) {
+	var query url.Values
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		query = req.URL.Query()
+		return okResponse(req), nil
+	})}
+
+	now := time.Now()
+	data := newWindow()
+	data.Add(RTL433Message{Timestamp: &now, Data: map[string]float32{"tempf": 69.1, "humidity": 97}})
+
+	if err := finalSubmit(client, finalSubmitConfig(), data, now); err != nil {
+		t.Fatal(err)
+	}
+
+	if query.Get("ID") != "KSTATION1" || query.Get("tempf") != "69.1" || query.Get("humidity") != "97" {
+		t.Errorf("submitted %v", query)
+	}
+
+	// The window was consumed
+	if timestamp, values := data.Take(now, time.Minute); timestamp != nil || len(values) != 0 {
+		t.Errorf("window still holds %v", values)
+	}
+}
+
+func TestFinalSubmitSkipsStaleReading(t *testing.T) {
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		t.Errorf("submitted a stale reading: %s", req.URL)
+		return okResponse(req), nil
+	})}
+
+	old := time.Now().Add(-10 * time.Minute)
+	data := newWindow()
+	data.Add(RTL433Message{Timestamp: &old, Data: map[string]float32{"tempf": 69.1}})
+
+	if err := finalSubmit(client, finalSubmitConfig(), data, time.Now()); err == nil {
+		t.Error("stale reading submitted without error")
+	}
+}
+
+func TestFinalSubmitGivesUpAtTimeout(t *testing.T) {
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	})}
+
+	now := time.Now()
+	data := newWindow()
+	data.Add(RTL433Message{Timestamp: &now, Data: map[string]float32{"tempf": 69.1}})
+
+	start := time.Now()
+	err := finalSubmit(client, finalSubmitConfig(), data, now)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the deadline to pass", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("final submission took %s", elapsed)
+	}
+}
+
+// newTestApp builds an App the way main does, from PWS_ and WEATHER_
+// variables on top of the defaults
+func newTestApp(t *testing.T, env map[string]string) App {
+	t.Helper()
+
+	for name, value := range env {
+		t.Setenv(name, value)
+	}
+
+	var conf PWSConfig
+	if err := envconfig.Process("pws", &conf); err != nil {
+		t.Fatal(err)
+	}
+
+	var validationConf weathermetrics.ValidationConfig
+	if err := envconfig.Process("weather", &validationConf); err != nil {
+		t.Fatal(err)
+	}
+
+	app, err := NewApp(conf, validationConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return app
+}
+
+func TestWindRainFieldNames(t *testing.T) {
+	app := newTestApp(t, nil)
+
+	data := app.handleWindRainMeasurement(weathermetrics.WindRainMeasurement{
+		WindSpeed:     16.0934,
+		WindDirection: 157.5,
+		RainInches:    0.5,
+	})
+	for _, key := range []string{"windspeedmph", "winddir", "dailyrainin"} {
+		if _, ok := data[key]; !ok {
+			t.Errorf("%s missing from %v", key, data)
+		}
+	}
+	if len(data) != 3 {
+		t.Errorf("unexpected fields in %v", data)
+	}
+
+	w := newWindow()
+	at := windowStart
+	w.Add(RTL433Message{Timestamp: &at, MessageType: weathermetrics.WIND_RAIN_MESSAGE, Data: data})
+	values := w.Values(at, 5*time.Minute)
+	if values["windspeedmph"] != "10.0" {
+		t.Errorf("windspeedmph = %q, want 10.0", values["windspeedmph"])
+	}
+	if values["winddir"] != "158" {
+		t.Errorf("winddir = %q, want whole degrees 158", values["winddir"])
+	}
+}
+
+func TestWindDirectionRoundsIntoRange(t *testing.T) {
+	for _, tc := range []struct {
+		degrees float32
+		want    string
+	}{
+		{degrees: 0, want: "0"},
+		{degrees: 0.4, want: "0"},
+		{degrees: 44.5, want: "45"},
+		{degrees: 359.4, want: "359"},
+		{degrees: 359.6, want: "0"},
+	} {
+		w := newWindow()
+		addAt(w, 0, weathermetrics.WIND_RAIN_MESSAGE, map[string]float32{"windspeedmph": 5, "winddir": tc.degrees})
+		if got := w.Values(windowStart, 5*time.Minute)["winddir"]; got != tc.want {
+			t.Errorf("winddir %v = %q, want %q", tc.degrees, got, tc.want)
+		}
+	}
+}
+
+func TestGustSubmittedOnlyWhenReported(t *testing.T) {
+	app := newTestApp(t, nil)
+
+	data := app.handleWindRainMeasurement(weathermetrics.WindRainMeasurement{
+		WindSpeed: 16.0934,
+		WindGust:  32.1868,
+		HasGust:   true,
+	})
+	if got, ok := data["windgustmph"]; !ok || math.Abs(float64(got-20)) > 0.01 {
+		t.Errorf("windgustmph = %v, want 20", got)
+	}
+
+	w := newWindow()
+	at := windowStart
+	w.Add(RTL433Message{Timestamp: &at, MessageType: weathermetrics.WIND_RAIN_MESSAGE, Data: data})
+	if got := w.Values(at, 5*time.Minute)["windgustmph"]; got != "20.0" {
+		t.Errorf("submitted windgustmph = %q, want 20.0", got)
+	}
+
+	// A sensor without wind_max_km_h decodes to a zero gust, not a calm one
+	data = app.handleWindRainMeasurement(weathermetrics.WindRainMeasurement{WindSpeed: 16.0934})
+	if _, ok := data["windgustmph"]; ok {
+		t.Errorf("windgustmph sent without a gust reading: %v", data)
+	}
+}
+
+func TestDewPointAndPressureFields(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		altitude string
+		m        weathermetrics.TempHumidityMeasurement
+		want     map[string]string
+	}{
+		{
+			name:     "sea level",
+			altitude: "0",
+			m:        weathermetrics.TempHumidityMeasurement{Temp: 68, Humidity: 50, PressureHPa: 1013.25},
+			want:     map[string]string{"tempf": "68.0", "humidity": "50", "dewptf": "48.7", "baromin": "29.92"},
+		},
+		{
+			name:     "reduced to sea level",
+			altitude: "100",
+			m:        weathermetrics.TempHumidityMeasurement{Temp: 68, Humidity: 50, PressureHPa: 1013.25},
+			want:     map[string]string{"tempf": "68.0", "humidity": "50", "dewptf": "48.7", "baromin": "30.28"},
+		},
+		{
+			// The 5n1 has no barometer, and no humidity means no dew point
+			name:     "no pressure or humidity",
+			altitude: "0",
+			m:        weathermetrics.TempHumidityMeasurement{Temp: 68},
+			want:     map[string]string{"tempf": "68.0", "humidity": "0"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			app := newTestApp(t, map[string]string{"PWS_ALTITUDE_M": tc.altitude})
+
+			values := map[string]string{}
+			for key, v := range app.handleTempHumidityMeasurement(tc.m) {
+				values[key] = weathermetrics.FormatPWSValue(key, v)
+			}
+			if !maps.Equal(values, tc.want) {
+				t.Errorf("got %v, want %v", values, tc.want)
+			}
+		})
+	}
+}
+
+func TestRainWaitForResetHoldsBackDailyRain(t *testing.T) {
+	reading := weathermetrics.WindRainMeasurement{RainInches: 1.25}
+
+	app := newTestApp(t, map[string]string{"PWS_RAIN_WAIT_FOR_RESET": "true"})
+	data := app.handleWindRainMeasurement(reading)
+	if _, ok := data["dailyrainin"]; ok {
+		t.Errorf("dailyrainin sent from a startup baseline: %v", data)
+	}
+
+	// A baseline restored for today counts as known
+	app = newTestApp(t, map[string]string{"PWS_RAIN_WAIT_FOR_RESET": "true"})
+	now := time.Now()
+	app.Rain.Restore(weathermetrics.RainState{
+		Date:     app.Rain.DayStart(now).Format("2006-01-02"),
+		Baseline: 1.00,
+		Last:     1.25,
+	}, now)
+	if got, ok := app.handleWindRainMeasurement(reading)["dailyrainin"]; !ok || got != 0.25 {
+		t.Errorf("dailyrainin = %v, %v after restoring today's baseline, want 0.25", got, ok)
+	}
+
+	app = newTestApp(t, map[string]string{"PWS_RAIN_WAIT_FOR_RESET": "false"})
+	if got, ok := app.handleWindRainMeasurement(reading)["dailyrainin"]; !ok || got != 0 {
+		t.Errorf("dailyrainin = %v, %v without waiting, want 0", got, ok)
+	}
+}
+
+func TestResolveCredentials(t *testing.T) {
+	type source struct{ id, key string }
+
+	for _, tc := range []struct {
+		name                  string
+		flag, env, file       source
+		wantID, wantKey       string
+		wantErr, wantNotInErr string
+	}{
+		{name: "flag only", flag: source{"KFLAG1", "flagkey"}, wantID: "KFLAG1", wantKey: "flagkey"},
+		{name: "env only", env: source{"KENV1", "envkey"}, wantID: "KENV1", wantKey: "envkey"},
+		{name: "file only", file: source{"KFILE1", "filekey"}, wantID: "KFILE1", wantKey: "filekey"},
+		{
+			name: "flag over env", flag: source{"KFLAG1", "flagkey"}, env: source{"KENV1", "envkey"},
+			wantID: "KFLAG1", wantKey: "flagkey",
+		},
+		{
+			name: "env over file", env: source{"KENV1", "envkey"}, file: source{"KFILE1", "filekey"},
+			wantID: "KENV1", wantKey: "envkey",
+		},
+		{
+			name: "flag over env and file", flag: source{"KFLAG1", "flagkey"}, env: source{"KENV1", "envkey"},
+			file: source{"KFILE1", "filekey"}, wantID: "KFLAG1", wantKey: "flagkey",
+		},
+		{
+			// An empty flag is its default, not a request for an empty key
+			name: "each from its own source", flag: source{"KFLAG1", ""}, file: source{"", "filekey"},
+			wantID: "KFLAG1", wantKey: "filekey",
+		},
+		{name: "neither", wantErr: "PWS_ID or --id and PWS_KEY or --key"},
+		{name: "key missing", env: source{"KENV1", ""}, wantErr: "PWS_KEY or --key", wantNotInErr: "PWS_ID"},
+		{name: "id missing", flag: source{"", "flagkey"}, wantErr: "PWS_ID or --id", wantNotInErr: "PWS_KEY"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// Unset rather than empty, so the config file can fill them in
+			for _, name := range []string{"PWS_ID", "PWS_KEY", "CONFIG_FILE"} {
+				t.Setenv(name, "")
+				os.Unsetenv(name)
+			}
+			if tc.env.id != "" {
+				t.Setenv("PWS_ID", tc.env.id)
+			}
+			if tc.env.key != "" {
+				t.Setenv("PWS_KEY", tc.env.key)
+			}
+
+			if tc.file != (source{}) {
+				path := filepath.Join(t.TempDir(), "config.yaml")
+				yaml := fmt.Sprintf("PWS_ID: %q\nPWS_KEY: %q\n", tc.file.id, tc.file.key)
+				if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+					t.Fatal(err)
+				}
+				t.Setenv("CONFIG_FILE", path)
+			}
+
+			// As main resolves them
+			if err := weathermetrics.LoadConfigFile(); err != nil {
+				t.Fatal(err)
+			}
+			var conf PWSConfig
+			if err := envconfig.Process("pws", &conf); err != nil {
+				t.Fatal(err)
+			}
+			id, key, err := resolveCredentials(tc.flag.id, tc.flag.key, conf.ID, conf.Key)
+
+			if tc.wantErr != "" {
+				if err == nil {
+					t.Fatalf("got %q, %q, want an error", id, key)
+				}
+				if !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("error %q doesn't mention %s", err, tc.wantErr)
+				}
+				if tc.wantNotInErr != "" && strings.Contains(err.Error(), tc.wantNotInErr) {
+					t.Errorf("error %q blames %s, which was set", err, tc.wantNotInErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id != tc.wantID || key != tc.wantKey {
+				t.Errorf("got %q, %q, want %q, %q", id, key, tc.wantID, tc.wantKey)
+			}
+		})
+	}
+}
+
+func TestNegativeRainLeavesDailyRainAlone(t *testing.T) {
+	app := newTestApp(t, nil)
+	messages := make(chan RTL433Message, 4)
+
+	timestamp := time.Now().In(app.TZ).Format(weathermetrics.RTL433_TIME_FORMAT)
+	for _, rain := range []string{"0.23", "0.23", "-0.5", "0.23"} {
+		app.handleEvent(messages, []byte(`{"time":"`+timestamp+`","model":"Acurite-5n1","id":1,"channel":"A","message_type":49,"wind_avg_km_h":5,"wind_dir_deg":90,"rain_in":`+rain+`}`), false)
+	}
+	close(messages)
+
+	var daily []float32
+	for msg := range messages {
+		if v, ok := msg.Data["dailyrainin"]; ok {
+			daily = append(daily, v)
+		}
+	}
+
+	// The bad reading sends no dailyrainin, and the one after it no rain
+	if len(daily) != 3 || daily[2] != 0 {
+		t.Errorf("dailyrainin %v, want three zeros", daily)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"log"
+	"math"
+	"time"
+
+	weathermetrics "github.com/mckeowbc/weather-metrics"
+)
+
+/*
+ * Averaging window
+ *
+ * The 5n1 alternates temp/humidity and wind/rain messages, so one submission
+ * window usually holds several of each. Fields are averaged over the window;
+ * wind is vector averaged so that readings either side of north don't average
+ * out to south. dailyrainin is a running total and keeps the latest value;
+ * windgustmph keeps the window's highest gust.
+ *
+ * Each field also remembers the newest sensor timestamp it was seen with, so a
+ * field whose readings are all older than the freshness limit is dropped on
+ * its own rather than holding back the whole submission.
+ *
+ * Some stations only send a direction with their gust message, so each
+ * station's last direction is held, across windows too, and paired with
+ * the speeds that arrive without one.
+ */
+type window struct {
+	timestamp *time.Time
+
+	sums   map[string]float64
+	counts map[string]int
+
+	wind []weathermetrics.WindSample
+
+	latest map[string]float32
+	peaks  map[string]float32
+	seen   map[string]time.Time
+
+	directions map[weathermetrics.StationKey]heldDirection
+}
+
+// heldDirection is a station's last reported wind direction and the sensor
+// time it was reported at
+type heldDirection struct {
+	degrees float32
+	at      time.Time
+}
+
+// Fields that carry a running total rather than a sample
+var windowLatestFields = map[string]bool{
+	"dailyrainin": true,
+}
+
+// Fields that report the window's maximum
+var windowPeakFields = map[string]bool{
+	"windgustmph": true,
+}
+
+func newWindow() *window {
+	w := &window{directions: make(map[weathermetrics.StationKey]heldDirection)}
+	w.Reset()
+	return w
+}
+
+func (w *window) Reset() {
+	w.timestamp = nil
+	w.sums = make(map[string]float64)
+	w.counts = make(map[string]int)
+	w.wind = nil
+	w.latest = make(map[string]float32)
+	w.peaks = make(map[string]float32)
+	w.seen = make(map[string]time.Time)
+}
+
+func (w *window) Add(msg RTL433Message) {
+	w.timestamp = msg.Timestamp
+
+	if dir, ok := msg.Data["winddir"]; ok {
+		held := heldDirection{degrees: dir}
+		if msg.Timestamp != nil {
+			held.at = *msg.Timestamp
+		}
+		w.directions[msg.Station] = held
+	}
+
+	if speed, ok := msg.Data["windspeedmph"]; ok {
+		if held, ok := w.directions[msg.Station]; ok {
+			w.wind = append(w.wind, weathermetrics.WindSample{Speed: speed, Direction: held.degrees})
+			if held.at.After(w.seen["winddir"]) {
+				w.seen["winddir"] = held.at
+			}
+		}
+	}
+
+	for key, v := range msg.Data {
+		if msg.Timestamp != nil && msg.Timestamp.After(w.seen[key]) {
+			w.seen[key] = *msg.Timestamp
+		}
+
+		switch {
+		case key == "winddir":
+			continue
+		case windowLatestFields[key]:
+			w.latest[key] = v
+		case windowPeakFields[key]:
+			if peak, ok := w.peaks[key]; !ok || v > peak {
+				w.peaks[key] = v
+			}
+		default:
+			w.sums[key] += float64(v)
+			w.counts[key]++
+		}
+	}
+}
+
+// Take returns the newest timestamp and Values, then resets the window so
+// nothing consumed by one submission is sent again by the next
+func (w *window) Take(now time.Time, maxAge time.Duration) (*time.Time, map[string]string) {
+	timestamp, values := w.timestamp, w.Values(now, maxAge)
+	w.Reset()
+	return timestamp, values
+}
+
+// Values returns the window's averages formatted for submission, leaving out
+// fields last seen more than maxAge before now
+func (w *window) Values(now time.Time, maxAge time.Duration) map[string]string {
+	values := make(map[string]string)
+
+	for key, sum := range w.sums {
+		values[key] = weathermetrics.FormatPWSValue(key, float32(sum/float64(w.counts[key])))
+	}
+
+	for key, v := range w.latest {
+		values[key] = weathermetrics.FormatPWSValue(key, v)
+	}
+
+	for key, v := range w.peaks {
+		values[key] = weathermetrics.FormatPWSValue(key, v)
+	}
+
+	if dir, ok := weathermetrics.MeanDirection(w.wind); ok {
+		// Wunderground wants whole degrees 0-359, so 359.6 becomes 0
+		rounded := math.Mod(math.Round(float64(dir)), 360)
+		values["winddir"] = weathermetrics.FormatPWSValue("winddir", float32(rounded))
+	}
+
+	for key := range values {
+		if age := now.Sub(w.seen[key]); age > maxAge {
+			log.Printf("dropping %s, last seen %s ago", key, age.Round(time.Second))
+			delete(values, key)
+		}
+	}
+
+	return values
+}
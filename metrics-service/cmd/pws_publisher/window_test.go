@@ -0,0 +1,181 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	weathermetrics "github.com/mckeowbc/weather-metrics"
+)
+
+var windowStart = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// addAt adds a message stamped offset into the window
+func addAt(w *window, offset time.Duration, messageType int, data map[string]float32) {
+	at := windowStart.Add(offset)
+	w.Add(RTL433Message{Timestamp: &at, MessageType: messageType, Data: data})
+}
+
+func TestWindowAveragesMultipleMessages(t *testing.T) {
+	w := newWindow()
+	addAt(w, 0, 56, map[string]float32{"tempf": 68, "humidity": 50})
+	addAt(w, 18*time.Second, 49, map[string]float32{"windspeedmph": 4, "winddir": 350, "dailyrainin": 0.10, "windgustmph": 9})
+	addAt(w, 36*time.Second, 56, map[string]float32{"tempf": 70, "humidity": 54})
+	addAt(w, 54*time.Second, 49, map[string]float32{"windspeedmph": 6, "winddir": 10, "dailyrainin": 0.12, "windgustmph": 7})
+
+	values := w.Values(windowStart.Add(time.Minute), 5*time.Minute)
+	for key, want := range map[string]string{
+		"tempf":        "69.0",
+		"humidity":     "52",
+		"windspeedmph": "5.0",
+		"winddir":      "2",
+		"dailyrainin":  "0.12",
+		"windgustmph":  "9.0",
+	} {
+		if got := values[key]; got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestWindowVectorAveragesAcrossNorth(t *testing.T) {
+	w := newWindow()
+	addAt(w, 0, 49, map[string]float32{"windspeedmph": 5, "winddir": 340})
+	addAt(w, 30*time.Second, 49, map[string]float32{"windspeedmph": 5, "winddir": 20})
+
+	// An arithmetic mean would say 180, straight into the wind
+	if got := w.Values(windowStart.Add(time.Minute), 5*time.Minute)["winddir"]; got != "0" {
+		t.Errorf("winddir = %q, want 0", got)
+	}
+}
+
+func TestWindowTakeResets(t *testing.T) {
+	w := newWindow()
+	addAt(w, 0, 56, map[string]float32{"tempf": 60})
+	addAt(w, 30*time.Second, 56, map[string]float32{"tempf": 62})
+
+	timestamp, values := w.Take(windowStart.Add(time.Minute), 5*time.Minute)
+	if timestamp == nil || values["tempf"] != "61.0" {
+		t.Fatalf("first window: %v %v", timestamp, values)
+	}
+
+	// The next window only averages its own readings
+	addAt(w, 90*time.Second, 56, map[string]float32{"tempf": 70})
+	if _, values := w.Take(windowStart.Add(2*time.Minute), 5*time.Minute); values["tempf"] != "70.0" {
+		t.Errorf("second window tempf = %q, want 70.0", values["tempf"])
+	}
+
+	if timestamp, values := w.Take(windowStart.Add(3*time.Minute), 5*time.Minute); timestamp != nil || len(values) != 0 {
+		t.Errorf("empty window returned %v %v", timestamp, values)
+	}
+}
+
+func TestWindowDropsStaleFieldsIndividually(t *testing.T) {
+	w := newWindow()
+	addAt(w, 0, 56, map[string]float32{"tempf": 68, "humidity": 50})
+	addAt(w, 4*time.Minute, 49, map[string]float32{"windspeedmph": 5, "winddir": 90, "dailyrainin": 0.1})
+
+	// At 6 minutes temp/humidity are 6 minutes old, wind/rain 2
+	values := w.Values(windowStart.Add(6*time.Minute), 5*time.Minute)
+
+	for _, key := range []string{"tempf", "humidity"} {
+		if v, ok := values[key]; ok {
+			t.Errorf("stale %s = %s submitted", key, v)
+		}
+	}
+	for _, key := range []string{"windspeedmph", "winddir", "dailyrainin"} {
+		if _, ok := values[key]; !ok {
+			t.Errorf("fresh %s dropped", key)
+		}
+	}
+}
+
+func TestWindowFieldAgeIsItsNewestReading(t *testing.T) {
+	w := newWindow()
+	addAt(w, 0, 56, map[string]float32{"tempf": 60})
+	addAt(w, 3*time.Minute, 56, map[string]float32{"tempf": 62})
+
+	// The older reading is past the limit but the newer one isn't, so the
+	// field stays and still averages both
+	values := w.Values(windowStart.Add(7*time.Minute), 5*time.Minute)
+	if values["tempf"] != "61.0" {
+		t.Errorf("tempf = %q, want 61.0", values["tempf"])
+	}
+}
+
+func TestWindowDoesNotResubmitConsumedFields(t *testing.T) {
+	w := newWindow()
+	addAt(w, 0, 49, map[string]float32{"windspeedmph": 5, "winddir": 90, "windgustmph": 12, "dailyrainin": 0.1})
+	addAt(w, 30*time.Second, 56, map[string]float32{"tempf": 60, "humidity": 50})
+	w.Take(windowStart.Add(time.Minute), 5*time.Minute)
+
+	// Only temp/humidity arrive in the next window; the wind fields were
+	// consumed by the first submission and must not ride along again
+	addAt(w, 90*time.Second, 56, map[string]float32{"tempf": 61, "humidity": 52})
+	_, values := w.Take(windowStart.Add(2*time.Minute), 5*time.Minute)
+
+	for _, key := range []string{"windspeedmph", "winddir", "windgustmph", "dailyrainin"} {
+		if v, ok := values[key]; ok {
+			t.Errorf("%s = %s resubmitted", key, v)
+		}
+	}
+	if values["tempf"] != "61.0" || values["humidity"] != "52" {
+		t.Errorf("second window = %v", values)
+	}
+}
+
+func TestWindowPairsAverageOnlySpeedsWithHeldDirection(t *testing.T) {
+	w := newWindow()
+
+	// The direction only rides along with the gust message; the average
+	// speed arrives on its own
+	addAt(w, 0, 49, map[string]float32{"windgustmph": 12, "winddir": 90})
+	addAt(w, 18*time.Second, 49, map[string]float32{"windspeedmph": 4})
+	addAt(w, 36*time.Second, 49, map[string]float32{"windgustmph": 14, "winddir": 100})
+	addAt(w, 54*time.Second, 49, map[string]float32{"windspeedmph": 6})
+
+	_, values := w.Take(windowStart.Add(time.Minute), 5*time.Minute)
+	for key, want := range map[string]string{
+		"windspeedmph": "5.0",
+		"winddir":      "96",
+		"windgustmph":  "14.0",
+	} {
+		if values[key] != want {
+			t.Errorf("%s = %q, want %q", key, values[key], want)
+		}
+	}
+
+	// The held direction carries into the next window
+	addAt(w, 72*time.Second, 49, map[string]float32{"windspeedmph": 5})
+	values = w.Values(windowStart.Add(2*time.Minute), 5*time.Minute)
+	if values["winddir"] != "100" {
+		t.Errorf("winddir in the next window = %q, want the held 100", values["winddir"])
+	}
+}
+
+func TestWindowHeldDirectionIsPerStation(t *testing.T) {
+	w := newWindow()
+	at := windowStart
+	w.Add(RTL433Message{Timestamp: &at, Station: weathermetrics.StationKey{ID: 1, Channel: "A"},
+		Data: map[string]float32{"windgustmph": 12, "winddir": 90}})
+	w.Add(RTL433Message{Timestamp: &at, Station: weathermetrics.StationKey{ID: 2, Channel: "A"},
+		Data: map[string]float32{"windspeedmph": 4}})
+
+	if v, ok := w.Values(windowStart.Add(time.Minute), 5*time.Minute)["winddir"]; ok {
+		t.Errorf("winddir = %s paired from another station", v)
+	}
+}
+
+func TestWindowHeldDirectionAgesOut(t *testing.T) {
+	w := newWindow()
+	addAt(w, 0, 49, map[string]float32{"windgustmph": 12, "winddir": 90})
+	w.Take(windowStart.Add(time.Minute), 5*time.Minute)
+
+	addAt(w, 10*time.Minute, 49, map[string]float32{"windspeedmph": 4})
+	values := w.Values(windowStart.Add(10*time.Minute), 5*time.Minute)
+	if v, ok := values["winddir"]; ok {
+		t.Errorf("winddir = %s from a direction 10m old", v)
+	}
+	if values["windspeedmph"] != "4.0" {
+		t.Errorf("windspeedmph = %q, want 4.0", values["windspeedmph"])
+	}
+}
@@ -0,0 +1,74 @@
+package weathermetrics
+
+import "fmt"
+
+/*
+ * Config
+ *
+ * The defaults are the usual indoor comfort ranges: 68-78F and 30-60%
+ * relative humidity.
+ */
+type ComfortConfig struct {
+	ComfortMinTempF    float32 `envconfig:"COMFORT_MIN_TEMP_F" default:"68"`
+	ComfortMaxTempF    float32 `envconfig:"COMFORT_MAX_TEMP_F" default:"78"`
+	ComfortMinHumidity float32 `envconfig:"COMFORT_MIN_HUMIDITY" default:"30"`
+	ComfortMaxHumidity float32 `envconfig:"COMFORT_MAX_HUMIDITY" default:"60"`
+}
+
+func (c ComfortConfig) Validate() error {
+	if c.ComfortMinTempF >= c.ComfortMaxTempF {
+		return fmt.Errorf("COMFORT_MIN_TEMP_F must be below COMFORT_MAX_TEMP_F, got %v and %v",
+			c.ComfortMinTempF, c.ComfortMaxTempF)
+	}
+
+	if c.ComfortMinHumidity >= c.ComfortMaxHumidity {
+		return fmt.Errorf("COMFORT_MIN_HUMIDITY must be below COMFORT_MAX_HUMIDITY, got %v and %v",
+			c.ComfortMinHumidity, c.ComfortMaxHumidity)
+	}
+
+	return nil
+}
+
+/*
+ * Comfort level
+ *
+ * Temperature is checked before humidity, so a hot, humid day is "too_hot".
+ * The thresholds themselves are comfortable. The numeric codes are what
+ * /metrics exports and must not be renumbered.
+ */
+type ComfortLevel int
+
+const (
+	COMFORT_COMFORTABLE ComfortLevel = iota
+	COMFORT_TOO_DRY
+	COMFORT_TOO_HUMID
+	COMFORT_TOO_COLD
+	COMFORT_TOO_HOT
+)
+
+var comfortLevelNames = map[ComfortLevel]string{
+	COMFORT_COMFORTABLE: "comfortable",
+	COMFORT_TOO_DRY:     "too_dry",
+	COMFORT_TOO_HUMID:   "too_humid",
+	COMFORT_TOO_COLD:    "too_cold",
+	COMFORT_TOO_HOT:     "too_hot",
+}
+
+func (l ComfortLevel) String() string {
+	return comfortLevelNames[l]
+}
+
+func (c CurrentConditions) ComfortLevel(conf ComfortConfig) ComfortLevel {
+	switch {
+	case c.Temp < conf.ComfortMinTempF:
+		return COMFORT_TOO_COLD
+	case c.Temp > conf.ComfortMaxTempF:
+		return COMFORT_TOO_HOT
+	case c.Humidity < conf.ComfortMinHumidity:
+		return COMFORT_TOO_DRY
+	case c.Humidity > conf.ComfortMaxHumidity:
+		return COMFORT_TOO_HUMID
+	}
+
+	return COMFORT_COMFORTABLE
+}
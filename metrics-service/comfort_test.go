@@ -0,0 +1,64 @@
+package weathermetrics
+
+import "testing"
+
+var defaultComfort = ComfortConfig{
+	ComfortMinTempF:    68,
+	ComfortMaxTempF:    78,
+	ComfortMinHumidity: 30,
+	ComfortMaxHumidity: 60,
+}
+
+func TestComfortLevelBoundaries(t *testing.T) {
+	for _, tc := range []struct {
+		temp, humidity float32
+		want           ComfortLevel
+	}{
+		// The thresholds themselves are comfortable
+		{temp: 68, humidity: 30, want: COMFORT_COMFORTABLE},
+		{temp: 78, humidity: 60, want: COMFORT_COMFORTABLE},
+
+		{temp: 67.9, humidity: 45, want: COMFORT_TOO_COLD},
+		{temp: 78.1, humidity: 45, want: COMFORT_TOO_HOT},
+		{temp: 72, humidity: 29.9, want: COMFORT_TOO_DRY},
+		{temp: 72, humidity: 60.1, want: COMFORT_TOO_HUMID},
+
+		// Temperature wins over humidity
+		{temp: 90, humidity: 90, want: COMFORT_TOO_HOT},
+		{temp: 50, humidity: 10, want: COMFORT_TOO_COLD},
+	} {
+		c := CurrentConditions{Temp: tc.temp, Humidity: tc.humidity}
+		if got := c.ComfortLevel(defaultComfort); got != tc.want {
+			t.Errorf("%vF %v%% = %s, want %s", tc.temp, tc.humidity, got, tc.want)
+		}
+	}
+}
+
+func TestComfortLevelCodes(t *testing.T) {
+	// The numeric codes are exported on /metrics, so they must not move
+	for level, want := range map[ComfortLevel]int{
+		COMFORT_COMFORTABLE: 0,
+		COMFORT_TOO_DRY:     1,
+		COMFORT_TOO_HUMID:   2,
+		COMFORT_TOO_COLD:    3,
+		COMFORT_TOO_HOT:     4,
+	} {
+		if int(level) != want {
+			t.Errorf("%s = %d, want %d", level, level, want)
+		}
+	}
+}
+
+func TestComfortConfigValidate(t *testing.T) {
+	conf := defaultComfort
+	conf.ComfortMinTempF = conf.ComfortMaxTempF
+	if err := conf.Validate(); err == nil {
+		t.Error("equal temperature thresholds accepted")
+	}
+
+	conf = defaultComfort
+	conf.ComfortMinHumidity = 70
+	if err := conf.Validate(); err == nil {
+		t.Error("inverted humidity thresholds accepted")
+	}
+}
@@ -0,0 +1,71 @@
+package weathermetrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+)
+
+/*
+ * Compass
+ *
+ * The 16 points of the compass, each covering a 22.5 degree sector centred on
+ * its heading.
+ */
+const COMPASS_SECTOR_DEGREES = 22.5
+
+var compassPoints = []string{
+	"N", "NNE", "NE", "ENE", "E", "ESE", "SE", "SSE",
+	"S", "SSW", "SW", "WSW", "W", "WNW", "NW", "NNW",
+}
+
+// CardinalToDegrees converts a compass point such as "NW" to degrees
+func CardinalToDegrees(point string) (float32, bool) {
+	point = strings.ToUpper(strings.TrimSpace(point))
+	for i := range compassPoints {
+		if compassPoints[i] == point {
+			return float32(i) * COMPASS_SECTOR_DEGREES, true
+		}
+	}
+
+	return 0, false
+}
+
+// sectorIndex returns which of the 16 points degrees is nearest to. Exact
+// boundaries round clockwise.
+func sectorIndex(degrees float32) int {
+	i := int(math.Floor(float64(degrees)/COMPASS_SECTOR_DEGREES + 0.5))
+	return ((i % len(compassPoints)) + len(compassPoints)) % len(compassPoints)
+}
+
+// SnapToSector rounds degrees to the heading of the nearest compass point,
+// e.g. 160 to 157.5 and 355 to 0
+func SnapToSector(degrees float32) float32 {
+	return float32(sectorIndex(degrees)) * COMPASS_SECTOR_DEGREES
+}
+
+// DegreesToCardinal names the compass point nearest to degrees
+func DegreesToCardinal(degrees float32) string {
+	return compassPoints[sectorIndex(degrees)]
+}
+
+// parseWindDir accepts rtl_433's alternate wind_dir field as either a compass
+// point string or a number of degrees
+func parseWindDir(raw json.RawMessage) (float32, error) {
+	var point string
+	if err := json.Unmarshal(raw, &point); err == nil {
+		degrees, ok := CardinalToDegrees(point)
+		if !ok {
+			return 0, fmt.Errorf("unknown wind direction %q", point)
+		}
+		return degrees, nil
+	}
+
+	var degrees float32
+	if err := json.Unmarshal(raw, &degrees); err != nil {
+		return 0, fmt.Errorf("could not parse wind_dir %s: %w", raw, err)
+	}
+
+	return degrees, nil
+}
@@ -0,0 +1,89 @@
+package weathermetrics
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCardinalToDegrees(t *testing.T) {
+	for point, want := range map[string]float32{
+		"N": 0, "NNE": 22.5, "NE": 45, "ENE": 67.5,
+		"E": 90, "ESE": 112.5, "SE": 135, "SSE": 157.5,
+		"S": 180, "SSW": 202.5, "SW": 225, "WSW": 247.5,
+		"W": 270, "WNW": 292.5, "NW": 315, "NNW": 337.5,
+	} {
+		got, ok := CardinalToDegrees(point)
+		if !ok || got != want {
+			t.Errorf("CardinalToDegrees(%q) = %v, %v, want %v", point, got, ok, want)
+		}
+	}
+}
+
+func TestCardinalToDegreesNormalizes(t *testing.T) {
+	if got, ok := CardinalToDegrees(" nw "); !ok || got != 315 {
+		t.Errorf(`CardinalToDegrees(" nw ") = %v, %v, want 315`, got, ok)
+	}
+
+	if _, ok := CardinalToDegrees("NORTH"); ok {
+		t.Error(`CardinalToDegrees("NORTH") accepted`)
+	}
+}
+
+func TestWindDirAsCardinalString(t *testing.T) {
+	for _, tc := range []struct {
+		payload string
+		want    float32
+	}{
+		{payload: `{"message_type":49,"wind_dir":"NW"}`, want: 315},
+		{payload: `{"message_type":49,"wind_dir":"SSE"}`, want: 157.5},
+		{payload: `{"message_type":49,"wind_dir":202.5}`, want: 202.5},
+		{payload: `{"message_type":49,"wind_dir_deg":90}`, want: 90},
+	} {
+		var m WindRainMeasurement
+		if err := json.Unmarshal([]byte(tc.payload), &m); err != nil {
+			t.Errorf("%s: %v", tc.payload, err)
+			continue
+		}
+		if m.WindDirection != tc.want || m.DirectionMissing {
+			t.Errorf("%s: direction %v (missing %v), want %v", tc.payload, m.WindDirection, m.DirectionMissing, tc.want)
+		}
+	}
+}
+
+func TestWindDirUnknownCardinalRejected(t *testing.T) {
+	var m WindRainMeasurement
+	if err := json.Unmarshal([]byte(`{"message_type":49,"wind_dir":"NORTHISH"}`), &m); err == nil {
+		t.Errorf("unknown compass point accepted as %v", m.WindDirection)
+	}
+}
+
+func TestSnapToSector(t *testing.T) {
+	for _, tc := range []struct {
+		degrees float32
+		want    float32
+		point   string
+	}{
+		{degrees: 157.5, want: 157.5, point: "SSE"},
+		{degrees: 160, want: 157.5, point: "SSE"},
+
+		// Either side of the SSE/S boundary at 168.75
+		{degrees: 168.7, want: 157.5, point: "SSE"},
+		{degrees: 168.8, want: 180, point: "S"},
+
+		// Exact boundaries round clockwise
+		{degrees: 168.75, want: 180, point: "S"},
+		{degrees: 11.25, want: 22.5, point: "NNE"},
+
+		// Near north wraps to 0 rather than 360
+		{degrees: 355, want: 0, point: "N"},
+		{degrees: 360, want: 0, point: "N"},
+		{degrees: 0, want: 0, point: "N"},
+	} {
+		if got := SnapToSector(tc.degrees); got != tc.want {
+			t.Errorf("SnapToSector(%v) = %v, want %v", tc.degrees, got, tc.want)
+		}
+		if got := DegreesToCardinal(tc.degrees); got != tc.point {
+			t.Errorf("DegreesToCardinal(%v) = %q, want %q", tc.degrees, got, tc.point)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package weathermetrics
+
+import (
+	"math"
+	"time"
+)
+
+/*
+ * /conditions document
+ *
+ * CONDITIONS_SCHEMA_VERSION must be bumped whenever a field in the document is
+ * removed, renamed or changes meaning. Adding a field is not a breaking change
+ * and does not need a bump.
+ */
+const CONDITIONS_SCHEMA_VERSION = 1
+
+type DerivedConditions struct {
+	DewPointF     *float32 `json:"dew_point_F,omitempty"`
+	HeatIndexF    *float32 `json:"heat_index_F,omitempty"`
+	ApparentTempF float32  `json:"apparent_temperature_F"`
+	Comfort       string   `json:"comfort,omitempty"`
+}
+
+type ConditionsResponse struct {
+	SchemaVersion int               `json:"schema_version"`
+	Observed      CurrentConditions `json:"observed"`
+	Derived       DerivedConditions `json:"derived"`
+
+	// Observation time rendered in the configured timezone. Omitted when the
+	// sensor's time can't be parsed.
+	LocalTime string `json:"local_time,omitempty"`
+	ISO8601   string `json:"time_iso8601,omitempty"`
+
+	// Today's sunrise and sunset, when the station's position is configured
+	Sun *SunTimes `json:"sun,omitempty"`
+}
+
+// LOCAL_TIME_FORMAT is the display form of local_time in /conditions
+const LOCAL_TIME_FORMAT = "Mon Jan 2 3:04 PM MST"
+
+func NewConditionsResponse(c CurrentConditions, loc *time.Location, comfort ComfortConfig) ConditionsResponse {
+	resp := ConditionsResponse{
+		SchemaVersion: CONDITIONS_SCHEMA_VERSION,
+		Observed:      c,
+		Derived:       DerivedConditions{ApparentTempF: c.ApparentTemperatureF()},
+	}
+
+	if t, err := ParseMessageTime(c.Timestamp, loc); err == nil {
+		resp.LocalTime = t.Format(LOCAL_TIME_FORMAT)
+		resp.ISO8601 = t.Format(time.RFC3339)
+	}
+
+	// These are all meaningless without a humidity reading
+	if c.Humidity > 0 {
+		resp.Derived.Comfort = c.ComfortLevel(comfort).String()
+
+		dewPoint := c.DewPointF()
+		heatIndex := c.HeatIndexF()
+		resp.Derived.DewPointF = &dewPoint
+		resp.Derived.HeatIndexF = &heatIndex
+	}
+
+	return resp
+}
+
+/*
+ * Derived values
+ */
+
+// DewPointF uses the Magnus approximation
+func (c CurrentConditions) DewPointF() float32 {
+	const b, d = 17.62, 243.12
+
+	t := float64(FahrenheitToCelsius(c.Temp))
+	gamma := math.Log(float64(c.Humidity)/100) + b*t/(d+t)
+
+	return CelsiusToFahrenheit(float32(d * gamma / (b - gamma)))
+}
+
+// HeatIndexF follows the NWS Rothfusz regression, including its low and high
+// humidity adjustments
+func (c CurrentConditions) HeatIndexF() float32 {
+	t := float64(c.Temp)
+	rh := float64(c.Humidity)
+
+	hi := 0.5 * (t + 61.0 + (t-68.0)*1.2 + rh*0.094)
+	if (hi+t)/2 < 80 {
+		return float32(hi)
+	}
+
+	hi = -42.379 + 2.04901523*t + 10.14333127*rh -
+		0.22475541*t*rh - 0.00683783*t*t -
+		0.05481717*rh*rh + 0.00122874*t*t*rh +
+		0.00085282*t*rh*rh - 0.00000199*t*t*rh*rh
+
+	if rh < 13 && t >= 80 && t <= 112 {
+		hi -= ((13 - rh) / 4) * math.Sqrt((17-math.Abs(t-95))/17)
+	} else if rh > 85 && t >= 80 && t <= 87 {
+		hi += ((rh - 85) / 10) * ((87 - t) / 5)
+	}
+
+	return float32(hi)
+}
+
+// WindChillF uses the NWS formula, which is only defined at or below 50F with
+// wind over 3 mph; outside that it is the air temperature
+func (c CurrentConditions) WindChillF() float32 {
+	t := float64(c.Temp)
+	v := float64(KmhToMph(c.WindSpeed))
+	if t > 50 || v <= 3 {
+		return c.Temp
+	}
+
+	v16 := math.Pow(v, 0.16)
+	return float32(35.74 + 0.6215*t - 35.75*v16 + 0.4275*t*v16)
+}
+
+// ApparentTemperatureF is the "feels like" temperature: heat index when it's
+// hot, wind chill when it's cold and windy, otherwise the air temperature
+func (c CurrentConditions) ApparentTemperatureF() float32 {
+	if c.Temp >= 80 && c.Humidity > 0 {
+		return c.HeatIndexF()
+	}
+
+	// WindChillF is already the air temperature outside its range
+	return c.WindChillF()
+}
+
+// SeaLevelPressureHPa reduces station pressure to sea level using the
+// barometric formula for the ICAO standard atmosphere, which is what
+// airport/METAR altimeter settings are based on
+func SeaLevelPressureHPa(stationHPa float32, altitudeM float64) float32 {
+	return float32(float64(stationHPa) * math.Pow(1-2.25577e-5*altitudeM, -5.25588))
+}
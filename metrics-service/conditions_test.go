@@ -0,0 +1,202 @@
+package weathermetrics
+
+import (
+	"encoding/json"
+	"maps"
+	"math"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestConditionsResponseShape(t *testing.T) {
+	loc, _ := time.LoadLocation("America/New_York")
+	c := CurrentConditions{
+		Timestamp: "2024-06-01 12:00:00",
+		Temp:      85,
+		Humidity:  60,
+		Battery:   1,
+	}
+
+	data, err := json.Marshal(NewConditionsResponse(c, loc, ComfortConfig{
+		ComfortMinTempF: 68, ComfortMaxTempF: 78, ComfortMinHumidity: 30, ComfortMaxHumidity: 60,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"derived", "local_time", "observed", "schema_version", "time_iso8601"}
+	if got := slices.Sorted(maps.Keys(doc)); !slices.Equal(got, want) {
+		t.Errorf("top level keys = %v, want %v", got, want)
+	}
+
+	var version int
+	json.Unmarshal(doc["schema_version"], &version)
+	if version != CONDITIONS_SCHEMA_VERSION {
+		t.Errorf("schema_version = %d, want %d", version, CONDITIONS_SCHEMA_VERSION)
+	}
+
+	var derived map[string]json.RawMessage
+	json.Unmarshal(doc["derived"], &derived)
+	want = []string{"apparent_temperature_F", "comfort", "dew_point_F", "heat_index_F"}
+	if got := slices.Sorted(maps.Keys(derived)); !slices.Equal(got, want) {
+		t.Errorf("derived keys = %v, want %v", got, want)
+	}
+
+	var observed map[string]json.RawMessage
+	json.Unmarshal(doc["observed"], &observed)
+	for _, name := range []string{"time", "temperature_F", "humidity", "battery_ok", "wind_avg_km_h", "wind_dir_deg", "rain_in"} {
+		if _, ok := observed[name]; !ok {
+			t.Errorf("observed missing %s", name)
+		}
+	}
+	if _, ok := observed["dew_point_F"]; ok {
+		t.Error("derived dew_point_F leaked into observed")
+	}
+
+	if string(doc["time_iso8601"]) != `"2024-06-01T12:00:00-04:00"` {
+		t.Errorf("time_iso8601 = %s", doc["time_iso8601"])
+	}
+}
+
+func TestConditionsResponseOmitsHumidityDerivedWithoutHumidity(t *testing.T) {
+	resp := NewConditionsResponse(CurrentConditions{Temp: 70}, time.UTC, ComfortConfig{})
+
+	if resp.Derived.DewPointF != nil || resp.Derived.HeatIndexF != nil || resp.Derived.Comfort != "" {
+		t.Errorf("humidity derived values set without a humidity reading: %+v", resp.Derived)
+	}
+}
+
+func TestConditionsLocalTimeFormatting(t *testing.T) {
+	for _, tc := range []struct {
+		zone, timestamp, local, iso string
+	}{
+		{zone: "America/New_York", timestamp: "2024-06-01 15:04:05", local: "Sat Jun 1 3:04 PM EDT", iso: "2024-06-01T15:04:05-04:00"},
+		{zone: "America/New_York", timestamp: "2024-12-25 09:30:00", local: "Wed Dec 25 9:30 AM EST", iso: "2024-12-25T09:30:00-05:00"},
+		{zone: "UTC", timestamp: "2024-06-01 00:00:00", local: "Sat Jun 1 12:00 AM UTC", iso: "2024-06-01T00:00:00Z"},
+	} {
+		loc, err := time.LoadLocation(tc.zone)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp := NewConditionsResponse(CurrentConditions{Timestamp: tc.timestamp}, loc, ComfortConfig{})
+		if resp.LocalTime != tc.local || resp.ISO8601 != tc.iso {
+			t.Errorf("%s in %s: local_time %q time_iso8601 %q, want %q and %q",
+				tc.timestamp, tc.zone, resp.LocalTime, resp.ISO8601, tc.local, tc.iso)
+		}
+	}
+}
+
+func TestConditionsUnparseableTimeOmitsFormattedFields(t *testing.T) {
+	resp := NewConditionsResponse(CurrentConditions{Timestamp: "yesterday"}, time.UTC, ComfortConfig{})
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc map[string]json.RawMessage
+	json.Unmarshal(data, &doc)
+	for _, key := range []string{"local_time", "time_iso8601"} {
+		if _, ok := doc[key]; ok {
+			t.Errorf("%s present for an unparseable timestamp: %s", key, doc[key])
+		}
+	}
+}
+
+func TestSeaLevelPressure(t *testing.T) {
+	for _, tc := range []struct {
+		stationHPa float32
+		altitudeM  float64
+		want       float32
+	}{
+		// The standard atmosphere's pressure at each altitude reduces to
+		// 1013.25 hPa
+		{stationHPa: 1013.25, altitudeM: 0, want: 1013.25},
+		{stationHPa: 954.61, altitudeM: 500, want: 1013.25},
+		{stationHPa: 898.76, altitudeM: 1000, want: 1013.25},
+		{stationHPa: 845.56, altitudeM: 1500, want: 1013.25},
+	} {
+		got := SeaLevelPressureHPa(tc.stationHPa, tc.altitudeM)
+		if math.Abs(float64(got-tc.want)) > 0.1 {
+			t.Errorf("SeaLevelPressureHPa(%v, %v) = %v, want %v", tc.stationHPa, tc.altitudeM, got, tc.want)
+		}
+	}
+}
+
+func TestWindAverageAndGustStoredIndependently(t *testing.T) {
+	var c CurrentConditions
+	apply := func(payload string) {
+		t.Helper()
+		var m WindRainMeasurement
+		if err := json.Unmarshal([]byte(payload), &m); err != nil {
+			t.Fatal(err)
+		}
+		c.ApplyWindRain(m)
+	}
+
+	// The Atlas sends both in one message
+	apply(`{"message_type":49,"wind_avg_km_h":12,"wind_max_km_h":20,"wind_dir_deg":90}`)
+	if c.WindSpeed != 12 || c.WindGust == nil || *c.WindGust != 20 {
+		t.Fatalf("wind = %v gust %v, want 12 gust 20", c.WindSpeed, c.WindGust)
+	}
+
+	apply(`{"message_type":49,"wind_max_km_h":25}`)
+	if c.WindSpeed != 12 || *c.WindGust != 25 {
+		t.Errorf("gust-only message: wind = %v gust %v, want 12 gust 25", c.WindSpeed, *c.WindGust)
+	}
+
+	apply(`{"message_type":49,"wind_avg_km_h":8}`)
+	if c.WindSpeed != 8 || *c.WindGust != 25 {
+		t.Errorf("average-only message: wind = %v gust %v, want 8 gust 25", c.WindSpeed, *c.WindGust)
+	}
+
+	// A message with no wind at all leaves both alone
+	apply(`{"message_type":49,"rain_in":0.1}`)
+	if c.WindSpeed != 8 || *c.WindGust != 25 || c.WindDirection != 90 {
+		t.Errorf("rain-only message: wind = %v gust %v from %v, want 8 gust 25 from 90",
+			c.WindSpeed, *c.WindGust, c.WindDirection)
+	}
+	if c.RainInches != 0.1 {
+		t.Errorf("rain = %v, want 0.1", c.RainInches)
+	}
+}
+
+func TestApparentTemperatureRegimes(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		c    CurrentConditions
+		want float32
+	}{
+		// NWS tables: 90F at 50% feels like 95F; 30F in a 10 mph wind
+		// feels like 21F
+		{name: "hot", c: CurrentConditions{Temp: 90, Humidity: 50, WindSpeed: 16.09}, want: 95},
+		{name: "cold", c: CurrentConditions{Temp: 30, Humidity: 50, WindSpeed: 16.09}, want: 21},
+
+		{name: "neutral", c: CurrentConditions{Temp: 65, Humidity: 50, WindSpeed: 30}, want: 65},
+		{name: "cold and calm", c: CurrentConditions{Temp: 30, Humidity: 50, WindSpeed: 3}, want: 30},
+		{name: "hot without humidity", c: CurrentConditions{Temp: 90, WindSpeed: 16.09}, want: 90},
+	} {
+		if got := tc.c.ApparentTemperatureF(); math.Abs(float64(got-tc.want)) > 0.5 {
+			t.Errorf("%s: ApparentTemperatureF() = %v, want about %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestApparentTemperatureSwitchesAtEightyF(t *testing.T) {
+	below := CurrentConditions{Temp: 79.9, Humidity: 90}
+	if got := below.ApparentTemperatureF(); got != below.Temp {
+		t.Errorf("79.9F = %v, want the air temperature", got)
+	}
+
+	at := CurrentConditions{Temp: 80, Humidity: 90}
+	if got := at.ApparentTemperatureF(); got != at.HeatIndexF() {
+		t.Errorf("80F = %v, want the heat index %v", got, at.HeatIndexF())
+	}
+}
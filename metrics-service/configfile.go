@@ -0,0 +1,103 @@
+package weathermetrics
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+ * Config file
+ *
+ * CONFIG_FILE may name a YAML file whose keys are the same names as the
+ * environment variables, e.g.
+ *
+ *   MQTT_SERVER: mqtt:1883
+ *   METRICS: [temperature, humidity]
+ *   WEBHOOK_HEADERS:
+ *     Authorization: Bearer abc123
+ *
+ * Map options such as WEBHOOK_HEADERS and RANGE_POLICY can be written as a
+ * YAML mapping or in their env form, "Authorization:Bearer abc123".
+ *
+ * Each key is exported into the environment unless it is already set, so
+ * envconfig.Process sees file values and real env vars still win.
+ */
+func LoadConfigFile() error {
+	path := os.Getenv("CONFIG_FILE")
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read config file: %w", err)
+	}
+
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("could not parse config file %s: %w", path, err)
+	}
+
+	for key, value := range values {
+		if _, ok := os.LookupEnv(key); ok {
+			continue
+		}
+
+		s, err := configFileValue(value)
+		if err != nil {
+			return fmt.Errorf("config file %s: %s: %w", path, key, err)
+		}
+
+		if err := os.Setenv(key, s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// configFileValue renders a YAML value in the form envconfig expects. Lists
+// become comma separated and maps key:value pairs, sorted by key.
+func configFileValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for i := range v {
+			item, err := configFileValue(v[i])
+			if err != nil {
+				return "", err
+			}
+			items = append(items, item)
+		}
+		return strings.Join(items, ","), nil
+	case map[string]interface{}:
+		pairs := make([]string, 0, len(v))
+		for _, key := range slices.Sorted(maps.Keys(v)) {
+			item, err := configFileScalar(v[key])
+			if err != nil {
+				return "", fmt.Errorf("%s: %w", key, err)
+			}
+			pairs = append(pairs, key+":"+item)
+		}
+		return strings.Join(pairs, ","), nil
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
+
+// configFileScalar renders a map value, which envconfig can't split further
+func configFileScalar(value interface{}) (string, error) {
+	switch value.(type) {
+	case []interface{}, map[string]interface{}:
+		return "", fmt.Errorf("map values must be scalars")
+	}
+
+	return configFileValue(value)
+}
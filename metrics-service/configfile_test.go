@@ -0,0 +1,91 @@
+package weathermetrics
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeConfigFile points CONFIG_FILE at a file holding yaml and unsets keys,
+// restoring them once the test is done
+func writeConfigFile(t *testing.T, yaml string, keys ...string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+
+	for _, key := range keys {
+		t.Setenv(key, "")
+		os.Unsetenv(key)
+	}
+}
+
+func TestConfigFileValues(t *testing.T) {
+	writeConfigFile(t, `
+MQTT_SERVER: mqtt:1883
+METRICS: [temperature, humidity]
+WEBHOOK_HEADERS:
+  X-Station: backyard
+  Authorization: Bearer abc123
+RANGE_POLICY: "humidity:clamp,wind_speed:reject"
+`, "MQTT_SERVER", "METRICS", "WEBHOOK_HEADERS", "RANGE_POLICY")
+
+	if err := LoadConfigFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, want := range map[string]string{
+		"MQTT_SERVER":     "mqtt:1883",
+		"METRICS":         "temperature,humidity",
+		"WEBHOOK_HEADERS": "Authorization:Bearer abc123,X-Station:backyard",
+		"RANGE_POLICY":    "humidity:clamp,wind_speed:reject",
+	} {
+		if got := os.Getenv(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestConfigFileEnvWins(t *testing.T) {
+	writeConfigFile(t, "MQTT_SERVER: from-file:1883\n")
+	t.Setenv("MQTT_SERVER", "from-env:1883")
+
+	if err := LoadConfigFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := os.Getenv("MQTT_SERVER"); got != "from-env:1883" {
+		t.Errorf("MQTT_SERVER = %q, want the env value", got)
+	}
+}
+
+func TestConfigFileErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		yaml string
+		want string
+	}{
+		"unparseable":      {yaml: "MQTT_SERVER: [unclosed\n", want: "could not parse config file"},
+		"nested map value": {yaml: "RANGE_POLICY:\n  humidity:\n    policy: clamp\n", want: "RANGE_POLICY: humidity: map values must be scalars"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			writeConfigFile(t, tc.yaml, "MQTT_SERVER", "RANGE_POLICY")
+
+			err := LoadConfigFile()
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("got error %v, want one containing %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestConfigFileUnset(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+
+	if err := LoadConfigFile(); err != nil {
+		t.Errorf("no config file: %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package weathermetrics
+
+import (
+	"fmt"
+	"time"
+)
+
+/*
+ * Config
+ *
+ * DECIMATE_INTERVAL samples the listed fields: a reading is applied only if
+ * the field hasn't been updated within the interval, and readings in between
+ * are discarded. Zero disables it. Fields are "wind" (speed and direction
+ * together, so they stay paired), "rain", "temperature" and "humidity".
+ */
+type DecimationConfig struct {
+	DecimateInterval time.Duration `envconfig:"DECIMATE_INTERVAL" default:"0s"`
+	DecimateFields   []string      `envconfig:"DECIMATE_FIELDS" default:"wind"`
+}
+
+var decimationFields = map[string]bool{
+	"wind":        true,
+	"rain":        true,
+	"temperature": true,
+	"humidity":    true,
+}
+
+func (c DecimationConfig) Validate() error {
+	if c.DecimateInterval < 0 {
+		return fmt.Errorf("DECIMATE_INTERVAL must not be negative, got %s", c.DecimateInterval)
+	}
+
+	for _, field := range c.DecimateFields {
+		if !decimationFields[field] {
+			return fmt.Errorf("unknown field %q in DECIMATE_FIELDS", field)
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Decimator
+ *
+ * Decimator is not safe for concurrent use; callers hold their own lock.
+ */
+type Decimator struct {
+	interval time.Duration
+	fields   map[string]bool
+	last     map[string]time.Time
+}
+
+func NewDecimator(conf DecimationConfig) *Decimator {
+	d := &Decimator{
+		interval: conf.DecimateInterval,
+		fields:   make(map[string]bool),
+		last:     make(map[string]time.Time),
+	}
+	for _, field := range conf.DecimateFields {
+		d.fields[field] = true
+	}
+
+	return d
+}
+
+// Allow reports whether a reading of field at now should be applied
+func (d *Decimator) Allow(field string, now time.Time) bool {
+	if d.interval == 0 || !d.fields[field] {
+		return true
+	}
+
+	if last, ok := d.last[field]; ok && now.Sub(last) < d.interval {
+		return false
+	}
+
+	d.last[field] = now
+	return true
+}
+
+// Filter puts back prev's value for each of fields that isn't due an update,
+// after a measurement has been applied to next. It reports whether any of
+// fields was kept.
+func (d *Decimator) Filter(prev CurrentConditions, next *CurrentConditions, now time.Time, fields ...string) bool {
+	kept := false
+	for _, field := range fields {
+		if d.Allow(field, now) {
+			kept = true
+			continue
+		}
+
+		switch field {
+		case "wind":
+			next.WindSpeed = prev.WindSpeed
+			next.WindGust = prev.WindGust
+			next.WindDirection = prev.WindDirection
+		case "rain":
+			next.RainInches = prev.RainInches
+		case "temperature":
+			next.Temp = prev.Temp
+		case "humidity":
+			next.Humidity = prev.Humidity
+		}
+	}
+
+	return kept
+}
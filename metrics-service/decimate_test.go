@@ -0,0 +1,89 @@
+package weathermetrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDecimatorIntervalBoundary(t *testing.T) {
+	d := NewDecimator(DecimationConfig{DecimateInterval: time.Minute, DecimateFields: []string{"wind"}})
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		offset time.Duration
+		want   bool
+	}{
+		{0, true},
+		{18 * time.Second, false},
+		{59*time.Second + 999*time.Millisecond, false},
+
+		// Exactly one interval after the last kept reading is due again,
+		// and the next interval counts from there
+		{time.Minute, true},
+		{time.Minute + 18*time.Second, false},
+		{2 * time.Minute, true},
+	} {
+		if got := d.Allow("wind", start.Add(tc.offset)); got != tc.want {
+			t.Errorf("Allow at +%s = %v, want %v", tc.offset, got, tc.want)
+		}
+	}
+}
+
+func TestDecimatorLeavesOtherFieldsAlone(t *testing.T) {
+	d := NewDecimator(DecimationConfig{DecimateInterval: time.Minute, DecimateFields: []string{"wind"}})
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	d.Allow("wind", now)
+	if !d.Allow("rain", now) || !d.Allow("rain", now.Add(time.Second)) {
+		t.Error("rain decimated without being listed")
+	}
+}
+
+func TestDecimatorDisabledAtZeroInterval(t *testing.T) {
+	d := NewDecimator(DecimationConfig{DecimateFields: []string{"wind"}})
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	if !d.Allow("wind", now) || !d.Allow("wind", now) {
+		t.Error("wind decimated with a zero interval")
+	}
+}
+
+func TestDecimatorFilterKeepsPreviousValues(t *testing.T) {
+	d := NewDecimator(DecimationConfig{DecimateInterval: time.Minute, DecimateFields: []string{"wind"}})
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	prev := CurrentConditions{WindSpeed: 10, WindDirection: 90, RainInches: 0.1}
+	next := prev
+	if !d.Filter(prev, &next, now, "wind", "rain") {
+		t.Fatal("first reading held back")
+	}
+
+	prev = next
+	next = CurrentConditions{WindSpeed: 20, WindDirection: 180, RainInches: 0.2}
+	if !d.Filter(prev, &next, now.Add(18*time.Second), "wind", "rain") {
+		t.Error("reading reported as fully held back although rain was applied")
+	}
+
+	// Speed and direction are held back together so they stay paired
+	if next.WindSpeed != 10 || next.WindDirection != 90 {
+		t.Errorf("wind = %v from %v, want the previous 10 from 90", next.WindSpeed, next.WindDirection)
+	}
+	if next.RainInches != 0.2 {
+		t.Errorf("rain = %v, want 0.2", next.RainInches)
+	}
+
+	// With only decimated fields in the message, nothing is kept
+	next = CurrentConditions{WindSpeed: 30}
+	if d.Filter(prev, &next, now.Add(36*time.Second), "wind") {
+		t.Error("wind-only reading kept within the interval")
+	}
+}
+
+func TestDecimationConfigValidate(t *testing.T) {
+	if err := (DecimationConfig{DecimateInterval: -time.Second}).Validate(); err == nil {
+		t.Error("negative interval accepted")
+	}
+	if err := (DecimationConfig{DecimateFields: []string{"pressure"}}).Validate(); err == nil {
+		t.Error("unknown field accepted")
+	}
+}
@@ -0,0 +1,70 @@
+package weathermetrics
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+/*
+ * CSV export
+ *
+ * Columns for exporting history samples, named after the /conditions fields.
+ * time is the sample time in RFC 3339. Fields a sample doesn't have, such as
+ * a gust from a sensor that doesn't report one, are left empty.
+ */
+var ExportColumns = []string{
+	"time",
+	"temperature_F",
+	"humidity",
+	"pressure_hPa",
+	"wind_avg_km_h",
+	"wind_max_km_h",
+	"wind_dir_deg",
+	"rain_in",
+	"battery_ok",
+}
+
+var exportFields = map[string]func(HistorySample) string{
+	"time":          func(s HistorySample) string { return s.Time.Format(time.RFC3339) },
+	"temperature_F": func(s HistorySample) string { return FormatCompact(s.Conditions.Temp) },
+	"humidity":      func(s HistorySample) string { return FormatCompact(s.Conditions.Humidity) },
+	"pressure_hPa": func(s HistorySample) string {
+		if s.Conditions.PressureHPa == 0 {
+			return ""
+		}
+		return FormatCompact(s.Conditions.PressureHPa)
+	},
+	"wind_avg_km_h": func(s HistorySample) string { return FormatCompact(s.Conditions.WindSpeed) },
+	"wind_max_km_h": func(s HistorySample) string {
+		if s.Conditions.WindGust == nil {
+			return ""
+		}
+		return FormatCompact(*s.Conditions.WindGust)
+	},
+	"wind_dir_deg": func(s HistorySample) string { return FormatCompact(s.Conditions.WindDirection) },
+	"rain_in":      func(s HistorySample) string { return FormatCompact(s.Conditions.RainInches) },
+	"battery_ok":   func(s HistorySample) string { return strconv.Itoa(s.Conditions.Battery) },
+}
+
+// CheckExportColumns returns an error naming the first unknown column
+func CheckExportColumns(columns []string) error {
+	for _, column := range columns {
+		if _, ok := exportFields[column]; !ok {
+			return fmt.Errorf("unknown column %q", column)
+		}
+	}
+
+	return nil
+}
+
+// ExportRow renders s as one CSV record. columns must have passed
+// CheckExportColumns.
+func ExportRow(s HistorySample, columns []string) []string {
+	row := make([]string, len(columns))
+	for i, column := range columns {
+		row[i] = exportFields[column](s)
+	}
+
+	return row
+}
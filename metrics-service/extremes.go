@@ -0,0 +1,44 @@
+package weathermetrics
+
+import "time"
+
+/*
+ * Daily extremes
+ *
+ * Highest and lowest temperature since local midnight.
+ *
+ * DailyExtremes is not safe for concurrent use; callers hold their own lock.
+ */
+type DailyExtremes struct {
+	loc  *time.Location
+	date string
+	min  float32
+	max  float32
+}
+
+func NewDailyExtremes(loc *time.Location) *DailyExtremes {
+	return &DailyExtremes{loc: loc}
+}
+
+func (e *DailyExtremes) Observe(temp float32, at time.Time) {
+	date := at.In(e.loc).Format(rainDateFormat)
+	if date != e.date {
+		e.date = date
+		e.min = temp
+		e.max = temp
+		return
+	}
+
+	e.min = min(e.min, temp)
+	e.max = max(e.max, temp)
+}
+
+// Range returns today's low and high as of now, or false if there has been
+// no reading yet today
+func (e *DailyExtremes) Range(now time.Time) (float32, float32, bool) {
+	if e.date == "" || now.In(e.loc).Format(rainDateFormat) != e.date {
+		return 0, 0, false
+	}
+
+	return e.min, e.max, true
+}
@@ -3,11 +3,15 @@ module github.com/mckeowbc/weather-metrics
 go 1.24.4
 
 require (
-	github.com/eclipse/paho.mqtt.golang v1.5.0 // indirect
+	github.com/eclipse/paho.mqtt.golang v1.5.0
+	github.com/golang/snappy v1.0.0
+	github.com/kelseyhightower/envconfig v1.4.0
+	google.golang.org/protobuf v1.36.12
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
 	github.com/gorilla/websocket v1.5.3 // indirect
-	github.com/kaido42/iso8601 v0.0.0-20180317094052-6173675fb719 // indirect
-	github.com/kelseyhightower/envconfig v1.4.0 // indirect
-	github.com/sethvargo/go-envconfig v1.3.0 // indirect
 	golang.org/x/net v0.27.0 // indirect
 	golang.org/x/sync v0.7.0 // indirect
 )
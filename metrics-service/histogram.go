@@ -0,0 +1,71 @@
+package weathermetrics
+
+import (
+	"sort"
+	"sync"
+)
+
+/*
+ * Histogram
+ *
+ * A fixed-bucket histogram in the shape Prometheus expects: one counter per
+ * upper bound plus a running sum and count. Observations are a lock and an
+ * increment, cheap enough for the message path.
+ */
+type Histogram struct {
+	m      sync.Mutex
+	bounds []float64
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+type HistogramSnapshot struct {
+	// Bounds are the bucket upper bounds, ascending. Counts are cumulative,
+	// so Counts[i] is the number of observations <= Bounds[i].
+	Bounds []float64
+	Counts []uint64
+	Sum    float64
+	Count  uint64
+}
+
+// NewHistogram takes the bucket upper bounds; +Inf is implied
+func NewHistogram(bounds []float64) *Histogram {
+	sorted := append([]float64(nil), bounds...)
+	sort.Float64s(sorted)
+
+	return &Histogram{bounds: sorted, counts: make([]uint64, len(sorted))}
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	snapshot := HistogramSnapshot{
+		Bounds: h.bounds,
+		Counts: make([]uint64, len(h.counts)),
+		Sum:    h.sum,
+		Count:  h.count,
+	}
+
+	var cumulative uint64
+	for i, c := range h.counts {
+		cumulative += c
+		snapshot.Counts[i] = cumulative
+	}
+
+	return snapshot
+}
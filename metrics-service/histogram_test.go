@@ -0,0 +1,26 @@
+package weathermetrics
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestHistogramBucketsAreCumulative(t *testing.T) {
+	h := NewHistogram([]float64{1, 0.1, 0.5})
+	for _, v := range []float64{0.05, 0.1, 0.3, 0.7, 2} {
+		h.Observe(v)
+	}
+
+	s := h.Snapshot()
+	if !slices.Equal(s.Bounds, []float64{0.1, 0.5, 1}) {
+		t.Errorf("bounds %v, want them sorted", s.Bounds)
+	}
+
+	// An observation on a bound falls in that bound's bucket
+	if !slices.Equal(s.Counts, []uint64{2, 3, 4}) {
+		t.Errorf("counts %v, want [2 3 4]", s.Counts)
+	}
+	if s.Count != 5 || !approxEqual(float32(s.Sum), 3.15) {
+		t.Errorf("count %d sum %v, want 5 and 3.15", s.Count, s.Sum)
+	}
+}
@@ -0,0 +1,128 @@
+package weathermetrics
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+ * History
+ *
+ * HistoryStore is a Sink that appends every update to a JSON lines file so
+ * endpoints can answer questions about the past. One line per sample:
+ *
+ *   {"time":"2025-08-03T21:52:39-04:00","conditions":{...}}
+ */
+type HistorySample struct {
+	Time       time.Time         `json:"time"`
+	Conditions CurrentConditions `json:"conditions"`
+}
+
+type HistoryStore struct {
+	m    sync.Mutex
+	path string
+	file *os.File
+}
+
+func OpenHistoryStore(path string) (*HistoryStore, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("could not open history file: %w", err)
+	}
+
+	return &HistoryStore{path: path, file: file}, nil
+}
+
+func (h *HistoryStore) Write(c CurrentConditions, at time.Time) {
+	line, err := json.Marshal(HistorySample{Time: at, Conditions: c})
+	if err != nil {
+		log.Printf("Could not encode history sample: %s", err)
+		return
+	}
+
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	if _, err := h.file.Write(append(line, '\n')); err != nil {
+		log.Printf("Could not write history sample: %s", err)
+	}
+}
+
+// Each calls fn for every stored sample in [from, to), oldest first, reading
+// the file as it goes rather than loading it all
+func (h *HistoryStore) Each(from, to time.Time, fn func(HistorySample) error) error {
+	file, err := os.Open(h.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var sample HistorySample
+		if err := json.Unmarshal(scanner.Bytes(), &sample); err != nil {
+			log.Printf("Skipping bad history line: %s", err)
+			continue
+		}
+
+		if sample.Time.Before(from) || !sample.Time.Before(to) {
+			continue
+		}
+
+		if err := fn(sample); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (h *HistoryStore) Close() error {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	return h.file.Close()
+}
+
+/*
+ * Daily rain
+ */
+type DailyRain struct {
+	Date   string  `json:"date"`
+	Inches float32 `json:"inches"`
+}
+
+// DailyRainTotals runs samples, oldest first, through rain, a new
+// accumulator, and returns the total for each of the days days up to and
+// including now's, oldest first. Days are rain days, so they start at the
+// accumulator's reset time and are named by the date they start on. The
+// accumulator's deadband and counter reset handling apply just as they do
+// live, so jitter isn't counted as rain.
+func DailyRainTotals(samples []HistorySample, rain *RainAccumulator, days int, now time.Time) []DailyRain {
+	today := rain.DayStart(now)
+	start := time.Date(today.Year(), today.Month(), today.Day()-(days-1), 0, 0, 0, 0, rain.loc)
+
+	totals := make([]DailyRain, days)
+	index := make(map[string]int, days)
+	for i := range totals {
+		date := start.AddDate(0, 0, i).Format(rainDateFormat)
+		totals[i].Date = date
+		index[date] = i
+	}
+
+	// Within a day the accumulated total only grows, so the day's last
+	// sample has its total
+	for _, sample := range samples {
+		daily := rain.Observe(sample.Conditions.RainInches, sample.Time)
+		if j, ok := index[rain.day(sample.Time)]; ok {
+			totals[j].Inches = daily
+		}
+	}
+
+	return totals
+}
@@ -0,0 +1,105 @@
+package weathermetrics
+
+import (
+	"testing"
+	"time"
+)
+
+func rainSample(at time.Time, inches float32) HistorySample {
+	return HistorySample{Time: at, Conditions: CurrentConditions{RainInches: inches}}
+}
+
+func TestDailyRainTotalsAcrossDays(t *testing.T) {
+	loc := mustLoadLocation(t, "America/New_York")
+	// day(0, h) is 1 June, day(-1, h) 31 May and so on
+	day := func(d, hour int) time.Time { return time.Date(2024, 6, 1+d, hour, 0, 0, 0, loc) }
+
+	samples := []HistorySample{
+		// 29 May, before the range, only baselines
+		rainSample(day(-3, 23), 1.00),
+
+		// 30 May: jitter either side of a real 0.10
+		rainSample(day(-2, 0), 1.00),
+		rainSample(day(-2, 6), 0.99),
+		rainSample(day(-2, 7), 1.00),
+		rainSample(day(-2, 12), 1.10),
+		rainSample(day(-2, 13), 1.09),
+		rainSample(day(-2, 14), 1.10),
+
+		// 31 May: dry
+
+		// 1 June: 0.20, a battery change resetting the counter, then 0.05
+		rainSample(day(0, 0), 1.10),
+		rainSample(day(0, 8), 1.30),
+		rainSample(day(0, 9), 0),
+		rainSample(day(0, 10), 0.05),
+	}
+
+	totals := DailyRainTotals(samples, NewRainAccumulator(loc, 0.02, 0), 3, day(0, 12))
+
+	want := []DailyRain{
+		{Date: "2024-05-30", Inches: 0.10},
+		{Date: "2024-05-31", Inches: 0},
+		{Date: "2024-06-01", Inches: 0.25},
+	}
+	if len(totals) != len(want) {
+		t.Fatalf("got %d days, want %d", len(totals), len(want))
+	}
+	for i := range want {
+		if totals[i].Date != want[i].Date || !approxEqual(totals[i].Inches, want[i].Inches) {
+			t.Errorf("day %d = %+v, want %+v", i, totals[i], want[i])
+		}
+	}
+}
+
+func TestDailyRainTotalsFollowResetTime(t *testing.T) {
+	loc := mustLoadLocation(t, "America/New_York")
+	day := func(d, hour int) time.Time { return time.Date(2024, 6, 1+d, hour, 0, 0, 0, loc) }
+
+	samples := []HistorySample{
+		// Baseline for the rain day that starts 30 May 09:00
+		rainSample(day(-2, 9), 1.00),
+
+		// 0.10 in the small hours of 31 May still counts toward 30 May
+		rainSample(day(-1, 3), 1.10),
+
+		// 31 May's day starts at 09:00; 0.20 falls in it, the last of it
+		// early on 1 June
+		rainSample(day(-1, 9), 1.10),
+		rainSample(day(-1, 15), 1.20),
+		rainSample(day(0, 8), 1.30),
+
+		// 1 June from 09:00
+		rainSample(day(0, 9), 1.30),
+		rainSample(day(0, 10), 1.35),
+	}
+
+	// At 08:00 on 2 June the current rain day is still 1 June's
+	rain := NewRainAccumulator(loc, 0.02, 9*time.Hour)
+	totals := DailyRainTotals(samples, rain, 3, day(1, 8))
+
+	want := []DailyRain{
+		{Date: "2024-05-30", Inches: 0.10},
+		{Date: "2024-05-31", Inches: 0.20},
+		{Date: "2024-06-01", Inches: 0.05},
+	}
+	if len(totals) != len(want) {
+		t.Fatalf("got %d days, want %d", len(totals), len(want))
+	}
+	for i := range want {
+		if totals[i].Date != want[i].Date || !approxEqual(totals[i].Inches, want[i].Inches) {
+			t.Errorf("day %d = %+v, want %+v", i, totals[i], want[i])
+		}
+	}
+}
+
+func TestDailyRainTotalsWithoutSamples(t *testing.T) {
+	loc := mustLoadLocation(t, "America/New_York")
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, loc)
+
+	totals := DailyRainTotals(nil, NewRainAccumulator(loc, 0.02, 0), 2, now)
+	if len(totals) != 2 || totals[0].Date != "2024-05-31" || totals[1].Date != "2024-06-01" ||
+		totals[0].Inches != 0 || totals[1].Inches != 0 {
+		t.Errorf("got %+v", totals)
+	}
+}
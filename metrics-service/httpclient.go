@@ -0,0 +1,44 @@
+package weathermetrics
+
+import (
+	"net/http"
+	"time"
+)
+
+/*
+ * Outbound HTTP
+ *
+ * Every request this project makes to a third party goes through a client
+ * from NewHTTPClient so providers see a descriptive User-Agent rather than
+ * Go's default.
+ */
+func DefaultUserAgent() string {
+	return "weather-station/" + Version
+}
+
+type userAgentTransport struct {
+	userAgent string
+	next      http.RoundTripper
+}
+
+func (t userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+
+	return t.next.RoundTrip(req)
+}
+
+// NewHTTPClient returns a client that sets userAgent, or DefaultUserAgent if
+// it is empty, on every request
+func NewHTTPClient(userAgent string, timeout time.Duration) *http.Client {
+	if userAgent == "" {
+		userAgent = DefaultUserAgent()
+	}
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: userAgentTransport{userAgent: userAgent, next: http.DefaultTransport},
+	}
+}
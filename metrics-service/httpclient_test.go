@@ -0,0 +1,55 @@
+package weathermetrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// userAgentSeen requests a test server with client and returns the
+// User-Agent it received
+func userAgentSeen(t *testing.T, client *http.Client, set string) string {
+	t.Helper()
+
+	seen := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen <- r.UserAgent()
+	}))
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set != "" {
+		req.Header.Set("User-Agent", set)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	return <-seen
+}
+
+func TestHTTPClientDefaultUserAgent(t *testing.T) {
+	got := userAgentSeen(t, NewHTTPClient("", time.Second), "")
+	if want := "weather-station/" + Version; got != want {
+		t.Errorf("User-Agent %q, want %q", got, want)
+	}
+}
+
+func TestHTTPClientConfiguredUserAgent(t *testing.T) {
+	if got := userAgentSeen(t, NewHTTPClient("backyard-station/1.0", time.Second), ""); got != "backyard-station/1.0" {
+		t.Errorf("User-Agent %q, want backyard-station/1.0", got)
+	}
+}
+
+func TestHTTPClientKeepsRequestUserAgent(t *testing.T) {
+	if got := userAgentSeen(t, NewHTTPClient("", time.Second), "explicit/2"); got != "explicit/2" {
+		t.Errorf("User-Agent %q, want the request's own explicit/2", got)
+	}
+}
@@ -0,0 +1,55 @@
+package weathermetrics
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+/*
+ * Socket activation
+ *
+ * Under systemd socket activation the socket unit owns the listening socket
+ * and hands it to the service as fd 3, so it stays open across a restart and
+ * connections queue rather than being refused. LISTEN_PID guards against
+ * inheriting a variable meant for a different process.
+ */
+const SD_LISTEN_FDS_START = 3
+
+// ActivatedListener returns the listener passed by systemd, or nil if the
+// process wasn't socket activated. Only the first passed socket is used.
+func ActivatedListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, nil
+	}
+
+	// Don't pass the sockets on to anything we start
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	return FileListener(SD_LISTEN_FDS_START)
+}
+
+// FileListener wraps an inherited, already listening socket
+func FileListener(fd uintptr) (net.Listener, error) {
+	file := os.NewFile(fd, fmt.Sprintf("LISTEN_FD_%d", fd))
+	if file == nil {
+		return nil, fmt.Errorf("invalid listen fd %d", fd)
+	}
+	defer file.Close()
+
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not use listen fd %d: %w", fd, err)
+	}
+
+	return listener, nil
+}
@@ -0,0 +1,81 @@
+package weathermetrics
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestFileListenerServesInheritedSocket(t *testing.T) {
+	// Stands in for the socket systemd would pass: already bound and
+	// listening before the server sees it
+	bound, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bound.Close()
+
+	file, err := bound.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	listener, err := FileListener(file.Fd())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "inherited")
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	if listener.Addr().String() != bound.Addr().String() {
+		t.Errorf("listener on %s, want the inherited %s", listener.Addr(), bound.Addr())
+	}
+
+	resp, err := http.Get("http://" + bound.Addr().String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "inherited" {
+		t.Errorf("body = %q", body)
+	}
+}
+
+func TestFileListenerRejectsNonSocket(t *testing.T) {
+	file, err := os.CreateTemp(t.TempDir(), "fd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if _, err := FileListener(file.Fd()); err == nil {
+		t.Error("regular file accepted as a listener")
+	}
+}
+
+func TestActivatedListenerWithoutSocketActivation(t *testing.T) {
+	for name, env := range map[string]map[string]string{
+		"unset":         {"LISTEN_PID": "", "LISTEN_FDS": ""},
+		"other process": {"LISTEN_PID": strconv.Itoa(os.Getpid() + 1), "LISTEN_FDS": "1"},
+		"no fds":        {"LISTEN_PID": strconv.Itoa(os.Getpid()), "LISTEN_FDS": "0"},
+	} {
+		for k, v := range env {
+			t.Setenv(k, v)
+		}
+
+		listener, err := ActivatedListener()
+		if listener != nil || err != nil {
+			t.Errorf("%s: got %v, %v, want to fall back to binding", name, listener, err)
+		}
+	}
+}
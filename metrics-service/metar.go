@@ -0,0 +1,73 @@
+package weathermetrics
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+/*
+ * METAR
+ *
+ * Renders conditions in the general shape of a METAR report:
+ *
+ *   METAR KXYZ 151456Z 27008G15KT 12/08 A2992
+ *
+ * station, day/time in UTC, wind direction to the nearest 10 degrees and
+ * speed in knots (00000KT when calm), temperature/dew point in whole degrees
+ * C with M for minus, and the altimeter setting in hundredths of inHg. There
+ * is no visibility, cloud or weather group since the sensors can't observe
+ * them. The dew point is left blank without humidity and the altimeter is
+ * left out without pressure.
+ */
+const METAR_TIME_FORMAT = "021504Z"
+
+func FormatMETAR(station string, c CurrentConditions, observed time.Time, altitudeM float64) string {
+	groups := []string{"METAR", station, observed.UTC().Format(METAR_TIME_FORMAT), metarWind(c)}
+
+	temp := metarTemperature(FahrenheitToCelsius(c.Temp)) + "/"
+	if c.Humidity > 0 {
+		temp += metarTemperature(FahrenheitToCelsius(c.DewPointF()))
+	}
+	groups = append(groups, temp)
+
+	if c.PressureHPa > 0 {
+		altimeter := HPaToInHg(SeaLevelPressureHPa(c.PressureHPa, altitudeM))
+		groups = append(groups, fmt.Sprintf("A%04d", int(math.Round(float64(altimeter)*100))))
+	}
+
+	return strings.Join(groups, " ")
+}
+
+func metarWind(c CurrentConditions) string {
+	speed := int(math.Round(float64(KmhToKnots(c.WindSpeed))))
+	if speed == 0 {
+		return "00000KT"
+	}
+
+	direction := int(math.Round(float64(c.WindDirection)/10)) * 10 % 360
+	if direction == 0 {
+		direction = 360
+	}
+
+	wind := fmt.Sprintf("%03d%02d", direction, speed)
+	if c.WindGust != nil {
+		if gust := int(math.Round(float64(KmhToKnots(*c.WindGust)))); gust > speed {
+			wind += fmt.Sprintf("G%02d", gust)
+		}
+	}
+
+	return wind + "KT"
+}
+
+// metarTemperature takes the sign from the reading itself, so -0.3 is M00
+// rather than 00
+func metarTemperature(celsius float32) string {
+	rounded := int(math.Round(math.Abs(float64(celsius))))
+	if celsius < 0 {
+		return fmt.Sprintf("M%02d", rounded)
+	}
+
+	return fmt.Sprintf("%02d", rounded)
+}
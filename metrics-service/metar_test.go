@@ -0,0 +1,72 @@
+package weathermetrics
+
+import (
+	"testing"
+	"time"
+)
+
+var metarObserved = time.Date(2024, 6, 15, 14, 56, 0, 0, time.UTC)
+
+func TestFormatMETAR(t *testing.T) {
+	gust := float32(27.78)
+	c := CurrentConditions{
+		Temp:          53.6,
+		Humidity:      76,
+		WindSpeed:     14.816,
+		WindGust:      &gust,
+		WindDirection: 268,
+		PressureHPa:   1013.25,
+	}
+
+	want := "METAR KXYZ 151456Z 27008G15KT 12/08 A2992"
+	if got := FormatMETAR("KXYZ", c, metarObserved, 0); got != want {
+		t.Errorf("FormatMETAR = %q, want %q", got, want)
+	}
+}
+
+func TestFormatMETARWithoutOptionalGroups(t *testing.T) {
+	// Calm, below freezing, no humidity or pressure
+	c := CurrentConditions{Temp: 23, WindDirection: 200}
+
+	want := "METAR KXYZ 151456Z 00000KT M05/"
+	if got := FormatMETAR("KXYZ", c, metarObserved, 0); got != want {
+		t.Errorf("FormatMETAR = %q, want %q", got, want)
+	}
+}
+
+func TestMETARWind(t *testing.T) {
+	gust := float32(20)
+	for _, tc := range []struct {
+		c    CurrentConditions
+		want string
+	}{
+		// North is 360, never 000
+		{c: CurrentConditions{WindSpeed: 18.52, WindDirection: 356}, want: "36010KT"},
+		{c: CurrentConditions{WindSpeed: 18.52, WindDirection: 4}, want: "36010KT"},
+
+		// A gust no stronger than the average is left out
+		{c: CurrentConditions{WindSpeed: 37.04, WindDirection: 90, WindGust: &gust}, want: "09020KT"},
+	} {
+		if got := metarWind(tc.c); got != tc.want {
+			t.Errorf("metarWind(%v from %v) = %q, want %q", tc.c.WindSpeed, tc.c.WindDirection, got, tc.want)
+		}
+	}
+}
+
+func TestMETARTemperature(t *testing.T) {
+	for _, tc := range []struct {
+		celsius float32
+		want    string
+	}{
+		{celsius: 12.4, want: "12"},
+		{celsius: 0.3, want: "00"},
+		{celsius: 0, want: "00"},
+		{celsius: -0.3, want: "M00"},
+		{celsius: -0.5, want: "M01"},
+		{celsius: -5, want: "M05"},
+	} {
+		if got := metarTemperature(tc.celsius); got != tc.want {
+			t.Errorf("metarTemperature(%v) = %q, want %q", tc.celsius, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package weathermetrics
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"net"
+	"time"
+)
+
+/*
+ * Config
+ */
+type ModbusConfig struct {
+	Addr string `envconfig:"MODBUS_ADDR"`
+}
+
+func (c ModbusConfig) Enabled() bool {
+	return c.Addr != ""
+}
+
+/*
+ * Modbus TCP
+ *
+ * Serves the current conditions as read-only holding registers (function code
+ * 3). Values are scaled to integers; temperature is a signed 16 bit register,
+ * the rest are unsigned. Any unit id is answered.
+ *
+ *   Register  Value               Scale  Sign
+ *   0         temperature_F       x10    signed
+ *   1         humidity            x10    unsigned
+ *   2         wind_avg_km_h       x10    unsigned
+ *   3         wind_dir_deg        x10    unsigned
+ *   4         rain_in             x100   unsigned
+ *   5         pressure_hPa        x10    unsigned, 0 if not reported
+ *   6         battery_ok          x1     unsigned
+ */
+const (
+	MODBUS_READ_HOLDING_REGISTERS = 0x03
+
+	modbusIllegalFunction    = 0x01
+	modbusIllegalDataAddress = 0x02
+	modbusIllegalDataValue   = 0x03
+
+	// Largest quantity a single read may ask for, per the spec
+	modbusMaxRead = 125
+
+	modbusIdleTimeout = 2 * time.Minute
+)
+
+// ModbusRegisters renders c in register map order
+func ModbusRegisters(c CurrentConditions) []uint16 {
+	return []uint16{
+		modbusSigned(c.Temp, 10),
+		modbusUnsigned(c.Humidity, 10),
+		modbusUnsigned(c.WindSpeed, 10),
+		modbusUnsigned(c.WindDirection, 10),
+		modbusUnsigned(c.RainInches, 100),
+		modbusUnsigned(c.PressureHPa, 10),
+		uint16(c.Battery),
+	}
+}
+
+func modbusSigned(v float32, scale float64) uint16 {
+	scaled := math.Round(float64(v) * scale)
+	return uint16(int16(max(min(scaled, math.MaxInt16), math.MinInt16)))
+}
+
+func modbusUnsigned(v float32, scale float64) uint16 {
+	scaled := math.Round(float64(v) * scale)
+	return uint16(max(min(scaled, math.MaxUint16), 0))
+}
+
+type ModbusServer struct {
+	conditions func() CurrentConditions
+}
+
+// NewModbusServer serves whatever conditions returns at the time of each read
+func NewModbusServer(conditions func() CurrentConditions) *ModbusServer {
+	return &ModbusServer{conditions: conditions}
+}
+
+func (s *ModbusServer) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Modbus TCP listening on %s", addr)
+	return s.Serve(listener)
+}
+
+func (s *ModbusServer) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *ModbusServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(modbusIdleTimeout))
+
+		// MBAP header: transaction id, protocol id, length, unit id
+		header := make([]byte, 7)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Printf("Modbus %s: %s", conn.RemoteAddr(), err)
+			}
+			return
+		}
+
+		length := binary.BigEndian.Uint16(header[4:6])
+		if binary.BigEndian.Uint16(header[2:4]) != 0 || length < 2 || length > 254 {
+			log.Printf("Modbus %s: malformed header", conn.RemoteAddr())
+			return
+		}
+
+		pdu := make([]byte, length-1)
+		if _, err := io.ReadFull(conn, pdu); err != nil {
+			log.Printf("Modbus %s: %s", conn.RemoteAddr(), err)
+			return
+		}
+
+		response := s.handle(pdu)
+
+		reply := make([]byte, 7, 7+len(response))
+		copy(reply, header[:4])
+		binary.BigEndian.PutUint16(reply[4:6], uint16(len(response)+1))
+		reply[6] = header[6]
+		reply = append(reply, response...)
+
+		if _, err := conn.Write(reply); err != nil {
+			log.Printf("Modbus %s: %s", conn.RemoteAddr(), err)
+			return
+		}
+	}
+}
+
+// handle answers a single request PDU
+func (s *ModbusServer) handle(pdu []byte) []byte {
+	function := pdu[0]
+	if function != MODBUS_READ_HOLDING_REGISTERS {
+		return []byte{function | 0x80, modbusIllegalFunction}
+	}
+
+	if len(pdu) != 5 {
+		return []byte{function | 0x80, modbusIllegalDataValue}
+	}
+
+	start := int(binary.BigEndian.Uint16(pdu[1:3]))
+	quantity := int(binary.BigEndian.Uint16(pdu[3:5]))
+	if quantity < 1 || quantity > modbusMaxRead {
+		return []byte{function | 0x80, modbusIllegalDataValue}
+	}
+
+	registers := ModbusRegisters(s.conditions())
+	if start+quantity > len(registers) {
+		return []byte{function | 0x80, modbusIllegalDataAddress}
+	}
+
+	response := []byte{function, byte(quantity * 2)}
+	for _, r := range registers[start : start+quantity] {
+		response = binary.BigEndian.AppendUint16(response, r)
+	}
+
+	return response
+}
@@ -0,0 +1,138 @@
+package weathermetrics
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+// startModbus serves c on a loopback port and returns a connection to it
+func startModbus(t *testing.T, c CurrentConditions) net.Conn {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	server := NewModbusServer(func() CurrentConditions { return c })
+	go server.Serve(listener)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(time.Second))
+
+	return conn
+}
+
+// modbusRequest sends a raw PDU as transaction id and returns the response
+// PDU, checking the MBAP header echoes the request
+func modbusRequest(t *testing.T, conn net.Conn, id uint16, pdu []byte) []byte {
+	t.Helper()
+
+	request := binary.BigEndian.AppendUint16(nil, id)
+	request = binary.BigEndian.AppendUint16(request, 0)
+	request = binary.BigEndian.AppendUint16(request, uint16(len(pdu)+1))
+	request = append(request, 0x11)
+	request = append(request, pdu...)
+	if _, err := conn.Write(request); err != nil {
+		t.Fatal(err)
+	}
+
+	header := make([]byte, 7)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		t.Fatal(err)
+	}
+	if got := binary.BigEndian.Uint16(header[0:2]); got != id || header[6] != 0x11 {
+		t.Fatalf("response header %x for transaction %d unit 0x11", header, id)
+	}
+
+	response := make([]byte, binary.BigEndian.Uint16(header[4:6])-1)
+	if _, err := io.ReadFull(conn, response); err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+// readHoldingRegisters is function code 3 as a Modbus client sends it
+func readHoldingRegisters(t *testing.T, conn net.Conn, start, quantity uint16) []uint16 {
+	t.Helper()
+
+	pdu := []byte{MODBUS_READ_HOLDING_REGISTERS}
+	pdu = binary.BigEndian.AppendUint16(pdu, start)
+	pdu = binary.BigEndian.AppendUint16(pdu, quantity)
+
+	response := modbusRequest(t, conn, start+1, pdu)
+	if response[0] != MODBUS_READ_HOLDING_REGISTERS || int(response[1]) != 2*int(quantity) {
+		t.Fatalf("read %d at %d: response %x", quantity, start, response)
+	}
+
+	registers := make([]uint16, quantity)
+	for i := range registers {
+		registers[i] = binary.BigEndian.Uint16(response[2+2*i:])
+	}
+	return registers
+}
+
+func TestModbusReadsScaledRegisters(t *testing.T) {
+	conn := startModbus(t, CurrentConditions{
+		Temp:          -12.34,
+		Humidity:      97,
+		WindSpeed:     12.25,
+		WindDirection: 337.5,
+		RainInches:    1.234,
+		PressureHPa:   1013.25,
+		Battery:       1,
+	})
+
+	registers := readHoldingRegisters(t, conn, 0, 7)
+
+	// Temperature is two's complement: -12.34F is -123 tenths
+	if got := int16(registers[0]); got != -123 {
+		t.Errorf("temperature register %d, want -123", got)
+	}
+
+	want := []uint16{970, 123, 3375, 123, 10133, 1}
+	if got := registers[1:]; !slices.Equal(got, want) {
+		t.Errorf("registers 1-6 = %v, want %v", got, want)
+	}
+
+	// A later read on the same connection can start part way in
+	if got := readHoldingRegisters(t, conn, 4, 2); !slices.Equal(got, []uint16{123, 10133}) {
+		t.Errorf("registers 4-5 = %v, want [123 10133]", got)
+	}
+}
+
+func TestModbusExceptions(t *testing.T) {
+	conn := startModbus(t, CurrentConditions{Temp: 70})
+
+	for _, tc := range []struct {
+		name string
+		pdu  []byte
+		want []byte
+	}{
+		{name: "write single register", pdu: []byte{0x06, 0, 0, 0, 1}, want: []byte{0x86, 0x01}},
+		{name: "past the map", pdu: []byte{0x03, 0, 5, 0, 3}, want: []byte{0x83, 0x02}},
+		{name: "zero quantity", pdu: []byte{0x03, 0, 0, 0, 0}, want: []byte{0x83, 0x03}},
+		{name: "short request", pdu: []byte{0x03, 0, 0}, want: []byte{0x83, 0x03}},
+	} {
+		if got := modbusRequest(t, conn, 7, tc.pdu); !slices.Equal(got, tc.want) {
+			t.Errorf("%s: response %x, want %x", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestModbusRegisterClamping(t *testing.T) {
+	registers := ModbusRegisters(CurrentConditions{Temp: 5000, Humidity: -1, PressureHPa: 7000})
+
+	if int16(registers[0]) != 32767 || registers[1] != 0 || registers[5] != 65535 {
+		t.Errorf("out of range values became %d, %d, %d", int16(registers[0]), registers[1], registers[5])
+	}
+}
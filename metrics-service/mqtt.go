@@ -2,9 +2,15 @@ package weathermetrics
 
 import (
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
@@ -15,10 +21,137 @@ import (
  */
 type MQTTConfig struct {
 	MQTTServer string `envconfig:"MQTT_SERVER" default:"mqtt:1883"`
-	Topic      string `envconfig:"MQTT_TOPIC" default:"rtl_433/+/events"`
-	Username   string `envconfig:"MQTT_USERNAME"`
-	Password   string `envconfig:"MQTT_PASSWORD"`
-	ClientID   string `envconfig:"MQTT_CLIENTID"`
+
+	// MQTTServers lists brokers to fail over between, e.g.
+	// "mqtt1:1883,mqtt2:1883". When set it takes precedence over MQTTServer.
+	MQTTServers []string `envconfig:"MQTT_SERVERS"`
+
+	// Topic may be a shared subscription, "$share/<group>/<filter>", so that
+	// several replicas split the messages between them. That needs broker
+	// support (Mosquitto 2, EMQX, HiveMQ) but not MQTT 5; brokers accept it
+	// from the 3.1.1 clients paho v1 speaks.
+	Topic    string `envconfig:"MQTT_TOPIC" default:"rtl_433/+/events"`
+	Username string `envconfig:"MQTT_USERNAME"`
+	Password string `envconfig:"MQTT_PASSWORD" secret:"true"`
+	ClientID string `envconfig:"MQTT_CLIENTID"`
+
+	// PayloadKey unwraps readings nested in an envelope, e.g. "payload"
+	PayloadKey string `envconfig:"MQTT_PAYLOAD_KEY"`
+
+	// TopicIDSegment is the zero-based index of the topic level that names
+	// the device, e.g. 1 for the "+" in rtl_433/+/events. It identifies
+	// payloads that lack an id. Negative disables it.
+	TopicIDSegment int `envconfig:"MQTT_TOPIC_ID_SEGMENT" default:"-1"`
+
+	// MaxPayloadSize drops messages larger than this many bytes before they
+	// are decoded. Zero disables the limit.
+	MaxPayloadSize int `envconfig:"MQTT_MAX_PAYLOAD_SIZE" default:"65536"`
+
+	// InferMessageType routes payloads without a message_type, as older
+	// rtl_433 versions send, by which fields they carry
+	InferMessageType bool `envconfig:"MQTT_INFER_MESSAGE_TYPE" default:"true"`
+
+	// ConnectLogInterval throttles connection attempt logging during an
+	// outage. Attempts still happen every couple of seconds.
+	ConnectLogInterval time.Duration `envconfig:"MQTT_CONNECT_LOG_INTERVAL" default:"1m"`
+}
+
+// Brokers returns the broker URLs to connect to, in failover order
+func (c MQTTConfig) Brokers() []string {
+	servers := c.MQTTServers
+	if len(servers) == 0 && c.MQTTServer != "" {
+		servers = []string{c.MQTTServer}
+	}
+
+	brokers := []string{}
+	for _, server := range servers {
+		server = strings.TrimSpace(server)
+		if server == "" {
+			continue
+		}
+		if !strings.Contains(server, "://") {
+			server = "tcp://" + server
+		}
+		brokers = append(brokers, server)
+	}
+
+	return brokers
+}
+
+func (c MQTTConfig) Validate() error {
+	if len(c.Brokers()) == 0 {
+		return fmt.Errorf("MQTT_SERVERS or MQTT_SERVER must name at least one broker")
+	}
+
+	if c.MaxPayloadSize < 0 {
+		return fmt.Errorf("MQTT_MAX_PAYLOAD_SIZE must not be negative, got %d", c.MaxPayloadSize)
+	}
+
+	if strings.HasPrefix(c.Topic, "$share") {
+		if _, _, ok := SplitSharedTopic(c.Topic); !ok {
+			return fmt.Errorf("MQTT_TOPIC must be $share/<group>/<filter>, got %q", c.Topic)
+		}
+	}
+
+	return nil
+}
+
+// PayloadTooLarge reports whether payload exceeds MQTT_MAX_PAYLOAD_SIZE
+func (c MQTTConfig) PayloadTooLarge(payload []byte) bool {
+	return c.MaxPayloadSize > 0 && len(payload) > c.MaxPayloadSize
+}
+
+// SplitSharedTopic splits a "$share/<group>/<filter>" shared subscription.
+// ok is false for an ordinary topic or a malformed shared one.
+func SplitSharedTopic(topic string) (group, filter string, ok bool) {
+	parts := strings.SplitN(topic, "/", 3)
+	if len(parts) != 3 || parts[0] != "$share" {
+		return "", "", false
+	}
+
+	group, filter = parts[1], parts[2]
+	if group == "" || filter == "" || strings.ContainsAny(group, "+#") {
+		return "", "", false
+	}
+
+	return group, filter, true
+}
+
+// clientID returns the client id to connect with. Replicas sharing a
+// subscription usually share their configuration too, and a broker drops
+// the older of two connections with the same id, so the hostname is
+// appended to a configured id when the topic is shared.
+func (c MQTTConfig) clientID() string {
+	if c.ClientID == "" {
+		return ""
+	}
+
+	if _, _, shared := SplitSharedTopic(c.Topic); !shared {
+		return c.ClientID
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Printf("could not get hostname for a unique client id: %s", err)
+		return c.ClientID
+	}
+
+	return c.ClientID + "-" + hostname
+}
+
+// TopicSegment returns level index of topic, or "" if index is negative or
+// the topic is too short
+func TopicSegment(topic string, index int) string {
+	if index < 0 {
+		return ""
+	}
+
+	segments := strings.Split(topic, "/")
+	if index >= len(segments) {
+		return ""
+	}
+
+	return segments[index]
 }
 
 const (
@@ -26,43 +159,211 @@ const (
 	WIND_RAIN_MESSAGE     = 49
 )
 
+// RTL433_TIME_FORMAT is the layout of rtl_433's "time" field, which is in the
+// receiver's local time
+const RTL433_TIME_FORMAT = "2006-01-02 15:04:05"
+
+func ParseMessageTime(timestamp string, loc *time.Location) (time.Time, error) {
+	return time.ParseInLocation(RTL433_TIME_FORMAT, timestamp, loc)
+}
+
+// FormatCompact renders v with only as many decimals as it needs, so
+// integer-valued readings such as humidity come out as "97" rather than
+// "97.000000". Formatting at 32 bits avoids float32 rounding noise.
+func FormatCompact(v float32) string {
+	return strconv.FormatFloat(float64(v), 'f', -1, 32)
+}
+
+// Channel is rtl_433's channel, a letter on Acurite sensors but a number on
+// many other devices. Either form decodes to a string so both can share a
+// station key.
+type Channel string
+
+func (c *Channel) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*c = Channel(s)
+		return nil
+	}
+
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return fmt.Errorf("channel must be a string or number, got %s", data)
+	}
+	*c = Channel(n.String())
+	return nil
+}
+
 type TempHumidityMeasurement struct {
 	Timestamp   string  `json:"time"`
+	Model       string  `json:"model"`
+	ID          int     `json:"id"`
+	Channel     Channel `json:"channel"`
 	Temp        float32 `json:"temperature_F"`
 	Humidity    float32 `json:"humidity"`
+	PressureHPa float32 `json:"pressure_hPa"`
 	Battery     int     `json:"battery_ok"`
 	MessageType int     `json:"message_type"`
+	Mic         string  `json:"mic"`
+	Sequence    *int    `json:"sequence_num"`
+
+	// Source identifies the device from the topic when the payload has no id
+	Source string `json:"-"`
+
+	// tempC is temperature_C when the payload had it alongside
+	// temperature_F, kept for the validator's consistency check
+	tempC *float32
+}
+
+// UnmarshalJSON keeps temperature_C when it arrives alongside temperature_F.
+// On its own it is an alias, converted by ApplyAliases before decoding.
+func (m *TempHumidityMeasurement) UnmarshalJSON(data []byte) error {
+	type measurement TempHumidityMeasurement
+	aux := struct {
+		*measurement
+		TempC *float32 `json:"temperature_C"`
+	}{measurement: (*measurement)(m)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	m.tempC = aux.TempC
+	return nil
 }
 
 type WindRainMeasurement struct {
 	Timestamp     string  `json:"time"`
+	Model         string  `json:"model"`
+	ID            int     `json:"id"`
+	Channel       Channel `json:"channel"`
 	WindSpeed     float32 `json:"wind_avg_km_h"`
+	WindGust      float32 `json:"wind_max_km_h"`
 	WindDirection float32 `json:"wind_dir_deg"`
 	RainInches    float32 `json:"rain_in"`
 	Battery       int     `json:"battery_ok"`
 	MessageType   int     `json:"message_type"`
+	Mic           string  `json:"mic"`
+	Sequence      *int    `json:"sequence_num"`
+
+	// Source identifies the device from the topic when the payload has no id
+	Source string `json:"-"`
+
+	// DirectionMissing is set when the payload carried no wind direction,
+	// as with stations that only report it alongside gusts
+	DirectionMissing bool `json:"-"`
+
+	// SpeedMissing and HasGust record which of wind_avg_km_h and
+	// wind_max_km_h the payload carried, so one never clobbers the other
+	SpeedMissing bool `json:"-"`
+	HasGust      bool `json:"-"`
+
+	// RainMissing is set when the validator threw out a bad rain_in, so
+	// the last good total stands
+	RainMissing bool `json:"-"`
+}
+
+// UnmarshalJSON also accepts wind direction as wind_dir, which some decoders
+// emit as a compass point ("NW") instead of wind_dir_deg
+func (m *WindRainMeasurement) UnmarshalJSON(data []byte) error {
+	type measurement WindRainMeasurement
+	aux := struct {
+		*measurement
+		WindAvg    *float32        `json:"wind_avg_km_h"`
+		WindMax    *float32        `json:"wind_max_km_h"`
+		WindDirDeg *float32        `json:"wind_dir_deg"`
+		WindDir    json.RawMessage `json:"wind_dir"`
+	}{measurement: (*measurement)(m)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	if aux.WindAvg != nil {
+		m.WindSpeed = *aux.WindAvg
+	}
+	m.SpeedMissing = aux.WindAvg == nil
+
+	if aux.WindMax != nil {
+		m.WindGust = *aux.WindMax
+	}
+	m.HasGust = aux.WindMax != nil
+
+	if aux.WindDirDeg != nil {
+		m.WindDirection = *aux.WindDirDeg
+	}
+	m.DirectionMissing = aux.WindDirDeg == nil && len(aux.WindDir) == 0
+
+	if len(aux.WindDir) > 0 && m.WindDirection == 0 {
+		degrees, err := parseWindDir(aux.WindDir)
+		if err != nil {
+			return err
+		}
+		m.WindDirection = degrees
+	}
+
+	return nil
 }
 
-func NewMQTTClient(conf MQTTConfig) (mqtt.Client, error) {
+// NewMQTTClient builds a client for conf. stats may be nil if the caller doesn't
+// report connection state. subs are (re)established on every connect, so they
+// survive the broker dropping the session.
+func NewMQTTClient(conf MQTTConfig, stats *ConnectionStats, subs ...Subscription) (mqtt.Client, error) {
+	if stats == nil {
+		stats = &ConnectionStats{}
+	}
+	brokers := newBrokerTracker(conf.Brokers(), stats)
+	brokers.logInterval = conf.ConnectLogInterval
+	brokers.subs = subs
+
 	opts := mqtt.NewClientOptions()
-	opts.AddBroker(fmt.Sprintf("tcp://%s", conf.MQTTServer))
-	opts.SetClientID(conf.ClientID)
+	for _, broker := range brokers.brokers {
+		opts.AddBroker(broker)
+	}
+	opts.SetClientID(conf.clientID())
 	opts.SetConnectRetry(true)
 	opts.SetConnectRetryInterval(time.Second * 2)
-	opts.SetConnectionAttemptHandler(connectAttemptHandler)
+	opts.SetConnectionAttemptHandler(brokers.connectAttemptHandler)
 	if len(conf.Username) > 0 {
 		opts.SetUsername(conf.Username)
 		opts.SetPassword(conf.Password)
 	}
 
 	opts.SetDefaultPublishHandler(messagePubHandler)
-	opts.OnConnect = connectHandler
-	opts.OnConnectionLost = connectLostHandler
+	opts.OnConnect = brokers.connectHandler
+	opts.OnConnectionLost = brokers.connectLostHandler
 	client := mqtt.NewClient(opts)
 
 	return client, nil
 }
 
+/*
+ * Subscriptions
+ */
+type Subscription struct {
+	Topic   string
+	Handler mqtt.MessageHandler
+}
+
+// Subscriber is the part of mqtt.Client SubscribeAll needs
+type Subscriber interface {
+	Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token
+}
+
+// SubscribeAll registers every subscription on client. It runs from the
+// OnConnect handler, so it also restores subscriptions after a reconnect.
+func SubscribeAll(client Subscriber, subs []Subscription) error {
+	for _, s := range subs {
+		token := client.Subscribe(s.Topic, 1, s.Handler)
+		if token.Wait() && token.Error() != nil {
+			return fmt.Errorf("subscribing to %s: %w", s.Topic, token.Error())
+		}
+		log.Printf("Subscribed to topic: %s", s.Topic)
+	}
+
+	return nil
+}
+
 /*
  * MQTT Message Handlers
  */
@@ -81,24 +382,187 @@ func messagePubHandler(client mqtt.Client, msg mqtt.Message) {
 */
 
 type CurrentConditions struct {
-	Timestamp     string  `json:"time"`
-	Temp          float32 `json:"temperature_F"`
-	Humidity      float32 `json:"humidity"`
-	Battery       int     `json:"battery_ok"`
-	WindSpeed     float32 `json:"wind_avg_km_h"`
-	WindDirection float32 `json:"wind_dir_deg"`
-	RainInches    float32 `json:"rain_in"`
+	Timestamp     string   `json:"time"`
+	Model         string   `json:"model,omitempty"`
+	ID            int      `json:"id,omitempty"`
+	Channel       string   `json:"channel,omitempty"`
+	Source        string   `json:"source,omitempty"`
+	Temp          float32  `json:"temperature_F"`
+	Humidity      float32  `json:"humidity"`
+	PressureHPa   float32  `json:"pressure_hPa,omitempty"`
+	Battery       int      `json:"battery_ok"`
+	WindSpeed     float32  `json:"wind_avg_km_h"`
+	WindGust      *float32 `json:"wind_max_km_h,omitempty"`
+	WindDirection float32  `json:"wind_dir_deg"`
+	RainInches    float32  `json:"rain_in"`
+
+	// Held marks a fixed cadence sample that repeats the previous one
+	// because no new reading arrived in between
+	Held bool `json:"held,omitempty"`
+}
+
+// Summary is a compact human readable form for logs
+func (c CurrentConditions) Summary() string {
+	return fmt.Sprintf("temp %.1fF humidity %.0f%% wind %.1fkm/h from %.0f deg rain %.2fin",
+		c.Temp, c.Humidity, c.WindSpeed, c.WindDirection, c.RainInches)
+}
+
+func (c *CurrentConditions) ApplyTempHumidity(m TempHumidityMeasurement) {
+	c.Timestamp = m.Timestamp
+	c.Model = m.Model
+	c.ID = m.ID
+	c.Channel = string(m.Channel)
+	c.Source = m.Source
+	c.Temp = m.Temp
+	c.Humidity = m.Humidity
+	c.Battery = m.Battery
+
+	// Not every sensor reports pressure, so keep the last reading
+	if m.PressureHPa > 0 {
+		c.PressureHPa = m.PressureHPa
+	}
+}
+
+func (c *CurrentConditions) ApplyWindRain(m WindRainMeasurement) {
+	c.Timestamp = m.Timestamp
+	c.Model = m.Model
+	c.ID = m.ID
+	c.Channel = string(m.Channel)
+	c.Source = m.Source
+	c.Battery = m.Battery
+
+	if !m.SpeedMissing {
+		c.WindSpeed = m.WindSpeed
+	}
+
+	if m.HasGust {
+		gust := m.WindGust
+		c.WindGust = &gust
+	}
+
+	// Keep the last direction for speed-only messages
+	if !m.DirectionMissing {
+		c.WindDirection = m.WindDirection
+	}
+
+	if !m.RainMissing {
+		c.RainInches = m.RainInches
+	}
+}
+
+/*
+ * Connection Handlers
+ *
+ * The handlers hang off a brokerTracker so each log line can name the full
+ * broker URL (scheme, host and port) and which of the configured brokers it is.
+ */
+type brokerTracker struct {
+	m       sync.Mutex
+	brokers []string
+	current string
+	stats   *ConnectionStats
+	subs    []Subscription
+
+	logInterval time.Duration
+	lastLogged  time.Time
+	suppressed  int
 }
 
-func connectHandler(client mqtt.Client) {
-	log.Println("Connected")
+func newBrokerTracker(brokers []string, stats *ConnectionStats) *brokerTracker {
+	return &brokerTracker{brokers: brokers, stats: stats}
 }
 
-func connectAttemptHandler(broker *url.URL, tlsCfg *tls.Config) *tls.Config {
-	log.Printf("Attempting connection to %s", broker.Host)
+func (b *brokerTracker) describe(broker string) string {
+	for i := range b.brokers {
+		if b.brokers[i] == broker {
+			return fmt.Sprintf("%s (broker %d of %d)", RedactURL(broker), i+1, len(b.brokers))
+		}
+	}
+
+	return RedactURL(broker)
+}
+
+func (b *brokerTracker) currentBroker() string {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	if b.current == "" {
+		return "unknown broker"
+	}
+
+	return b.describe(b.current)
+}
+
+func (b *brokerTracker) connectHandler(client mqtt.Client) {
+	b.stats.connected.Store(true)
+
+	// Log the first attempt of the next outage straight away
+	b.m.Lock()
+	b.lastLogged = time.Time{}
+	b.suppressed = 0
+	b.m.Unlock()
+
+	log.Printf("Connected to %s", b.currentBroker())
+
+	if err := SubscribeAll(client, b.subs); err != nil {
+		log.Print(err)
+	}
+}
+
+func (b *brokerTracker) connectAttemptHandler(broker *url.URL, tlsCfg *tls.Config) *tls.Config {
+	b.m.Lock()
+	b.current = broker.String()
+	now := time.Now()
+	if !b.lastLogged.IsZero() && now.Sub(b.lastLogged) < b.logInterval {
+		b.suppressed++
+		b.m.Unlock()
+		return tlsCfg
+	}
+	suppressed := b.suppressed
+	b.lastLogged = now
+	b.suppressed = 0
+	b.m.Unlock()
+
+	if suppressed > 0 {
+		log.Printf("Attempting connection to %s (%d attempts not logged)", b.currentBroker(), suppressed)
+	} else {
+		log.Printf("Attempting connection to %s", b.currentBroker())
+	}
 	return tlsCfg
 }
 
-func connectLostHandler(client mqtt.Client, err error) {
-	log.Printf("Connect lost: %v", err)
+func (b *brokerTracker) connectLostHandler(client mqtt.Client, err error) {
+	b.stats.connected.Store(false)
+	b.stats.reconnects.Add(1)
+	log.Printf("Connect lost to %s: %v", b.currentBroker(), err)
+}
+
+/*
+ * Connection Stats
+ *
+ * Updated from the paho callbacks and read by the metrics renderers, so the
+ * fields are atomics rather than sharing the app mutex.
+ */
+type ConnectionStats struct {
+	reconnects atomic.Int64
+	connected  atomic.Bool
+	oversized  atomic.Int64
+}
+
+// Reconnects counts lost connections, each of which paho retries
+func (s *ConnectionStats) Reconnects() int64 {
+	return s.reconnects.Load()
+}
+
+func (s *ConnectionStats) Connected() bool {
+	return s.connected.Load()
+}
+
+func (s *ConnectionStats) ObserveOversized() {
+	s.oversized.Add(1)
+}
+
+// Oversized counts messages dropped for exceeding MQTT_MAX_PAYLOAD_SIZE
+func (s *ConnectionStats) Oversized() int64 {
+	return s.oversized.Load()
 }
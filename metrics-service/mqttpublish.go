@@ -0,0 +1,209 @@
+package weathermetrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+/*
+ * Config
+ */
+type MQTTPublishConfig struct {
+	// PublishTopic is the prefix conditions are republished under, e.g.
+	// "weather". Empty disables publishing.
+	PublishTopic string `envconfig:"MQTT_PUBLISH_TOPIC"`
+	Retain       bool   `envconfig:"MQTT_PUBLISH_RETAIN" default:"true"`
+
+	// PublishDerived also publishes dew point, heat index and wind chill to
+	// their own topics under PublishTopic/derived
+	PublishDerived bool `envconfig:"MQTT_PUBLISH_DERIVED" default:"false"`
+
+	// PublishRTL433 also publishes the merged conditions to
+	// PublishTopic/rtl_433 as the two messages rtl_433 itself would send,
+	// for chaining in front of other rtl_433 consumers
+	PublishRTL433 bool `envconfig:"MQTT_PUBLISH_RTL433" default:"false"`
+
+	// An update is only republished if some value moved by more than
+	// PublishMinChange since the last publish, or PublishHeartbeat has
+	// passed. A zero heartbeat disables it.
+	PublishMinChange float64       `envconfig:"MQTT_PUBLISH_MIN_CHANGE" default:"0"`
+	PublishHeartbeat time.Duration `envconfig:"MQTT_PUBLISH_HEARTBEAT" default:"5m"`
+}
+
+func (c MQTTPublishConfig) Enabled() bool {
+	return c.PublishTopic != ""
+}
+
+func (c MQTTPublishConfig) Validate() error {
+	if c.PublishMinChange < 0 {
+		return fmt.Errorf("MQTT_PUBLISH_MIN_CHANGE must not be negative, got %v", c.PublishMinChange)
+	}
+
+	if c.PublishHeartbeat < 0 {
+		return fmt.Errorf("MQTT_PUBLISH_HEARTBEAT must not be negative, got %s", c.PublishHeartbeat)
+	}
+
+	return nil
+}
+
+/*
+ * MQTT publish sink
+ *
+ * Republishes the merged conditions as JSON to PublishTopic/conditions after
+ * every update, and optionally each derived value as a bare number so Home
+ * Assistant style consumers can use them without a template. Publishing is
+ * fire and forget; paho queues the message and Write never waits on it.
+ *
+ * The 5n1 repeats itself every 18 seconds, so unchanged readings are held
+ * back until the heartbeat is due.
+ *
+ * The rtl_433 form is never retained, as rtl_433's own events aren't. Its
+ * topic deliberately doesn't end in /events so that a PublishTopic under
+ * rtl_433/ can't match the default subscription and loop back.
+ */
+
+// Publisher is the part of mqtt.Client the sink needs
+type Publisher interface {
+	Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token
+}
+
+type MQTTPublishSink struct {
+	conf   MQTTPublishConfig
+	client Publisher
+
+	m             sync.Mutex
+	lastValues    map[string]float64
+	lastPublished time.Time
+}
+
+func NewMQTTPublishSink(conf MQTTPublishConfig, client Publisher) *MQTTPublishSink {
+	return &MQTTPublishSink{conf: conf, client: client}
+}
+
+func (s *MQTTPublishSink) Write(c CurrentConditions, at time.Time) {
+	if !s.due(c, at) {
+		return
+	}
+
+	payload, err := json.Marshal(c)
+	if err != nil {
+		log.Printf("could not encode conditions for MQTT: %s", err)
+		return
+	}
+	s.client.Publish(s.conf.PublishTopic+"/conditions", 0, s.conf.Retain, payload)
+
+	if s.conf.PublishRTL433 {
+		for _, message := range RTL433Messages(c) {
+			s.client.Publish(s.conf.PublishTopic+"/rtl_433", 0, false, message)
+		}
+	}
+
+	if !s.conf.PublishDerived {
+		return
+	}
+
+	for name, v := range derivedValues(c) {
+		s.client.Publish(fmt.Sprintf("%s/derived/%s", s.conf.PublishTopic, name), 0, s.conf.Retain, FormatCompact(v))
+	}
+}
+
+// due reports whether c should be published, and if so records it as the
+// last published reading
+func (s *MQTTPublishSink) due(c CurrentConditions, at time.Time) bool {
+	values := conditionSamples(c)
+	values["pressure_hPa"] = float64(c.PressureHPa)
+	values["battery_ok"] = float64(c.Battery)
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	changed := s.lastValues == nil ||
+		(s.conf.PublishHeartbeat > 0 && at.Sub(s.lastPublished) >= s.conf.PublishHeartbeat)
+	for name, v := range values {
+		last, ok := s.lastValues[name]
+		if !ok || math.Abs(v-last) > s.conf.PublishMinChange {
+			changed = true
+		}
+	}
+
+	if !changed {
+		return false
+	}
+
+	s.lastValues = values
+	s.lastPublished = at
+	return true
+}
+
+// RTL433Messages renders c as the temperature/humidity and wind/rain pair an
+// Acurite 5n1 sends, in rtl_433's field names and message types. There is no
+// mic: nothing integrity checked the merged reading, so it mustn't claim to
+// have passed a checksum.
+func RTL433Messages(c CurrentConditions) [][]byte {
+	model := c.Model
+	if model == "" {
+		model = SYNTHETIC_MODEL
+	}
+
+	tempHumidity := map[string]any{
+		"time":          c.Timestamp,
+		"model":         model,
+		"id":            c.ID,
+		"channel":       c.Channel,
+		"battery_ok":    c.Battery,
+		"message_type":  TEMP_HUMIDITY_MESSAGE,
+		"temperature_F": c.Temp,
+		"humidity":      c.Humidity,
+	}
+	if c.PressureHPa != 0 {
+		tempHumidity["pressure_hPa"] = c.PressureHPa
+	}
+
+	windRain := map[string]any{
+		"time":          c.Timestamp,
+		"model":         model,
+		"id":            c.ID,
+		"channel":       c.Channel,
+		"battery_ok":    c.Battery,
+		"message_type":  WIND_RAIN_MESSAGE,
+		"wind_avg_km_h": c.WindSpeed,
+		"wind_dir_deg":  c.WindDirection,
+		"rain_in":       c.RainInches,
+	}
+	if c.WindGust != nil {
+		windRain["wind_max_km_h"] = *c.WindGust
+	}
+
+	messages := [][]byte{}
+	for _, m := range []map[string]any{tempHumidity, windRain} {
+		payload, err := json.Marshal(m)
+		if err != nil {
+			log.Printf("could not encode rtl_433 message: %s", err)
+			continue
+		}
+		messages = append(messages, payload)
+	}
+
+	return messages
+}
+
+// derivedValues mirrors the /conditions derived block plus wind chill
+func derivedValues(c CurrentConditions) map[string]float32 {
+	values := map[string]float32{
+		"wind_chill_F":           c.WindChillF(),
+		"apparent_temperature_F": c.ApparentTemperatureF(),
+	}
+
+	if c.Humidity > 0 {
+		values["dew_point_F"] = c.DewPointF()
+		values["heat_index_F"] = c.HeatIndexF()
+	}
+
+	return values
+}
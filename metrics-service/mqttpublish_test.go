@@ -0,0 +1,276 @@
+package weathermetrics
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+type published struct {
+	topic    string
+	retained bool
+	payload  string
+}
+
+// fakePublisher records Publish calls
+type fakePublisher struct {
+	m        sync.Mutex
+	messages []published
+}
+
+func (p *fakePublisher) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	var s string
+	switch v := payload.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	}
+	p.messages = append(p.messages, published{topic: topic, retained: retained, payload: s})
+	return fakeToken{}
+}
+
+// Topics maps each published topic to its last payload
+func (p *fakePublisher) Topics() map[string]string {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	topics := map[string]string{}
+	for _, m := range p.messages {
+		topics[m.topic] = m.payload
+	}
+	return topics
+}
+
+// Len is how many messages have been published
+func (p *fakePublisher) Len() int {
+	p.m.Lock()
+	defer p.m.Unlock()
+	return len(p.messages)
+}
+
+var publishConditions = CurrentConditions{
+	Model:     SYNTHETIC_MODEL,
+	ID:        1026,
+	Channel:   "C",
+	Temp:      90,
+	Humidity:  50,
+	WindSpeed: 10,
+	Battery:   1,
+}
+
+func TestMQTTPublishDerivedTopics(t *testing.T) {
+	client := &fakePublisher{}
+	sink := NewMQTTPublishSink(MQTTPublishConfig{PublishTopic: "weather", PublishDerived: true, Retain: true}, client)
+
+	sink.Write(publishConditions, time.Now())
+
+	topics := client.Topics()
+	want := []string{
+		"weather/conditions",
+		"weather/derived/apparent_temperature_F",
+		"weather/derived/dew_point_F",
+		"weather/derived/heat_index_F",
+		"weather/derived/wind_chill_F",
+	}
+	if got := slices.Sorted(maps.Keys(topics)); !slices.Equal(got, want) {
+		t.Fatalf("published to %v, want %v", got, want)
+	}
+
+	c := publishConditions
+	for topic, v := range map[string]float32{
+		"weather/derived/dew_point_F":  c.DewPointF(),
+		"weather/derived/heat_index_F": c.HeatIndexF(),
+		"weather/derived/wind_chill_F": c.WindChillF(),
+	} {
+		if topics[topic] != FormatCompact(v) {
+			t.Errorf("%s = %q, want %q", topic, topics[topic], FormatCompact(v))
+		}
+	}
+}
+
+func TestMQTTPublishWithoutDerived(t *testing.T) {
+	client := &fakePublisher{}
+	sink := NewMQTTPublishSink(MQTTPublishConfig{PublishTopic: "weather"}, client)
+
+	sink.Write(publishConditions, time.Now())
+
+	if got := slices.Sorted(maps.Keys(client.Topics())); !slices.Equal(got, []string{"weather/conditions"}) {
+		t.Errorf("published to %v, want only weather/conditions", got)
+	}
+}
+
+func TestMQTTPublishDerivedSkipsHumidityValuesWithoutHumidity(t *testing.T) {
+	client := &fakePublisher{}
+	sink := NewMQTTPublishSink(MQTTPublishConfig{PublishTopic: "weather", PublishDerived: true}, client)
+
+	c := publishConditions
+	c.Humidity = 0
+	sink.Write(c, time.Now())
+
+	topics := client.Topics()
+	for _, topic := range []string{"weather/derived/dew_point_F", "weather/derived/heat_index_F"} {
+		if _, ok := topics[topic]; ok {
+			t.Errorf("published %s without a humidity reading", topic)
+		}
+	}
+}
+
+func TestMQTTPublishHoldsBackSmallChanges(t *testing.T) {
+	client := &fakePublisher{}
+	sink := NewMQTTPublishSink(MQTTPublishConfig{PublishTopic: "weather", PublishMinChange: 0.5}, client)
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	c := publishConditions
+	sink.Write(c, start)
+	if client.Len() != 1 {
+		t.Fatalf("first reading published %d times, want 1", client.Len())
+	}
+
+	// Repeats and moves within the threshold are held back
+	sink.Write(c, start.Add(18*time.Second))
+	c.Temp += 0.5
+	sink.Write(c, start.Add(36*time.Second))
+	if client.Len() != 1 {
+		t.Errorf("published %d times after changes within the threshold, want 1", client.Len())
+	}
+
+	// Measured from the last publish, not the last reading, so a slow
+	// drift is still published
+	c.Temp += 0.1
+	sink.Write(c, start.Add(54*time.Second))
+	if client.Len() != 2 {
+		t.Errorf("published %d times after a 0.6 drift, want 2", client.Len())
+	}
+}
+
+func TestMQTTPublishHeartbeat(t *testing.T) {
+	client := &fakePublisher{}
+	sink := NewMQTTPublishSink(MQTTPublishConfig{PublishTopic: "weather", PublishHeartbeat: 5 * time.Minute}, client)
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	sink.Write(publishConditions, start)
+	sink.Write(publishConditions, start.Add(5*time.Minute-time.Second))
+	if client.Len() != 1 {
+		t.Errorf("unchanged reading published %d times before the heartbeat, want 1", client.Len())
+	}
+
+	sink.Write(publishConditions, start.Add(5*time.Minute))
+	if client.Len() != 2 {
+		t.Errorf("unchanged reading published %d times at the heartbeat, want 2", client.Len())
+	}
+
+	// The next heartbeat counts from that publish
+	sink.Write(publishConditions, start.Add(9*time.Minute))
+	if client.Len() != 2 {
+		t.Errorf("published %d times before the second heartbeat, want 2", client.Len())
+	}
+}
+
+func TestMQTTPublishWithoutHeartbeat(t *testing.T) {
+	client := &fakePublisher{}
+	sink := NewMQTTPublishSink(MQTTPublishConfig{PublishTopic: "weather"}, client)
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	sink.Write(publishConditions, start)
+	sink.Write(publishConditions, start.Add(24*time.Hour))
+	if client.Len() != 1 {
+		t.Errorf("unchanged reading published %d times with the heartbeat off, want 1", client.Len())
+	}
+}
+
+func TestMQTTPublishRTL433Schema(t *testing.T) {
+	client := &fakePublisher{}
+	sink := NewMQTTPublishSink(MQTTPublishConfig{PublishTopic: "weather", PublishRTL433: true}, client)
+
+	gust := float32(20)
+	c := publishConditions
+	c.Timestamp = "2024-06-01 12:00:00"
+	c.PressureHPa = 1013
+	c.WindGust = &gust
+	sink.Write(c, time.Now())
+
+	var messages []map[string]any
+	for _, m := range client.messages {
+		if m.topic != "weather/rtl_433" {
+			continue
+		}
+		if m.retained {
+			t.Error("rtl_433 message retained")
+		}
+
+		var fields map[string]any
+		if err := json.Unmarshal([]byte(m.payload), &fields); err != nil {
+			t.Fatalf("%s: %s", err, m.payload)
+		}
+		messages = append(messages, fields)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("%d rtl_433 messages, want a temperature/humidity and a wind/rain one", len(messages))
+	}
+
+	common := []string{"time", "model", "id", "channel", "battery_ok", "message_type"}
+	for i, want := range []struct {
+		messageType float64
+		fields      []string
+	}{
+		{messageType: TEMP_HUMIDITY_MESSAGE, fields: []string{"temperature_F", "humidity", "pressure_hPa"}},
+		{messageType: WIND_RAIN_MESSAGE, fields: []string{"wind_avg_km_h", "wind_max_km_h", "wind_dir_deg", "rain_in"}},
+	} {
+		keys := slices.Sorted(maps.Keys(messages[i]))
+		wantKeys := slices.Sorted(slices.Values(append(slices.Clone(common), want.fields...)))
+		if !slices.Equal(keys, wantKeys) {
+			t.Errorf("message %d has fields %v, want %v", i, keys, wantKeys)
+		}
+
+		if messages[i]["message_type"] != want.messageType {
+			t.Errorf("message %d message_type = %v, want %v", i, messages[i]["message_type"], want.messageType)
+		}
+		if messages[i]["model"] != SYNTHETIC_MODEL || messages[i]["id"] != float64(1026) ||
+			messages[i]["channel"] != "C" || messages[i]["time"] != "2024-06-01 12:00:00" {
+			t.Errorf("message %d doesn't identify the station: %v", i, messages[i])
+		}
+	}
+
+	// They decode as the measurements this service reads from rtl_433
+	var th TempHumidityMeasurement
+	if err := json.Unmarshal([]byte(mustMarshal(t, messages[0])), &th); err != nil || th.Temp != 90 || th.Humidity != 50 || th.Mic != "" {
+		t.Errorf("temperature/humidity decoded as %+v, %v", th, err)
+	}
+	var wr WindRainMeasurement
+	if err := json.Unmarshal([]byte(mustMarshal(t, messages[1])), &wr); err != nil || wr.WindSpeed != 10 || !wr.HasGust || wr.WindGust != 20 {
+		t.Errorf("wind/rain decoded as %+v, %v", wr, err)
+	}
+}
+
+func TestRTL433MessagesOmitMissingGustAndPressure(t *testing.T) {
+	for _, payload := range RTL433Messages(publishConditions) {
+		var fields map[string]any
+		if err := json.Unmarshal(payload, &fields); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"wind_max_km_h", "pressure_hPa", "mic"} {
+			if _, ok := fields[name]; ok {
+				t.Errorf("%s present in %s", name, payload)
+			}
+		}
+	}
+}
+
+func mustMarshal(t *testing.T, v any) string {
+	t.Helper()
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,104 @@
+package weathermetrics
+
+import (
+	"fmt"
+	"time"
+)
+
+/*
+ * Config
+ *
+ * PAIR_MESSAGES holds sink writes back until a station has sent both its
+ * temperature/humidity and its wind/rain message, so each write is one
+ * complete reading rather than two partial ones. A half whose partner hasn't
+ * arrived within PAIR_TIMEOUT is written on its own.
+ */
+type PairConfig struct {
+	PairMessages bool          `envconfig:"PAIR_MESSAGES" default:"false"`
+	PairTimeout  time.Duration `envconfig:"PAIR_TIMEOUT" default:"30s"`
+}
+
+func (c PairConfig) Validate() error {
+	if c.PairMessages && c.PairTimeout <= 0 {
+		return fmt.Errorf("PAIR_TIMEOUT must be positive, got %s", c.PairTimeout)
+	}
+
+	return nil
+}
+
+/*
+ * Message pairing
+ *
+ * The 5n1 alternates its two message types, so one transmission cycle is a
+ * temperature/humidity message and a wind/rain message a few seconds apart.
+ * A cycle opens with whichever half arrives first and completes when the
+ * other half arrives within the timeout. Repeats of the half already seen
+ * don't complete it. Cycles are kept per station so two stations can't pair
+ * with each other.
+ *
+ * Each half says whether it was kept, i.e. changed something worth writing.
+ * A cycle is only worth writing if one of its own halves was kept, so one
+ * station's kept reading can't release another station's cycle.
+ *
+ * MessagePairer is not safe for concurrent use; callers hold their own lock.
+ */
+type MessageHalf int
+
+const (
+	HALF_TEMP_HUMIDITY MessageHalf = iota
+	HALF_WIND_RAIN
+)
+
+type pairCycle struct {
+	opened time.Time
+	seen   [2]bool
+	kept   bool
+}
+
+type MessagePairer struct {
+	timeout time.Duration
+	cycles  map[StationKey]*pairCycle
+}
+
+func NewMessagePairer(timeout time.Duration) *MessagePairer {
+	return &MessagePairer{timeout: timeout, cycles: make(map[StationKey]*pairCycle)}
+}
+
+// Observe records half arriving from station at at. complete reports whether
+// it completed the station's cycle with at least one kept half. expired
+// reports whether it replaced a timed-out cycle with a kept half that Expire
+// hadn't closed yet; that cycle is owed a write of its own, made before this
+// half is applied.
+func (p *MessagePairer) Observe(station StationKey, half MessageHalf, kept bool, at time.Time) (expired, complete bool) {
+	cycle, ok := p.cycles[station]
+	if !ok || at.Sub(cycle.opened) > p.timeout {
+		expired = ok && cycle.kept
+		cycle = &pairCycle{opened: at}
+		p.cycles[station] = cycle
+	}
+
+	cycle.seen[half] = true
+	cycle.kept = cycle.kept || kept
+	if cycle.seen[HALF_TEMP_HUMIDITY] && cycle.seen[HALF_WIND_RAIN] {
+		delete(p.cycles, station)
+		return expired, cycle.kept
+	}
+
+	return expired, false
+}
+
+// Expire closes every cycle opened more than the timeout before now and
+// returns how many of them had a kept half
+func (p *MessagePairer) Expire(now time.Time) int {
+	expired := 0
+	for station, cycle := range p.cycles {
+		if now.Sub(cycle.opened) > p.timeout {
+			delete(p.cycles, station)
+			if cycle.kept {
+				expired++
+			}
+		}
+	}
+
+	return expired
+}
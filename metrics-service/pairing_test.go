@@ -0,0 +1,137 @@
+package weathermetrics
+
+import (
+	"testing"
+	"time"
+)
+
+var pairStart = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// completes is Observe's complete result
+func completes(p *MessagePairer, station StationKey, half MessageHalf, kept bool, at time.Time) bool {
+	_, complete := p.Observe(station, half, kept, at)
+	return complete
+}
+
+func TestMessagePairerCompletesOnBothHalves(t *testing.T) {
+	p := NewMessagePairer(30 * time.Second)
+	station := StationKey{ID: 1, Channel: "A"}
+
+	if completes(p, station, HALF_WIND_RAIN, true, pairStart) {
+		t.Fatal("completed on one half")
+	}
+	if completes(p, station, HALF_WIND_RAIN, true, pairStart.Add(time.Second)) {
+		t.Fatal("completed on a repeat of the same half")
+	}
+	if !completes(p, station, HALF_TEMP_HUMIDITY, true, pairStart.Add(18*time.Second)) {
+		t.Fatal("didn't complete on the other half")
+	}
+
+	// The next cycle starts from scratch
+	if completes(p, station, HALF_TEMP_HUMIDITY, true, pairStart.Add(36*time.Second)) {
+		t.Error("completed a new cycle on one half")
+	}
+}
+
+func TestMessagePairerKeepsStationsApart(t *testing.T) {
+	p := NewMessagePairer(30 * time.Second)
+	one := StationKey{ID: 1, Channel: "A"}
+	two := StationKey{ID: 2, Channel: "A"}
+
+	p.Observe(one, HALF_TEMP_HUMIDITY, true, pairStart)
+	if completes(p, two, HALF_WIND_RAIN, true, pairStart.Add(time.Second)) {
+		t.Error("halves from two stations paired")
+	}
+}
+
+func TestMessagePairerKeptIsPerStation(t *testing.T) {
+	p := NewMessagePairer(30 * time.Second)
+	one := StationKey{ID: 1, Channel: "A"}
+	two := StationKey{ID: 2, Channel: "A"}
+
+	// Station 1's kept half mustn't release station 2's cycle, where
+	// neither half was kept
+	p.Observe(one, HALF_TEMP_HUMIDITY, true, pairStart)
+	p.Observe(two, HALF_TEMP_HUMIDITY, false, pairStart.Add(time.Second))
+	if completes(p, two, HALF_WIND_RAIN, false, pairStart.Add(2*time.Second)) {
+		t.Error("station 2's cycle written with nothing kept")
+	}
+
+	// Either half being kept is enough
+	if !completes(p, one, HALF_WIND_RAIN, false, pairStart.Add(3*time.Second)) {
+		t.Error("station 1's cycle not written with its first half kept")
+	}
+}
+
+func TestMessagePairerTimeout(t *testing.T) {
+	p := NewMessagePairer(30 * time.Second)
+	station := StationKey{ID: 1, Channel: "A"}
+
+	// A partner arriving after the timeout opens a new cycle instead
+	p.Observe(station, HALF_TEMP_HUMIDITY, true, pairStart)
+	if completes(p, station, HALF_WIND_RAIN, true, pairStart.Add(31*time.Second)) {
+		t.Error("paired with a half older than the timeout")
+	}
+	if !completes(p, station, HALF_TEMP_HUMIDITY, true, pairStart.Add(40*time.Second)) {
+		t.Error("the new cycle didn't complete")
+	}
+}
+
+func TestMessagePairerObserveFlushesTimedOutCycle(t *testing.T) {
+	p := NewMessagePairer(30 * time.Second)
+	station := StationKey{ID: 1, Channel: "A"}
+
+	// No Expire between the kept half and its late partner, so Observe has
+	// to own up to the cycle it replaces
+	p.Observe(station, HALF_TEMP_HUMIDITY, true, pairStart)
+	expired, complete := p.Observe(station, HALF_WIND_RAIN, false, pairStart.Add(31*time.Second))
+	if !expired || complete {
+		t.Errorf("late partner: expired %v complete %v, want the kept cycle expired", expired, complete)
+	}
+
+	// The replaced cycle is gone, so Expire doesn't count it again
+	if n := p.Expire(pairStart.Add(40 * time.Second)); n != 0 {
+		t.Errorf("Expire = %d after Observe flushed the cycle, want 0", n)
+	}
+
+	// A timed-out cycle with nothing kept owes nothing
+	p.Observe(station, HALF_TEMP_HUMIDITY, false, pairStart.Add(2*time.Minute))
+	if expired, _ := p.Observe(station, HALF_TEMP_HUMIDITY, true, pairStart.Add(3*time.Minute)); expired {
+		t.Error("a cycle with nothing kept expired as owing a write")
+	}
+}
+
+func TestMessagePairerExpire(t *testing.T) {
+	p := NewMessagePairer(30 * time.Second)
+
+	p.Observe(StationKey{ID: 1, Channel: "A"}, HALF_TEMP_HUMIDITY, true, pairStart)
+	p.Observe(StationKey{ID: 2, Channel: "A"}, HALF_TEMP_HUMIDITY, false, pairStart)
+	p.Observe(StationKey{ID: 3, Channel: "A"}, HALF_WIND_RAIN, true, pairStart.Add(20*time.Second))
+
+	// Exactly at the timeout nothing has expired yet
+	if n := p.Expire(pairStart.Add(30 * time.Second)); n != 0 {
+		t.Errorf("Expire at the timeout = %d, want 0", n)
+	}
+
+	// Stations 1 and 2 expire, but only station 1 had anything kept
+	if n := p.Expire(pairStart.Add(31 * time.Second)); n != 1 {
+		t.Errorf("Expire = %d, want 1", n)
+	}
+	if n := p.Expire(pairStart.Add(31 * time.Second)); n != 0 {
+		t.Errorf("Expire again = %d, want 0", n)
+	}
+
+	// Station 3's cycle was still open and completes
+	if !completes(p, StationKey{ID: 3, Channel: "A"}, HALF_TEMP_HUMIDITY, false, pairStart.Add(35*time.Second)) {
+		t.Error("an open cycle was expired early")
+	}
+}
+
+func TestPairConfigValidate(t *testing.T) {
+	if err := (PairConfig{PairMessages: true}).Validate(); err == nil {
+		t.Error("PAIR_TIMEOUT of zero accepted")
+	}
+	if err := (PairConfig{PairTimeout: 0}).Validate(); err != nil {
+		t.Errorf("PAIR_TIMEOUT checked with pairing off: %s", err)
+	}
+}
@@ -0,0 +1,129 @@
+package weathermetrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// EmptyPayload reports whether payload is empty or only whitespace, as when
+// a retained message is cleared
+func EmptyPayload(payload []byte) bool {
+	return len(bytes.TrimSpace(payload)) == 0
+}
+
+// DecodePayload returns the rtl_433 events in an MQTT payload, with field
+// aliases applied. If unwrapKey is set, each event is first unwrapped from an
+// envelope such as {"topic":"...","payload":{...}}; a dotted key descends
+// into nested objects.
+func DecodePayload(payload []byte, unwrapKey string) ([]json.RawMessage, error) {
+	events, err := unwrapEvents(payload, unwrapKey)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range events {
+		if events[i], err = ApplyAliases(events[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return events, nil
+}
+
+func unwrapEvents(payload []byte, unwrapKey string) ([]json.RawMessage, error) {
+	events, err := SplitPayload(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if unwrapKey == "" {
+		return events, nil
+	}
+
+	unwrapped := []json.RawMessage{}
+	for _, event := range events {
+		inner, err := UnwrapPayload(event, unwrapKey)
+		if err != nil {
+			return nil, err
+		}
+
+		innerEvents, err := SplitPayload(inner)
+		if err != nil {
+			return nil, err
+		}
+		unwrapped = append(unwrapped, innerEvents...)
+	}
+
+	return unwrapped, nil
+}
+
+// SplitPayload returns the individual rtl_433 events in an MQTT payload. Most
+// bridges publish a single JSON object per message, but some batch several
+// events into a JSON array.
+func SplitPayload(payload []byte) ([]json.RawMessage, error) {
+	var event map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &event); err == nil {
+		return []json.RawMessage{payload}, nil
+	}
+
+	var events []json.RawMessage
+	if err := json.Unmarshal(payload, &events); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+func UnwrapPayload(payload []byte, key string) (json.RawMessage, error) {
+	current := json.RawMessage(payload)
+	for _, k := range strings.Split(key, ".") {
+		var envelope map[string]json.RawMessage
+		if err := json.Unmarshal(current, &envelope); err != nil {
+			return nil, fmt.Errorf("could not unwrap %q: %w", key, err)
+		}
+
+		inner, ok := envelope[k]
+		if !ok {
+			return nil, fmt.Errorf("could not unwrap %q: no %q key", key, k)
+		}
+		current = inner
+	}
+
+	return current, nil
+}
+
+// Fields that identify a reading's type when message_type is missing. The
+// 5n1 reports wind speed in both of its messages, so it doesn't count.
+var (
+	tempHumidityFields = []string{"temperature_F", "temperature_C", "humidity"}
+	windRainFields     = []string{"wind_dir_deg", "wind_dir", "rain_in"}
+)
+
+// InferMessageType guesses the message_type of an event that lacks one from
+// the fields present. It returns 0 if neither set of fields is there.
+func InferMessageType(event []byte) int {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(event, &fields); err != nil {
+		return 0
+	}
+
+	has := func(names []string) bool {
+		for _, name := range names {
+			if _, ok := fields[name]; ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch {
+	case has(tempHumidityFields):
+		return TEMP_HUMIDITY_MESSAGE
+	case has(windRainFields):
+		return WIND_RAIN_MESSAGE
+	}
+
+	return 0
+}
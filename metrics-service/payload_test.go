@@ -0,0 +1,149 @@
+package weathermetrics
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const (
+	tempHumidityPayload = `{"time":"2025-08-03 21:51:44","model":"Acurite-5n1","message_type":56,"id":1026,"channel":"C","battery_ok":1,"temperature_F":69.1,"humidity":97,"mic":"CHECKSUM"}`
+	windRainPayload     = `{"time":"2025-08-03 21:52:39","model":"Acurite-5n1","message_type":49,"id":1026,"channel":"C","battery_ok":1,"wind_avg_km_h":3.5,"wind_dir_deg":157.5,"rain_in":0.23,"mic":"CHECKSUM"}`
+)
+
+func TestSplitPayloadSingleObject(t *testing.T) {
+	events, err := SplitPayload([]byte(tempHumidityPayload))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 1 || string(events[0]) != tempHumidityPayload {
+		t.Errorf("SplitPayload(object) = %s", events)
+	}
+}
+
+func TestSplitPayloadBatchedArray(t *testing.T) {
+	events, err := SplitPayload([]byte("[" + tempHumidityPayload + "," + windRainPayload + "]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2", len(events))
+	}
+
+	var th TempHumidityMeasurement
+	if err := json.Unmarshal(events[0], &th); err != nil || th.Temp != 69.1 {
+		t.Errorf("first event = %+v, %v", th, err)
+	}
+
+	var wr WindRainMeasurement
+	if err := json.Unmarshal(events[1], &wr); err != nil || wr.RainInches != 0.23 {
+		t.Errorf("second event = %+v, %v", wr, err)
+	}
+}
+
+func TestSplitPayloadEmptyArray(t *testing.T) {
+	events, err := SplitPayload([]byte("[]"))
+	if err != nil || len(events) != 0 {
+		t.Errorf("SplitPayload([]) = %s, %v", events, err)
+	}
+}
+
+func TestSplitPayloadRejectsOtherJSON(t *testing.T) {
+	for _, payload := range []string{`"text"`, `42`, `{"unterminated":`} {
+		if _, err := SplitPayload([]byte(payload)); err == nil {
+			t.Errorf("SplitPayload(%s) accepted", payload)
+		}
+	}
+}
+
+func TestDecodePayloadUnwrapsEnvelope(t *testing.T) {
+	wrapped := `{"topic":"rtl_433/events","payload":` + tempHumidityPayload + `}`
+
+	events, err := DecodePayload([]byte(wrapped), "payload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("got %d events, want 1", len(events))
+	}
+
+	var th TempHumidityMeasurement
+	if err := json.Unmarshal(events[0], &th); err != nil {
+		t.Fatal(err)
+	}
+	if th.Temp != 69.1 || th.Humidity != 97 || th.ID != 1026 {
+		t.Errorf("unwrapped event = %+v", th)
+	}
+}
+
+func TestDecodePayloadUnwrapsDottedKey(t *testing.T) {
+	wrapped := `{"msg":{"data":[` + tempHumidityPayload + `,` + windRainPayload + `]}}`
+
+	events, err := DecodePayload([]byte(wrapped), "msg.data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Errorf("got %d events, want 2", len(events))
+	}
+}
+
+func TestDecodePayloadWithoutUnwrapKey(t *testing.T) {
+	wrapped := `{"topic":"rtl_433/events","payload":` + tempHumidityPayload + `}`
+
+	events, err := DecodePayload([]byte(wrapped), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// By default the envelope is the event, so nothing decodes from it
+	var th TempHumidityMeasurement
+	if err := json.Unmarshal(events[0], &th); err != nil {
+		t.Fatal(err)
+	}
+	if th.Temp != 0 || th.ID != 0 {
+		t.Errorf("envelope decoded as %+v without an unwrap key", th)
+	}
+}
+
+func TestUnwrapPayloadMissingKey(t *testing.T) {
+	_, err := UnwrapPayload([]byte(`{"topic":"x","data":{}}`), "payload")
+	if err == nil || err.Error() != `could not unwrap "payload": no "payload" key` {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestInferMessageType(t *testing.T) {
+	for _, tc := range []struct {
+		payload string
+		want    int
+	}{
+		{`{"model":"Acurite-5n1","id":1,"temperature_F":60,"humidity":50}`, TEMP_HUMIDITY_MESSAGE},
+		{`{"model":"Acurite-5n1","id":1,"temperature_C":15.5}`, TEMP_HUMIDITY_MESSAGE},
+		{`{"model":"Acurite-5n1","id":1,"wind_avg_km_h":10,"wind_dir_deg":90,"rain_in":0.5}`, WIND_RAIN_MESSAGE},
+		{`{"model":"Acurite-5n1","id":1,"rain_in":0.5}`, WIND_RAIN_MESSAGE},
+
+		// Wind speed comes with both halves, so it alone says nothing
+		{`{"model":"Acurite-5n1","id":1,"wind_avg_km_h":10}`, 0},
+		{`{"model":"Acurite-5n1","id":1}`, 0},
+		{`not json`, 0},
+	} {
+		if got := InferMessageType([]byte(tc.payload)); got != tc.want {
+			t.Errorf("InferMessageType(%s) = %d, want %d", tc.payload, got, tc.want)
+		}
+	}
+}
+
+func TestEmptyPayload(t *testing.T) {
+	for payload, want := range map[string]bool{
+		"":                  true,
+		" \n\t":             true,
+		"{}":                false,
+		tempHumidityPayload: false,
+	} {
+		if got := EmptyPayload([]byte(payload)); got != want {
+			t.Errorf("EmptyPayload(%q) = %v, want %v", payload, got, want)
+		}
+	}
+}
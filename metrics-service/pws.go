@@ -0,0 +1,35 @@
+package weathermetrics
+
+import (
+	"math"
+	"strconv"
+)
+
+/*
+ * PWS formatting
+ *
+ * Wunderground expects particular precision per field. Values are rounded
+ * half away from zero before formatting; fields not listed get two decimals.
+ */
+var PWSPrecision = map[string]int{
+	"tempf":        1,
+	"humidity":     0,
+	"dewptf":       1,
+	"baromin":      2,
+	"windspeedmph": 1,
+	"windgustmph":  1,
+	"winddir":      0,
+	"dailyrainin":  2,
+}
+
+func FormatPWSValue(field string, v float32) string {
+	precision, ok := PWSPrecision[field]
+	if !ok {
+		precision = 2
+	}
+
+	scale := math.Pow10(precision)
+	rounded := math.Round(float64(v)*scale) / scale
+
+	return strconv.FormatFloat(rounded, 'f', precision, 64)
+}
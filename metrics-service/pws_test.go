@@ -0,0 +1,29 @@
+package weathermetrics
+
+import "testing"
+
+func TestFormatPWSValue(t *testing.T) {
+	for _, tc := range []struct {
+		field string
+		v     float32
+		want  string
+	}{
+		{field: "tempf", v: 69.14, want: "69.1"},
+		{field: "tempf", v: 69.15, want: "69.2"},
+		{field: "tempf", v: -3.25, want: "-3.3"},
+		{field: "humidity", v: 97, want: "97"},
+		{field: "humidity", v: 96.5, want: "97"},
+		{field: "dewptf", v: 55.04, want: "55.0"},
+		{field: "baromin", v: 29.9213, want: "29.92"},
+		{field: "windspeedmph", v: 7.46, want: "7.5"},
+		{field: "windgustmph", v: 12, want: "12.0"},
+		{field: "winddir", v: 157.5, want: "158"},
+		{field: "dailyrainin", v: 0.125, want: "0.13"},
+		{field: "dailyrainin", v: 0, want: "0.00"},
+		{field: "solarradiation", v: 1.234, want: "1.23"},
+	} {
+		if got := FormatPWSValue(tc.field, tc.v); got != tc.want {
+			t.Errorf("FormatPWSValue(%s, %v) = %q, want %q", tc.field, tc.v, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package weathermetrics
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+/*
+ * Daily rain
+ *
+ * rain_in is a cumulative counter since the sensor powered up. The
+ * accumulator turns it into rain since the day started by remembering the
+ * counter's value at that point. The day starts at local midnight unless a
+ * reset time is given, e.g. 09:00 for the CoCoRaHS observation day.
+ *
+ * Drops smaller than the deadband are sensor quantization and hold the
+ * previous value; anything larger is a counter reset (battery change) and
+ * re-baselines so the day's total carries on from where it was.
+ *
+ * The very first reading can only baseline the day from wherever the counter
+ * is at startup, which misses any rain earlier in the day. Baselined reports
+ * whether the baseline has since been taken at a day's start, or restored.
+ *
+ * RainAccumulator is not safe for concurrent use; callers hold their own lock.
+ */
+type RainAccumulator struct {
+	loc      *time.Location
+	deadband float32
+	reset    time.Duration
+
+	initialized bool
+	baselined   bool
+	date        string
+	baseline    float32
+	last        float32
+}
+
+const rainDateFormat = "2006-01-02"
+
+// NewRainAccumulator starts each day reset after local midnight
+func NewRainAccumulator(loc *time.Location, deadband float32, reset time.Duration) *RainAccumulator {
+	return &RainAccumulator{loc: loc, deadband: deadband, reset: reset}
+}
+
+// ParseResetTime parses a local time of day such as "09:00" into the offset
+// from midnight NewRainAccumulator takes
+func ParseResetTime(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("reset time must be HH:MM, got %q", s)
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// day names the rain day at falls in: the local date on which it started.
+// It works on the wall clock so DST changes don't move the boundary.
+func (r *RainAccumulator) day(at time.Time) string {
+	local := at.In(r.loc)
+	sinceMidnight := time.Duration(local.Hour())*time.Hour +
+		time.Duration(local.Minute())*time.Minute +
+		time.Duration(local.Second())*time.Second
+
+	if sinceMidnight < r.reset {
+		local = local.AddDate(0, 0, -1)
+	}
+
+	return local.Format(rainDateFormat)
+}
+
+// DayStart is when the rain day at falls in started
+func (r *RainAccumulator) DayStart(at time.Time) time.Time {
+	date, _ := time.ParseInLocation(rainDateFormat, r.day(at), r.loc)
+	return time.Date(date.Year(), date.Month(), date.Day(),
+		int(r.reset/time.Hour), int(r.reset%time.Hour/time.Minute), 0, 0, r.loc)
+}
+
+// Observe records a rain counter reading taken at at and returns the total
+// since the day started
+func (r *RainAccumulator) Observe(rain float32, at time.Time) float32 {
+	date := r.day(at)
+
+	if !r.initialized {
+		r.initialized = true
+		r.date = date
+		r.baseline = rain
+		r.last = rain
+		return 0
+	}
+
+	if rain < r.last {
+		if r.last-rain < r.deadband {
+			rain = r.last
+		} else {
+			log.Printf("rain counter reset from %0.2f to %0.2f, re-baselining", r.last, rain)
+			r.baseline = rain - (r.last - r.baseline)
+		}
+	}
+
+	if date != r.date {
+		r.date = date
+		r.baseline = rain
+		r.baselined = true
+	}
+
+	r.last = rain
+	return rain - r.baseline
+}
+
+// Baselined is false until the day's baseline is known to be its start
+// rather than the first reading after startup
+func (r *RainAccumulator) Baselined() bool {
+	return r.baselined
+}
+
+// Daily is the total since the day started as of now. It is zero before the
+// first reading and on a new day until a reading arrives.
+func (r *RainAccumulator) Daily(now time.Time) float32 {
+	if !r.initialized || r.day(now) != r.date {
+		return 0
+	}
+
+	return r.last - r.baseline
+}
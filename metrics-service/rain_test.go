@@ -0,0 +1,134 @@
+package weathermetrics
+
+import (
+	"testing"
+	"time"
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestRainDeadbandHoldsJitter(t *testing.T) {
+	loc := mustLoadLocation(t, "America/New_York")
+	at := time.Date(2024, 6, 1, 12, 0, 0, 0, loc)
+	rain := NewRainAccumulator(loc, 0.02, 0)
+
+	rain.Observe(1.00, at)
+	rain.Observe(1.10, at.Add(time.Minute))
+
+	if got := rain.Observe(1.09, at.Add(2*time.Minute)); !approxEqual(got, 0.10) {
+		t.Errorf("after jitter down: %v, want the held 0.10", got)
+	}
+	// Re-baselining on the jitter would make this 0.11
+	if got := rain.Observe(1.10, at.Add(3*time.Minute)); !approxEqual(got, 0.10) {
+		t.Errorf("after jitter back up: %v, want 0.10", got)
+	}
+	if got := rain.Observe(1.12, at.Add(4*time.Minute)); !approxEqual(got, 0.12) {
+		t.Errorf("after real rain: %v, want 0.12", got)
+	}
+}
+
+func TestRainCounterResetCarriesOn(t *testing.T) {
+	loc := mustLoadLocation(t, "America/New_York")
+	at := time.Date(2024, 6, 1, 12, 0, 0, 0, loc)
+	rain := NewRainAccumulator(loc, 0.02, 0)
+
+	rain.Observe(5.00, at)
+	rain.Observe(5.30, at.Add(time.Minute))
+
+	// Battery change: the counter starts again from zero
+	if got := rain.Observe(0, at.Add(2*time.Minute)); !approxEqual(got, 0.30) {
+		t.Errorf("after counter reset: %v, want 0.30 carried over", got)
+	}
+	if got := rain.Observe(0.05, at.Add(3*time.Minute)); !approxEqual(got, 0.35) {
+		t.Errorf("rain after reset: %v, want 0.35", got)
+	}
+}
+
+func TestRainStartsOverEachDay(t *testing.T) {
+	loc := mustLoadLocation(t, "America/New_York")
+	rain := NewRainAccumulator(loc, 0.02, 0)
+
+	evening := time.Date(2024, 6, 1, 22, 0, 0, 0, loc)
+	rain.Observe(2.00, evening)
+	if got := rain.Observe(2.40, evening.Add(time.Hour)); !approxEqual(got, 0.40) {
+		t.Errorf("first day total %v, want 0.40", got)
+	}
+	if rain.Baselined() {
+		t.Error("baselined from a mid-day start")
+	}
+
+	// Past midnight and before the next reading there is no rain yet today
+	morning := time.Date(2024, 6, 2, 0, 30, 0, 0, loc)
+	if got := rain.Daily(morning); got != 0 {
+		t.Errorf("Daily on a new day before a reading = %v, want 0", got)
+	}
+
+	if got := rain.Observe(2.40, morning); got != 0 {
+		t.Errorf("first reading of the day = %v, want 0", got)
+	}
+	if got := rain.Observe(2.55, morning.Add(time.Hour)); !approxEqual(got, 0.15) {
+		t.Errorf("second day total %v, want 0.15", got)
+	}
+	if !rain.Baselined() {
+		t.Error("not baselined after a day boundary")
+	}
+	if got := rain.Daily(morning.Add(2 * time.Hour)); !approxEqual(got, 0.15) {
+		t.Errorf("Daily = %v, want 0.15", got)
+	}
+}
+
+func TestRainResetTime(t *testing.T) {
+	loc := mustLoadLocation(t, "America/New_York")
+	reset, err := ParseResetTime("09:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rain := NewRainAccumulator(loc, 0.02, reset)
+
+	// Midnight is not a boundary for a 09:00 rain day
+	rain.Observe(1.00, time.Date(2024, 6, 1, 20, 0, 0, 0, loc))
+	if got := rain.Observe(1.30, time.Date(2024, 6, 2, 8, 59, 0, 0, loc)); !approxEqual(got, 0.30) {
+		t.Errorf("total before 09:00 = %v, want 0.30", got)
+	}
+	if got := rain.Observe(1.30, time.Date(2024, 6, 2, 9, 0, 0, 0, loc)); got != 0 {
+		t.Errorf("total at 09:00 = %v, want 0", got)
+	}
+}
+
+func TestParseResetTime(t *testing.T) {
+	if got, err := ParseResetTime("07:30"); err != nil || got != 7*time.Hour+30*time.Minute {
+		t.Errorf("ParseResetTime(07:30) = %s, %v", got, err)
+	}
+
+	for _, s := range []string{"", "9am", "25:00", "07:30:00"} {
+		if _, err := ParseResetTime(s); err == nil {
+			t.Errorf("ParseResetTime(%q) accepted", s)
+		}
+	}
+}
+
+func TestRainDayStart(t *testing.T) {
+	loc := mustLoadLocation(t, "America/New_York")
+	rain := NewRainAccumulator(loc, 0.02, 9*time.Hour)
+
+	for at, want := range map[time.Time]time.Time{
+		time.Date(2024, 6, 1, 8, 59, 0, 0, loc): time.Date(2024, 5, 31, 9, 0, 0, 0, loc),
+		time.Date(2024, 6, 1, 9, 0, 0, 0, loc):  time.Date(2024, 6, 1, 9, 0, 0, 0, loc),
+		time.Date(2024, 6, 1, 23, 0, 0, 0, loc): time.Date(2024, 6, 1, 9, 0, 0, 0, loc),
+
+		// The day after spring forward still starts at 09:00 local
+		time.Date(2024, 3, 10, 12, 0, 0, 0, loc): time.Date(2024, 3, 10, 9, 0, 0, 0, loc),
+	} {
+		if got := rain.DayStart(at); !got.Equal(want) {
+			t.Errorf("DayStart(%s) = %s, want %s", at, got, want)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package weathermetrics
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+/*
+ * Config
+ */
+type RemoteWriteConfig struct {
+	URL      string        `envconfig:"REMOTE_WRITE_URL"`
+	Username string        `envconfig:"REMOTE_WRITE_USERNAME"`
+	Password string        `envconfig:"REMOTE_WRITE_PASSWORD" secret:"true"`
+	Interval time.Duration `envconfig:"REMOTE_WRITE_INTERVAL" default:"30s"`
+	MaxQueue int           `envconfig:"REMOTE_WRITE_MAX_QUEUE" default:"1000"`
+	Retries  int           `envconfig:"REMOTE_WRITE_RETRIES" default:"3"`
+}
+
+func (c RemoteWriteConfig) Enabled() bool {
+	return c.URL != ""
+}
+
+func (c RemoteWriteConfig) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+
+	if c.Interval <= 0 {
+		return fmt.Errorf("REMOTE_WRITE_INTERVAL must be positive, got %s", c.Interval)
+	}
+
+	if c.MaxQueue < 1 {
+		return fmt.Errorf("REMOTE_WRITE_MAX_QUEUE must be at least 1, got %d", c.MaxQueue)
+	}
+
+	if c.Retries < 0 {
+		return fmt.Errorf("REMOTE_WRITE_RETRIES must not be negative, got %d", c.Retries)
+	}
+
+	return nil
+}
+
+/*
+ * Remote write sink
+ *
+ * Samples are queued on Write and POSTed in batches every Interval as a
+ * snappy compressed prometheus.WriteRequest. The queue holds at most MaxQueue
+ * samples; when the endpoint falls behind the oldest samples are dropped.
+ * Failed batches are retried with backoff and then put back on the queue;
+ * batches the endpoint rejects outright (4xx other than 429) are dropped.
+ */
+type remoteSample struct {
+	name      string
+	value     float64
+	timestamp int64
+}
+
+type RemoteWriteSink struct {
+	conf    RemoteWriteConfig
+	clock   Clock
+	client  *http.Client
+	m       sync.Mutex
+	queue   []remoteSample
+	dropped int64
+}
+
+func NewRemoteWriteSink(conf RemoteWriteConfig, clock Clock, userAgent string) *RemoteWriteSink {
+	return &RemoteWriteSink{
+		conf:   conf,
+		clock:  clock,
+		client: NewHTTPClient(userAgent, 10*time.Second),
+	}
+}
+
+func (s *RemoteWriteSink) Write(c CurrentConditions, at time.Time) {
+	samples := []remoteSample{}
+	for name, value := range conditionSamples(c) {
+		samples = append(samples, remoteSample{name: name, value: value, timestamp: at.UnixMilli()})
+	}
+
+	s.enqueue(samples)
+}
+
+func (s *RemoteWriteSink) enqueue(samples []remoteSample) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.queue = append(s.queue, samples...)
+	if over := len(s.queue) - s.conf.MaxQueue; over > 0 {
+		s.queue = s.queue[over:]
+		s.dropped += int64(over)
+	}
+}
+
+// Dropped counts samples discarded because the queue was full or the
+// endpoint rejected them
+func (s *RemoteWriteSink) Dropped() int64 {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.dropped
+}
+
+// Run flushes the queue every Interval until stop is closed
+func (s *RemoteWriteSink) Run(stop <-chan struct{}) {
+	ticker := s.clock.NewTicker(s.conf.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			s.flush()
+		case <-stop:
+			s.flush()
+			return
+		}
+	}
+}
+
+func (s *RemoteWriteSink) flush() {
+	s.m.Lock()
+	batch := s.queue
+	s.queue = nil
+	s.m.Unlock()
+
+	if len(batch) == 0 {
+		return
+	}
+
+	body := snappy.Encode(nil, encodeWriteRequest(batch))
+
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		retry, err := s.post(body)
+		if err == nil {
+			return
+		}
+
+		if !retry {
+			log.Printf("remote_write: dropping %d samples: %s", len(batch), err)
+			s.m.Lock()
+			s.dropped += int64(len(batch))
+			s.m.Unlock()
+			return
+		}
+
+		if attempt >= s.conf.Retries {
+			log.Printf("remote_write: requeueing %d samples: %s", len(batch), err)
+			s.requeue(batch)
+			return
+		}
+
+		log.Printf("remote_write: %s, retrying in %s", err, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// requeue puts a failed batch back ahead of anything queued since
+func (s *RemoteWriteSink) requeue(batch []remoteSample) {
+	s.m.Lock()
+	queued := s.queue
+	s.queue = batch
+	s.m.Unlock()
+
+	s.enqueue(queued)
+}
+
+// post sends one batch and reports whether a failure is worth retrying
+func (s *RemoteWriteSink) post(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, s.conf.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if s.conf.Username != "" {
+		req.SetBasicAuth(s.conf.Username, s.conf.Password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+
+	// Per the remote_write spec only 5xx and 429 are retryable
+	retry := resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("%s returned %s", s.conf.URL, resp.Status)
+}
+
+/*
+ * Protobuf encoding
+ *
+ * Hand-rolled encoding of the small subset of prometheus.WriteRequest we need:
+ *
+ *   WriteRequest { repeated TimeSeries timeseries = 1; }
+ *   TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+ *   Label        { string name = 1; string value = 2; }
+ *   Sample       { double value = 1; int64 timestamp = 2; }
+ */
+func encodeWriteRequest(samples []remoteSample) []byte {
+	series := map[string][]remoteSample{}
+	for _, sample := range samples {
+		series[sample.name] = append(series[sample.name], sample)
+	}
+
+	names := make([]string, 0, len(series))
+	for name := range series {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var req []byte
+	for _, name := range names {
+		var ts []byte
+
+		var label []byte
+		label = protowire.AppendTag(label, 1, protowire.BytesType)
+		label = protowire.AppendString(label, "__name__")
+		label = protowire.AppendTag(label, 2, protowire.BytesType)
+		label = protowire.AppendString(label, name)
+		ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, label)
+
+		for _, sample := range series[name] {
+			var encoded []byte
+			encoded = protowire.AppendTag(encoded, 1, protowire.Fixed64Type)
+			encoded = protowire.AppendFixed64(encoded, math.Float64bits(sample.value))
+			encoded = protowire.AppendTag(encoded, 2, protowire.VarintType)
+			encoded = protowire.AppendVarint(encoded, uint64(sample.timestamp))
+			ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, encoded)
+		}
+
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, ts)
+	}
+
+	return req
+}
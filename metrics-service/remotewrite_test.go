@@ -0,0 +1,311 @@
+package weathermetrics
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// decodedSeries is one TimeSeries of a decoded WriteRequest
+type decodedSeries struct {
+	labels  map[string]string
+	samples []remoteSample
+}
+
+// decodeWriteRequest parses encodeWriteRequest's output back without going
+// through the encoder's code, so the wire format itself is checked
+func decodeWriteRequest(t *testing.T, b []byte) []decodedSeries {
+	t.Helper()
+
+	var series []decodedSeries
+	for _, ts := range decodeFields(t, b, 1) {
+		s := decodedSeries{labels: map[string]string{}}
+
+		for _, field := range decodeMessage(t, ts) {
+			switch field.num {
+			case 1:
+				label := map[protowire.Number][]byte{}
+				for _, f := range decodeMessage(t, field.bytes) {
+					label[f.num] = f.bytes
+				}
+				s.labels[string(label[1])] = string(label[2])
+			case 2:
+				var sample remoteSample
+				for _, f := range decodeMessage(t, field.bytes) {
+					switch f.num {
+					case 1:
+						sample.value = math.Float64frombits(f.fixed64)
+					case 2:
+						sample.timestamp = int64(f.varint)
+					}
+				}
+				s.samples = append(s.samples, sample)
+			default:
+				t.Errorf("unexpected TimeSeries field %d", field.num)
+			}
+		}
+
+		series = append(series, s)
+	}
+
+	return series
+}
+
+type decodedField struct {
+	num     protowire.Number
+	bytes   []byte
+	fixed64 uint64
+	varint  uint64
+}
+
+func decodeMessage(t *testing.T, b []byte) []decodedField {
+	t.Helper()
+
+	var fields []decodedField
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatalf("bad tag: %s", protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		field := decodedField{num: num}
+		switch typ {
+		case protowire.BytesType:
+			field.bytes, n = protowire.ConsumeBytes(b)
+		case protowire.Fixed64Type:
+			field.fixed64, n = protowire.ConsumeFixed64(b)
+		case protowire.VarintType:
+			field.varint, n = protowire.ConsumeVarint(b)
+		default:
+			t.Fatalf("unexpected wire type %d for field %d", typ, num)
+		}
+		if n < 0 {
+			t.Fatalf("bad field %d: %s", num, protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		fields = append(fields, field)
+	}
+
+	return fields
+}
+
+// decodeFields returns the bytes of every num field, failing on any other
+func decodeFields(t *testing.T, b []byte, num protowire.Number) [][]byte {
+	t.Helper()
+
+	var out [][]byte
+	for _, field := range decodeMessage(t, b) {
+		if field.num != num {
+			t.Fatalf("unexpected field %d", field.num)
+		}
+		out = append(out, field.bytes)
+	}
+	return out
+}
+
+func TestEncodeWriteRequest(t *testing.T) {
+	series := decodeWriteRequest(t, encodeWriteRequest([]remoteSample{
+		{name: "temperature", value: 72.5, timestamp: 1717257600000},
+		{name: "humidity", value: 40, timestamp: 1717257600000},
+		{name: "temperature", value: -3.25, timestamp: 1717257630000},
+	}))
+
+	if len(series) != 2 {
+		t.Fatalf("got %d series, want 2", len(series))
+	}
+
+	// Series are sorted by name
+	if len(series[0].labels) != 1 || series[0].labels["__name__"] != "humidity" {
+		t.Errorf("first series labels %v, want humidity", series[0].labels)
+	}
+	if series[1].labels["__name__"] != "temperature" {
+		t.Errorf("second series labels %v, want temperature", series[1].labels)
+	}
+
+	want := []remoteSample{
+		{value: 72.5, timestamp: 1717257600000},
+		{value: -3.25, timestamp: 1717257630000},
+	}
+	if len(series[1].samples) != len(want) {
+		t.Fatalf("temperature samples %v, want %v", series[1].samples, want)
+	}
+	for i := range want {
+		if series[1].samples[i] != want[i] {
+			t.Errorf("temperature sample %d = %+v, want %+v", i, series[1].samples[i], want[i])
+		}
+	}
+}
+
+// remoteWriteServer stands in for the endpoint, answering with each status
+// in turn and then 204
+type remoteWriteServer struct {
+	*httptest.Server
+
+	m        sync.Mutex
+	statuses []int
+	bodies   [][]byte
+}
+
+func newRemoteWriteServer(t *testing.T, statuses ...int) *remoteWriteServer {
+	t.Helper()
+
+	s := &remoteWriteServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "snappy" {
+			t.Errorf("Content-Encoding %q, want snappy", r.Header.Get("Content-Encoding"))
+		}
+
+		compressed, _ := io.ReadAll(r.Body)
+		body, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			t.Errorf("body isn't snappy: %s", err)
+		}
+
+		s.m.Lock()
+		defer s.m.Unlock()
+		s.bodies = append(s.bodies, body)
+
+		status := http.StatusNoContent
+		if len(s.statuses) > 0 {
+			status, s.statuses = s.statuses[0], s.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *remoteWriteServer) Bodies() [][]byte {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return append([][]byte(nil), s.bodies...)
+}
+
+func newTestRemoteWriteSink(url string, clock Clock) *RemoteWriteSink {
+	return NewRemoteWriteSink(RemoteWriteConfig{
+		URL:      url,
+		Interval: 30 * time.Second,
+		MaxQueue: 100,
+		Retries:  1,
+	}, clock, "test")
+}
+
+var remoteWriteAt = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func TestRemoteWriteRetriesServerErrors(t *testing.T) {
+	server := newRemoteWriteServer(t, http.StatusServiceUnavailable)
+	sink := newTestRemoteWriteSink(server.URL, NewFakeClock(remoteWriteAt))
+
+	sink.Write(CurrentConditions{Temp: 72.5}, remoteWriteAt)
+	sink.flush()
+
+	bodies := server.Bodies()
+	if len(bodies) != 2 {
+		t.Fatalf("got %d POSTs, want the 503 retried once", len(bodies))
+	}
+
+	var temperature []remoteSample
+	for _, s := range decodeWriteRequest(t, bodies[1]) {
+		if s.labels["__name__"] == "temperature" {
+			temperature = s.samples
+		}
+	}
+	if len(temperature) != 1 || temperature[0].value != 72.5 || temperature[0].timestamp != remoteWriteAt.UnixMilli() {
+		t.Errorf("temperature samples %+v, want 72.5 at %d", temperature, remoteWriteAt.UnixMilli())
+	}
+
+	if n := sink.Dropped(); n != 0 {
+		t.Errorf("Dropped() = %d after a successful retry", n)
+	}
+}
+
+func TestRemoteWriteDropsClientErrors(t *testing.T) {
+	server := newRemoteWriteServer(t, http.StatusBadRequest)
+	sink := newTestRemoteWriteSink(server.URL, NewFakeClock(remoteWriteAt))
+
+	sink.Write(CurrentConditions{Temp: 72.5}, remoteWriteAt)
+	queued := len(sink.queue)
+	sink.flush()
+
+	if n := len(server.Bodies()); n != 1 {
+		t.Errorf("got %d POSTs, want the 400 not retried", n)
+	}
+	if n := sink.Dropped(); n != int64(queued) {
+		t.Errorf("Dropped() = %d, want %d", n, queued)
+	}
+	if len(sink.queue) != 0 {
+		t.Errorf("%d samples requeued after a 400", len(sink.queue))
+	}
+}
+
+func TestRemoteWriteRequeuesAfterRetries(t *testing.T) {
+	server := newRemoteWriteServer(t, http.StatusInternalServerError, http.StatusInternalServerError)
+	sink := newTestRemoteWriteSink(server.URL, NewFakeClock(remoteWriteAt))
+
+	sink.Write(CurrentConditions{Temp: 72.5}, remoteWriteAt)
+	queued := len(sink.queue)
+	sink.flush()
+
+	if len(sink.queue) != queued {
+		t.Errorf("%d samples queued after giving up, want %d back", len(sink.queue), queued)
+	}
+
+	sink.flush()
+	if n := len(server.Bodies()); n != 3 {
+		t.Errorf("got %d POSTs, want 3", n)
+	}
+	if len(sink.queue) != 0 || sink.Dropped() != 0 {
+		t.Errorf("queue %d, dropped %d after the endpoint recovered", len(sink.queue), sink.Dropped())
+	}
+}
+
+func TestRemoteWriteQueueDropsOldest(t *testing.T) {
+	sink := newTestRemoteWriteSink("http://unused", NewFakeClock(remoteWriteAt))
+	sink.conf.MaxQueue = 3
+
+	sink.enqueue([]remoteSample{{name: "a"}, {name: "b"}})
+	sink.enqueue([]remoteSample{{name: "c"}, {name: "d"}})
+
+	if len(sink.queue) != 3 || sink.queue[0].name != "b" {
+		t.Errorf("queue %+v, want b, c, d", sink.queue)
+	}
+	if n := sink.Dropped(); n != 1 {
+		t.Errorf("Dropped() = %d, want 1", n)
+	}
+}
+
+func TestRemoteWriteRunFlushesOnStop(t *testing.T) {
+	server := newRemoteWriteServer(t)
+	clock := NewFakeClock(remoteWriteAt)
+	sink := newTestRemoteWriteSink(server.URL, clock)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		sink.Run(stop)
+		close(done)
+	}()
+
+	sink.Write(CurrentConditions{Temp: 72.5}, remoteWriteAt)
+	close(stop)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run didn't return after stop")
+	}
+
+	if n := len(server.Bodies()); n != 1 {
+		t.Errorf("got %d POSTs, want the queue flushed on stop", n)
+	}
+}
@@ -0,0 +1,78 @@
+package weathermetrics
+
+import (
+	"fmt"
+	"time"
+)
+
+/*
+ * Config
+ *
+ * rtl_433's sequence_num counts up and wraps at SEQUENCE_MODULUS. On the
+ * Acurite 5n1 it is the repeat index, 0-2, of a transmission sent three
+ * times, so the default of 3 counts missed repeats. Zero disables gap
+ * tracking.
+ */
+type SequenceConfig struct {
+	SequenceModulus int           `envconfig:"SEQUENCE_MODULUS" default:"3"`
+	SequenceWindow  time.Duration `envconfig:"SEQUENCE_WINDOW" default:"1h"`
+}
+
+func (c SequenceConfig) Validate() error {
+	if c.SequenceModulus < 0 {
+		return fmt.Errorf("SEQUENCE_MODULUS must not be negative, got %d", c.SequenceModulus)
+	}
+
+	if c.SequenceModulus > 0 && c.SequenceWindow <= 0 {
+		return fmt.Errorf("SEQUENCE_WINDOW must be positive, got %s", c.SequenceWindow)
+	}
+
+	return nil
+}
+
+/*
+ * Sequence gaps
+ *
+ * Each message's sequence number is compared with the previous one from the
+ * same station; any numbers skipped in between, modulo the wrap, were missed.
+ * A repeated number counts as no gap, since it can't be told apart from a
+ * whole cycle going missing. Reception is received / (received + missed)
+ * over the window.
+ */
+type SequenceStats struct {
+	Gaps             int64   `json:"gaps"`
+	ReceptionPercent float64 `json:"reception_percent"`
+}
+
+type sequenceState struct {
+	last int
+
+	// missed holds, per received message, how many were missed before it
+	missed *TimeWindowBuffer[int]
+}
+
+// sequenceGap is how many numbers were skipped going from last to next
+func sequenceGap(last, next, modulus int) int {
+	if next == last {
+		return 0
+	}
+
+	return ((next-last-1)%modulus + modulus) % modulus
+}
+
+func (s *sequenceState) observe(seq int, at time.Time, modulus int) int {
+	gap := sequenceGap(s.last, seq, modulus)
+	s.last = seq
+	s.missed.Add(at, gap)
+	return gap
+}
+
+func (s *sequenceState) receptionPercent() float64 {
+	received, missed := 0, 0
+	for _, sample := range s.missed.Samples() {
+		received++
+		missed += sample.Value
+	}
+
+	return 100 * float64(received) / float64(received+missed)
+}
@@ -0,0 +1,70 @@
+package weathermetrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSequenceGap(t *testing.T) {
+	for _, tc := range []struct {
+		last, next, modulus int
+		want                int
+	}{
+		{last: 0, next: 1, modulus: 3, want: 0},
+		{last: 0, next: 2, modulus: 3, want: 1},
+		{last: 2, next: 0, modulus: 3, want: 0},
+		{last: 2, next: 1, modulus: 3, want: 1},
+		{last: 1, next: 1, modulus: 3, want: 0},
+		{last: 250, next: 3, modulus: 256, want: 8},
+		{last: 255, next: 0, modulus: 256, want: 0},
+	} {
+		if got := sequenceGap(tc.last, tc.next, tc.modulus); got != tc.want {
+			t.Errorf("sequenceGap(%d, %d, %d) = %d, want %d", tc.last, tc.next, tc.modulus, got, tc.want)
+		}
+	}
+}
+
+func TestObserveSequenceCountsGaps(t *testing.T) {
+	conf := SequenceConfig{SequenceModulus: 16, SequenceWindow: time.Hour}
+	key := StationKey{ID: 1, Channel: "A"}
+	at := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tracker := NewStationTracker(10)
+	observe := func(offset time.Duration, seq int) *SequenceStats {
+		tracker.Update(key, SYNTHETIC_MODEL, at.Add(offset), func(*CurrentConditions) {})
+		tracker.ObserveSequence(key, seq, at.Add(offset), conf)
+		return tracker.Stations()[0].Sequence
+	}
+
+	// 14, 15, 0 wraps cleanly; 3 misses 1 and 2; 7 misses 4, 5 and 6
+	for i, seq := range []int{14, 15, 0, 3} {
+		observe(time.Duration(i)*time.Minute, seq)
+	}
+	stats := observe(4*time.Minute, 7)
+	if stats.Gaps != 5 {
+		t.Errorf("gaps = %d, want 5", stats.Gaps)
+	}
+	if stats.ReceptionPercent != 50 {
+		t.Errorf("reception = %v%%, want 50", stats.ReceptionPercent)
+	}
+
+	// The gaps age out of the reception window but stay in the total
+	stats = observe(2*time.Hour, 8)
+	if stats.Gaps != 5 || stats.ReceptionPercent != 100 {
+		t.Errorf("after the window got %+v, want 5 gaps at 100%%", stats)
+	}
+}
+
+func TestObserveSequenceDisabled(t *testing.T) {
+	key := StationKey{ID: 1, Channel: "A"}
+	at := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tracker := NewStationTracker(10)
+	tracker.Update(key, SYNTHETIC_MODEL, at, func(*CurrentConditions) {})
+	tracker.ObserveSequence(key, 0, at, SequenceConfig{})
+	tracker.ObserveSequence(key, 2, at, SequenceConfig{})
+
+	if s := tracker.Stations()[0].Sequence; s != nil {
+		t.Errorf("sequence tracked with SEQUENCE_MODULUS=0: %+v", s)
+	}
+}
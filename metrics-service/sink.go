@@ -0,0 +1,35 @@
+package weathermetrics
+
+import "time"
+
+/*
+ * Sinks
+ *
+ * A Sink is handed the merged current conditions after every update. Write is
+ * called on the MQTT message path, so sinks that talk to the network must
+ * queue and return rather than block ingest.
+ */
+type Sink interface {
+	Write(c CurrentConditions, at time.Time)
+}
+
+// conditionSamples flattens c into the same series names /metrics uses
+func conditionSamples(c CurrentConditions) map[string]float64 {
+	samples := map[string]float64{
+		"temperature":    float64(c.Temp),
+		"humidity":       float64(c.Humidity),
+		"rain_in":        float64(c.RainInches),
+		"wind_direction": float64(c.WindDirection),
+		"wind_speed":     float64(c.WindSpeed),
+	}
+
+	if c.WindGust != nil {
+		samples["weather_wind_gust"] = float64(*c.WindGust)
+	}
+
+	if c.Held {
+		samples["weather_sample_held"] = 1
+	}
+
+	return samples
+}
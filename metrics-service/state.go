@@ -0,0 +1,153 @@
+package weathermetrics
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+/*
+ * Persisted state
+ *
+ * The accumulators behind daily rain, the daily high/low and the temperature
+ * trend only live in memory. SaveState writes them, with the latest
+ * conditions, so a restart can carry on where it left off. Each accumulator
+ * records the day or window it belongs to; anything that has since expired is
+ * discarded when it is restored rather than when it is saved.
+ */
+type RainState struct {
+	Date     string  `json:"date"`
+	Baseline float32 `json:"baseline"`
+	Last     float32 `json:"last"`
+}
+
+type ExtremesState struct {
+	Date string  `json:"date"`
+	Min  float32 `json:"min_F"`
+	Max  float32 `json:"max_F"`
+}
+
+type TrendSample struct {
+	At   time.Time `json:"at"`
+	Temp float64   `json:"temperature_F"`
+}
+
+type PersistedState struct {
+	SavedAt    time.Time         `json:"saved_at"`
+	Conditions CurrentConditions `json:"conditions"`
+	Rain       *RainState        `json:"rain,omitempty"`
+	Extremes   *ExtremesState    `json:"extremes,omitempty"`
+	Trend      []TrendSample     `json:"trend,omitempty"`
+}
+
+// SaveState writes state to path atomically, so a crash mid-write leaves the
+// previous file intact
+func SaveState(path string, state PersistedState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("could not save state: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not save state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not save state: %w", err)
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadState reads a file written by SaveState. A missing file is not an
+// error; it returns nil.
+func LoadState(path string) (*PersistedState, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not load state: %w", err)
+	}
+
+	var state PersistedState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("could not parse state file %s: %w", path, err)
+	}
+
+	return &state, nil
+}
+
+/*
+ * Accumulator snapshots
+ */
+
+func (r *RainAccumulator) State() *RainState {
+	if !r.initialized {
+		return nil
+	}
+
+	return &RainState{Date: r.date, Baseline: r.baseline, Last: r.last}
+}
+
+// Restore resumes today's baseline. A state from an earlier rain day is
+// ignored: its baseline isn't today's start, so the first reading after the
+// restart baselines as on a fresh start and Baselined stays false.
+func (r *RainAccumulator) Restore(s RainState, now time.Time) {
+	if s.Date != r.day(now) {
+		return
+	}
+
+	r.initialized = true
+	r.baselined = true
+	r.date = s.Date
+	r.baseline = s.Baseline
+	r.last = s.Last
+}
+
+func (e *DailyExtremes) State() *ExtremesState {
+	if e.date == "" {
+		return nil
+	}
+
+	return &ExtremesState{Date: e.date, Min: e.min, Max: e.max}
+}
+
+// Restore resumes today's high and low. A state from an earlier day is
+// ignored.
+func (e *DailyExtremes) Restore(s ExtremesState, now time.Time) {
+	if s.Date != now.In(e.loc).Format(rainDateFormat) {
+		return
+	}
+
+	e.date = s.Date
+	e.min = s.Min
+	e.max = s.Max
+}
+
+func (t *TemperatureTrend) State() []TrendSample {
+	samples := make([]TrendSample, t.samples.Len())
+	for i, s := range t.samples.Samples() {
+		samples[i] = TrendSample{At: s.At, Temp: s.Value}
+	}
+
+	return samples
+}
+
+// Restore resumes the window, dropping samples that have aged out of it
+func (t *TemperatureTrend) Restore(samples []TrendSample, now time.Time) {
+	t.samples.Reset()
+	for _, s := range samples {
+		t.samples.Add(s.At, s.Temp)
+	}
+	t.samples.Prune(now)
+}
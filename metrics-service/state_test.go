@@ -0,0 +1,136 @@
+package weathermetrics
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	at := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	saved := PersistedState{
+		SavedAt:    at,
+		Conditions: CurrentConditions{Temp: 70, Humidity: 50},
+		Rain:       &RainState{Date: "2024-06-01", Baseline: 1.5, Last: 1.75},
+		Extremes:   &ExtremesState{Date: "2024-06-01", Min: 58, Max: 75},
+		Trend:      []TrendSample{{At: at, Temp: 70}},
+	}
+	if err := SaveState(path, saved); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.SavedAt.Equal(at) || loaded.Conditions.Temp != 70 ||
+		*loaded.Rain != *saved.Rain || *loaded.Extremes != *saved.Extremes ||
+		len(loaded.Trend) != 1 || loaded.Trend[0].Temp != 70 {
+		t.Errorf("loaded %+v, want %+v", loaded, saved)
+	}
+}
+
+func TestLoadStateMissingFile(t *testing.T) {
+	state, err := LoadState(filepath.Join(t.TempDir(), "missing.json"))
+	if state != nil || err != nil {
+		t.Errorf("got %v, %v, want nil, nil", state, err)
+	}
+}
+
+func TestDailyExtremesResumeSameDay(t *testing.T) {
+	loc := mustLoadLocation(t, "America/New_York")
+	morning := time.Date(2024, 6, 1, 9, 0, 0, 0, loc)
+
+	before := NewDailyExtremes(loc)
+	before.Observe(60, morning)
+	before.Observe(75, morning.Add(time.Hour))
+
+	after := NewDailyExtremes(loc)
+	after.Restore(*before.State(), morning.Add(2*time.Hour))
+	after.Observe(65, morning.Add(3*time.Hour))
+
+	low, high, ok := after.Range(morning.Add(3 * time.Hour))
+	if !ok || low != 60 || high != 75 {
+		t.Errorf("range after restart = %v, %v, %v, want 60, 75", low, high, ok)
+	}
+}
+
+func TestDailyExtremesIgnoreYesterday(t *testing.T) {
+	loc := mustLoadLocation(t, "America/New_York")
+	evening := time.Date(2024, 6, 1, 22, 0, 0, 0, loc)
+
+	before := NewDailyExtremes(loc)
+	before.Observe(90, evening)
+
+	after := NewDailyExtremes(loc)
+	after.Restore(*before.State(), evening.Add(3*time.Hour))
+	if _, _, ok := after.Range(evening.Add(3 * time.Hour)); ok {
+		t.Error("yesterday's high restored into today")
+	}
+}
+
+func TestTemperatureTrendRestorePrunes(t *testing.T) {
+	trend := NewTemperatureTrend(TrendConfig{TrendWindow: time.Hour})
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	trend.Restore([]TrendSample{
+		{At: now.Add(-2 * time.Hour), Temp: 50},
+		{At: now.Add(-30 * time.Minute), Temp: 60},
+		{At: now.Add(-10 * time.Minute), Temp: 62},
+	}, now)
+
+	if got := trend.State(); len(got) != 2 || got[0].Temp != 60 {
+		t.Errorf("restored %v, want the two samples inside the window", got)
+	}
+}
+
+func TestRainResumeSameDay(t *testing.T) {
+	loc := mustLoadLocation(t, "America/New_York")
+	morning := time.Date(2024, 6, 1, 9, 0, 0, 0, loc)
+
+	rain := NewRainAccumulator(loc, 0.02, 0)
+	rain.Restore(RainState{Date: "2024-06-01", Baseline: 1.50, Last: 1.75}, morning)
+	if !rain.Baselined() {
+		t.Error("not baselined after restoring today's baseline")
+	}
+	if got := rain.Observe(1.80, morning.Add(time.Minute)); !approxEqual(got, 0.30) {
+		t.Errorf("total after restore = %v, want 0.30", got)
+	}
+}
+
+func TestRainIgnoresYesterdaysBaseline(t *testing.T) {
+	loc := mustLoadLocation(t, "America/New_York")
+	morning := time.Date(2024, 6, 2, 9, 0, 0, 0, loc)
+
+	rain := NewRainAccumulator(loc, 0.02, 0)
+	rain.Restore(RainState{Date: "2024-06-01", Baseline: 1.50, Last: 1.75}, morning)
+	if rain.Baselined() {
+		t.Error("baselined from yesterday's state")
+	}
+
+	// The first reading baselines as on a fresh start, so nothing from
+	// yesterday's baseline leaks into today
+	if got := rain.Observe(2.00, morning.Add(time.Minute)); got != 0 {
+		t.Errorf("first reading after restore = %v, want 0", got)
+	}
+	if rain.Baselined() {
+		t.Error("baselined from the first reading after a restart")
+	}
+}
+
+func TestRainRestoreFollowsResetTime(t *testing.T) {
+	loc := mustLoadLocation(t, "America/New_York")
+	reset, err := ParseResetTime("09:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 08:00 on 2 June is still the rain day that began on 1 June
+	rain := NewRainAccumulator(loc, 0.02, reset)
+	rain.Restore(RainState{Date: "2024-06-01", Baseline: 1.50, Last: 1.75}, time.Date(2024, 6, 2, 8, 0, 0, 0, loc))
+	if !rain.Baselined() {
+		t.Error("state from the current rain day not restored")
+	}
+}
@@ -0,0 +1,132 @@
+package weathermetrics
+
+import (
+	"container/list"
+	"fmt"
+	"time"
+)
+
+/*
+ * Station tracking
+ *
+ * Stations are keyed by rtl_433 id and channel. Spoofed or neighbouring
+ * sensors could otherwise grow the map without bound, so the tracker holds at
+ * most max stations and evicts the least recently updated one to make room.
+ *
+ * StationTracker is not safe for concurrent use; callers hold their own lock.
+ */
+type StationKey struct {
+	ID      int
+	Channel string
+
+	// Source is the topic-derived identifier of a payload without an id
+	Source string
+}
+
+func (k StationKey) String() string {
+	if k.Source != "" {
+		return fmt.Sprintf("%d/%s@%s", k.ID, k.Channel, k.Source)
+	}
+
+	return fmt.Sprintf("%d/%s", k.ID, k.Channel)
+}
+
+type Station struct {
+	ID         int               `json:"id"`
+	Channel    string            `json:"channel"`
+	Source     string            `json:"source,omitempty"`
+	Model      string            `json:"model"`
+	LastSeen   time.Time         `json:"last_seen"`
+	Conditions CurrentConditions `json:"conditions"`
+
+	// Sequence is replaced rather than updated in place, so copies handed
+	// out by Stations stay consistent. Nil until a sequence_num arrives.
+	Sequence *SequenceStats `json:"sequence,omitempty"`
+
+	sequence *sequenceState
+}
+
+type StationTracker struct {
+	max       int
+	order     *list.List
+	entries   map[StationKey]*list.Element
+	evictions int64
+}
+
+func NewStationTracker(max int) *StationTracker {
+	return &StationTracker{
+		max:     max,
+		order:   list.New(),
+		entries: make(map[StationKey]*list.Element),
+	}
+}
+
+// Update applies fn to the station's conditions, creating the station if it
+// is new and marking it most recently updated
+func (t *StationTracker) Update(key StationKey, model string, seen time.Time, fn func(*CurrentConditions)) {
+	elem, ok := t.entries[key]
+	if !ok {
+		if t.order.Len() >= t.max {
+			t.evictOldest()
+		}
+		elem = t.order.PushFront(&Station{ID: key.ID, Channel: key.Channel, Source: key.Source})
+		t.entries[key] = elem
+	}
+	t.order.MoveToFront(elem)
+
+	station := elem.Value.(*Station)
+	station.Model = model
+	station.LastSeen = seen
+	fn(&station.Conditions)
+}
+
+func (t *StationTracker) evictOldest() {
+	oldest := t.order.Back()
+	if oldest == nil {
+		return
+	}
+
+	station := t.order.Remove(oldest).(*Station)
+	key := StationKey{ID: station.ID, Channel: station.Channel, Source: station.Source}
+	delete(t.entries, key)
+	t.evictions++
+}
+
+// ObserveSequence records a sequence number from an already updated
+// station, counting any numbers skipped since its last one
+func (t *StationTracker) ObserveSequence(key StationKey, seq int, at time.Time, conf SequenceConfig) {
+	elem, ok := t.entries[key]
+	if !ok || conf.SequenceModulus <= 0 {
+		return
+	}
+
+	station := elem.Value.(*Station)
+	if station.sequence == nil {
+		station.sequence = &sequenceState{last: seq, missed: NewTimeWindowBuffer[int](conf.SequenceWindow)}
+		station.sequence.missed.Add(at, 0)
+		station.Sequence = &SequenceStats{ReceptionPercent: 100}
+		return
+	}
+
+	gaps := station.Sequence.Gaps + int64(station.sequence.observe(seq, at, conf.SequenceModulus))
+	station.Sequence = &SequenceStats{Gaps: gaps, ReceptionPercent: station.sequence.receptionPercent()}
+}
+
+// Stations returns a copy of every tracked station, most recently updated
+// first
+func (t *StationTracker) Stations() []Station {
+	stations := make([]Station, 0, t.order.Len())
+	for elem := t.order.Front(); elem != nil; elem = elem.Next() {
+		stations = append(stations, *elem.Value.(*Station))
+	}
+
+	return stations
+}
+
+func (t *StationTracker) Len() int {
+	return t.order.Len()
+}
+
+func (t *StationTracker) Evictions() int64 {
+	return t.evictions
+}
@@ -0,0 +1,52 @@
+package weathermetrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStationTrackerEvictsLeastRecentlyUpdated(t *testing.T) {
+	tracker := NewStationTracker(2)
+	at := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	noop := func(*CurrentConditions) {}
+
+	tracker.Update(StationKey{ID: 1, Channel: "A"}, SYNTHETIC_MODEL, at, noop)
+	tracker.Update(StationKey{ID: 2, Channel: "A"}, SYNTHETIC_MODEL, at.Add(time.Minute), noop)
+
+	// Touching 1 leaves 2 as the least recently updated
+	tracker.Update(StationKey{ID: 1, Channel: "A"}, SYNTHETIC_MODEL, at.Add(2*time.Minute), noop)
+	tracker.Update(StationKey{ID: 3, Channel: "A"}, SYNTHETIC_MODEL, at.Add(3*time.Minute), noop)
+
+	if tracker.Len() != 2 {
+		t.Fatalf("tracking %d stations, want 2", tracker.Len())
+	}
+	if tracker.Evictions() != 1 {
+		t.Errorf("%d evictions, want 1", tracker.Evictions())
+	}
+
+	var ids []int
+	for _, s := range tracker.Stations() {
+		ids = append(ids, s.ID)
+	}
+	if len(ids) != 2 || ids[0] != 3 || ids[1] != 1 {
+		t.Errorf("stations %v, want [3 1]", ids)
+	}
+}
+
+func TestStationTrackerKeysOnChannel(t *testing.T) {
+	tracker := NewStationTracker(10)
+	at := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tracker.Update(StationKey{ID: 1, Channel: "A"}, SYNTHETIC_MODEL, at, func(c *CurrentConditions) { c.Temp = 70 })
+	tracker.Update(StationKey{ID: 1, Channel: "B"}, SYNTHETIC_MODEL, at, func(c *CurrentConditions) { c.Temp = 60 })
+	tracker.Update(StationKey{ID: 1, Channel: "A"}, SYNTHETIC_MODEL, at, func(c *CurrentConditions) { c.Humidity = 40 })
+
+	if tracker.Len() != 2 || tracker.Evictions() != 0 {
+		t.Fatalf("tracking %d stations with %d evictions, want 2 and 0", tracker.Len(), tracker.Evictions())
+	}
+
+	a := tracker.Stations()[0]
+	if a.Channel != "A" || a.Conditions.Temp != 70 || a.Conditions.Humidity != 40 {
+		t.Errorf("station 1/A = %+v, want temp 70 humidity 40", a)
+	}
+}
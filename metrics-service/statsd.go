@@ -0,0 +1,118 @@
+package weathermetrics
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+ * Config
+ */
+type StatsdConfig struct {
+	Addr   string `envconfig:"STATSD_ADDR"`
+	Prefix string `envconfig:"STATSD_PREFIX" default:"weather"`
+
+	// Tags adds DogStatsD style id/channel/model tags to every gauge
+	Tags bool `envconfig:"STATSD_TAGS" default:"false"`
+
+	// Interval batches gauges on a ticker. Zero sends on every update.
+	Interval time.Duration `envconfig:"STATSD_INTERVAL" default:"0s"`
+}
+
+func (c StatsdConfig) Enabled() bool {
+	return c.Addr != ""
+}
+
+/*
+ * Statsd sink
+ *
+ * Gauges go out over UDP, so a missing or slow collector never blocks ingest;
+ * send failures are only logged.
+ */
+type StatsdSink struct {
+	conf   StatsdConfig
+	clock  Clock
+	conn   net.Conn
+	m      sync.Mutex
+	latest *CurrentConditions
+}
+
+func NewStatsdSink(conf StatsdConfig, clock Clock) (*StatsdSink, error) {
+	conn, err := net.Dial("udp", conf.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("could not open statsd socket: %w", err)
+	}
+
+	return &StatsdSink{conf: conf, clock: clock, conn: conn}, nil
+}
+
+func (s *StatsdSink) Write(c CurrentConditions, at time.Time) {
+	if s.conf.Interval == 0 {
+		s.send(c)
+		return
+	}
+
+	s.m.Lock()
+	s.latest = &c
+	s.m.Unlock()
+}
+
+// Run sends the latest conditions every Interval until stop is closed, and
+// once more on the way out. It is only needed when Interval is set.
+func (s *StatsdSink) Run(stop <-chan struct{}) {
+	ticker := s.clock.NewTicker(s.conf.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			s.flush()
+		case <-stop:
+			s.flush()
+			return
+		}
+	}
+}
+
+func (s *StatsdSink) flush() {
+	s.m.Lock()
+	latest := s.latest
+	s.latest = nil
+	s.m.Unlock()
+
+	if latest != nil {
+		s.send(*latest)
+	}
+}
+
+func (s *StatsdSink) send(c CurrentConditions) {
+	samples := conditionSamples(c)
+	names := make([]string, 0, len(samples))
+	for name := range samples {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tags := ""
+	if s.conf.Tags {
+		tags = fmt.Sprintf("|#id:%d,channel:%s,model:%s", c.ID, c.Channel, c.Model)
+	}
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s.%s:%g|g%s\n", s.conf.Prefix, name, samples[name], tags)
+	}
+
+	if _, err := s.conn.Write(buf.Bytes()); err != nil {
+		log.Printf("statsd: %s", err)
+	}
+}
+
+func (s *StatsdSink) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,167 @@
+package weathermetrics
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listenStatsd stands in for the statsd collector
+func listenStatsd(t *testing.T) *net.UDPConn {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readPacket returns the next datagram's lines
+func readPacket(t *testing.T, conn *net.UDPConn) []string {
+	t.Helper()
+
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(buf[:n])), "\n")
+}
+
+var statsdConditions = CurrentConditions{
+	Model:         SYNTHETIC_MODEL,
+	ID:            1026,
+	Channel:       "C",
+	Temp:          69.5,
+	Humidity:      97,
+	WindSpeed:     12,
+	WindDirection: 270,
+	RainInches:    0.25,
+}
+
+func TestStatsdSendsGauges(t *testing.T) {
+	collector := listenStatsd(t)
+	sink, err := NewStatsdSink(StatsdConfig{Addr: collector.LocalAddr().String(), Prefix: "weather"}, RealClock{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	sink.Write(statsdConditions, time.Now())
+
+	want := []string{
+		"weather.humidity:97|g",
+		"weather.rain_in:0.25|g",
+		"weather.temperature:69.5|g",
+		"weather.wind_direction:270|g",
+		"weather.wind_speed:12|g",
+	}
+	if got := readPacket(t, collector); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestStatsdTags(t *testing.T) {
+	collector := listenStatsd(t)
+	conf := StatsdConfig{Addr: collector.LocalAddr().String(), Prefix: "ws", Tags: true}
+	sink, err := NewStatsdSink(conf, RealClock{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	sink.Write(statsdConditions, time.Now())
+
+	for _, line := range readPacket(t, collector) {
+		if !strings.HasPrefix(line, "ws.") || !strings.HasSuffix(line, "|g|#id:1026,channel:C,model:Acurite-5n1") {
+			t.Errorf("untagged gauge %q", line)
+		}
+	}
+}
+
+func TestStatsdIntervalSendsLatest(t *testing.T) {
+	collector := listenStatsd(t)
+	clock := NewFakeClock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	conf := StatsdConfig{Addr: collector.LocalAddr().String(), Prefix: "weather", Interval: time.Minute}
+	sink, err := NewStatsdSink(conf, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go sink.Run(stop)
+	for clock.Tickers() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	first, second := statsdConditions, statsdConditions
+	first.Temp, second.Temp = 60, 61
+	sink.Write(first, clock.Now())
+	sink.Write(second, clock.Now())
+
+	// Nothing goes out until the tick, then only the newest conditions
+	collector.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, err := collector.Read(make([]byte, 4096)); err == nil {
+		t.Fatal("sent before the interval")
+	}
+
+	clock.Advance(time.Minute)
+	lines := readPacket(t, collector)
+	if !strings.Contains(strings.Join(lines, "\n"), "weather.temperature:61|g") {
+		t.Errorf("tick sent %v, want the latest temperature 61", lines)
+	}
+}
+
+func TestStatsdRunSendsLatestOnStop(t *testing.T) {
+	collector := listenStatsd(t)
+	clock := NewFakeClock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	conf := StatsdConfig{Addr: collector.LocalAddr().String(), Prefix: "weather", Interval: time.Minute}
+	sink, err := NewStatsdSink(conf, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	sink.Write(statsdConditions, clock.Now())
+
+	stop := make(chan struct{})
+	close(stop)
+	sink.Run(stop)
+
+	lines := readPacket(t, collector)
+	if !strings.Contains(strings.Join(lines, "\n"), "weather.temperature:69.5|g") {
+		t.Errorf("stop sent %v, want the pending temperature 69.5", lines)
+	}
+}
+
+func TestStatsdUnreachableCollectorDoesNotBlock(t *testing.T) {
+	collector := listenStatsd(t)
+	addr := collector.LocalAddr().String()
+	collector.Close()
+
+	sink, err := NewStatsdSink(StatsdConfig{Addr: addr, Prefix: "weather"}, RealClock{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 3; i++ {
+			sink.Write(statsdConditions, time.Now())
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Write blocked on an unreachable collector")
+	}
+}
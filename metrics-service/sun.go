@@ -0,0 +1,129 @@
+package weathermetrics
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+/*
+ * Config
+ *
+ * The station's position, for sunrise and sunset. Longitude is positive east
+ * of Greenwich. Both are unset by default, which leaves the sun times out.
+ */
+type SunConfig struct {
+	Latitude  *float64 `envconfig:"LATITUDE"`
+	Longitude *float64 `envconfig:"LONGITUDE"`
+}
+
+func (c SunConfig) Enabled() bool {
+	return c.Latitude != nil && c.Longitude != nil
+}
+
+func (c SunConfig) Validate() error {
+	if (c.Latitude == nil) != (c.Longitude == nil) {
+		return fmt.Errorf("LATITUDE and LONGITUDE must be set together")
+	}
+
+	if !c.Enabled() {
+		return nil
+	}
+
+	if *c.Latitude < -90 || *c.Latitude > 90 {
+		return fmt.Errorf("LATITUDE must be between -90 and 90, got %v", *c.Latitude)
+	}
+
+	if *c.Longitude < -180 || *c.Longitude > 180 {
+		return fmt.Errorf("LONGITUDE must be between -180 and 180, got %v", *c.Longitude)
+	}
+
+	return nil
+}
+
+/*
+ * Sunrise and sunset
+ *
+ * The sunrise equation with the usual corrections for the equation of time
+ * and for refraction and the sun's radius (the -0.833 degree altitude),
+ * good to a minute or so away from the poles. Nothing is looked up.
+ *
+ * Inside the polar circles the sun may not cross the horizon at all on a
+ * given day. Then there is no sunrise or sunset and Polar says whether it is
+ * "day" (midnight sun) or "night".
+ */
+const (
+	SUN_POLAR_DAY   = "day"
+	SUN_POLAR_NIGHT = "night"
+
+	julianJ2000     = 2451545.0
+	sunriseAltitude = -0.833
+	earthObliquity  = 23.4397
+	earthPerihelion = 102.9372
+	secondsPerDay   = 86400
+)
+
+type SunTimes struct {
+	Sunrise *time.Time `json:"sunrise,omitempty"`
+	Sunset  *time.Time `json:"sunset,omitempty"`
+	Polar   string     `json:"polar,omitempty"`
+}
+
+// SunTimesOn works out sunrise and sunset for the local calendar day that at
+// falls on, in at's location
+func SunTimesOn(at time.Time, latitude, longitude float64) SunTimes {
+	year, month, day := at.Date()
+	date := time.Date(year, month, day, 12, 0, 0, 0, time.UTC)
+	n := math.Round(date.Sub(time.Date(2000, 1, 1, 12, 0, 0, 0, time.UTC)).Hours() / 24)
+
+	// Mean solar noon, then the sun's mean anomaly, equation of the centre
+	// and ecliptic longitude
+	jStar := n - longitude/360
+	m := math.Mod(357.5291+0.98560028*jStar, 360)
+	c := 1.9148*sinDeg(m) + 0.02*sinDeg(2*m) + 0.0003*sinDeg(3*m)
+	lambda := math.Mod(m+c+180+earthPerihelion, 360)
+
+	transit := julianJ2000 + jStar + 0.0053*sinDeg(m) - 0.0069*sinDeg(2*lambda)
+
+	sinDecl := sinDeg(lambda) * sinDeg(earthObliquity)
+	cosDecl := math.Cos(math.Asin(sinDecl))
+	cosHourAngle := (sinDeg(sunriseAltitude) - sinDeg(latitude)*sinDecl) / (cosDeg(latitude) * cosDecl)
+
+	switch {
+	case cosHourAngle > 1:
+		return SunTimes{Polar: SUN_POLAR_NIGHT}
+	case cosHourAngle < -1:
+		return SunTimes{Polar: SUN_POLAR_DAY}
+	}
+
+	hourAngle := math.Acos(cosHourAngle) * 180 / math.Pi
+	sunrise := julianToTime(transit-hourAngle/360, at.Location())
+	sunset := julianToTime(transit+hourAngle/360, at.Location())
+
+	return SunTimes{Sunrise: &sunrise, Sunset: &sunset}
+}
+
+// IsDaylight reports whether at falls between the day's sunrise and sunset
+func IsDaylight(at time.Time, latitude, longitude float64) bool {
+	sun := SunTimesOn(at, latitude, longitude)
+	if sun.Polar != "" {
+		return sun.Polar == SUN_POLAR_DAY
+	}
+
+	return !at.Before(*sun.Sunrise) && at.Before(*sun.Sunset)
+}
+
+func julianToTime(j float64, loc *time.Location) time.Time {
+	seconds := math.Round((j - julianJ2000) * secondsPerDay)
+	j2000 := time.Date(2000, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	return j2000.Add(time.Duration(seconds) * time.Second).In(loc)
+}
+
+func sinDeg(d float64) float64 {
+	return math.Sin(d * math.Pi / 180)
+}
+
+func cosDeg(d float64) float64 {
+	return math.Cos(d * math.Pi / 180)
+}
@@ -0,0 +1,125 @@
+package weathermetrics
+
+import (
+	"testing"
+	"time"
+)
+
+// closeTo reports whether got is within a couple of minutes of hh:mm on
+// got's day, the accuracy the sunrise equation promises
+func closeTo(got time.Time, hour, minute int) bool {
+	want := time.Date(got.Year(), got.Month(), got.Day(), hour, minute, 0, 0, got.Location())
+	diff := got.Sub(want)
+	return diff > -2*time.Minute && diff < 2*time.Minute
+}
+
+func TestSunTimesKnownDates(t *testing.T) {
+	// Published times, rounded to the minute
+	for _, tc := range []struct {
+		name                string
+		tz                  string
+		date                time.Time
+		latitude, longitude float64
+		riseH, riseM        int
+		setH, setM          int
+	}{
+		{
+			name: "New York, summer solstice", tz: "America/New_York",
+			date: time.Date(2024, 6, 20, 12, 0, 0, 0, time.UTC), latitude: 40.7128, longitude: -74.0060,
+			riseH: 5, riseM: 25, setH: 20, setM: 31,
+		},
+		{
+			name: "London, winter solstice", tz: "Europe/London",
+			date: time.Date(2024, 12, 21, 12, 0, 0, 0, time.UTC), latitude: 51.5074, longitude: -0.1278,
+			riseH: 8, riseM: 4, setH: 15, setM: 54,
+		},
+		{
+			name: "Sydney, southern summer", tz: "Australia/Sydney",
+			date: time.Date(2024, 12, 21, 12, 0, 0, 0, time.UTC), latitude: -33.8688, longitude: 151.2093,
+			riseH: 5, riseM: 41, setH: 20, setM: 5,
+		},
+	} {
+		loc := mustLoadLocation(t, tc.tz)
+		year, month, day := tc.date.Date()
+		noon := time.Date(year, month, day, 12, 0, 0, 0, loc)
+
+		sun := SunTimesOn(noon, tc.latitude, tc.longitude)
+		if sun.Sunrise == nil || sun.Sunset == nil {
+			t.Errorf("%s: no sunrise or sunset: %+v", tc.name, sun)
+			continue
+		}
+		if sun.Sunrise.Location() != loc {
+			t.Errorf("%s: sunrise in %s, want %s", tc.name, sun.Sunrise.Location(), loc)
+		}
+		if !closeTo(*sun.Sunrise, tc.riseH, tc.riseM) {
+			t.Errorf("%s: sunrise %s, want about %02d:%02d", tc.name, sun.Sunrise.Format("15:04:05"), tc.riseH, tc.riseM)
+		}
+		if !closeTo(*sun.Sunset, tc.setH, tc.setM) {
+			t.Errorf("%s: sunset %s, want about %02d:%02d", tc.name, sun.Sunset.Format("15:04:05"), tc.setH, tc.setM)
+		}
+	}
+}
+
+func TestSunTimesPolar(t *testing.T) {
+	loc := mustLoadLocation(t, "Europe/Oslo")
+	const tromsoLat, tromsoLon = 69.6492, 18.9553
+
+	summer := SunTimesOn(time.Date(2024, 6, 21, 12, 0, 0, 0, loc), tromsoLat, tromsoLon)
+	if summer.Polar != SUN_POLAR_DAY || summer.Sunrise != nil || summer.Sunset != nil {
+		t.Errorf("Tromsø in June = %+v, want midnight sun", summer)
+	}
+
+	winter := SunTimesOn(time.Date(2024, 12, 21, 12, 0, 0, 0, loc), tromsoLat, tromsoLon)
+	if winter.Polar != SUN_POLAR_NIGHT || winter.Sunrise != nil || winter.Sunset != nil {
+		t.Errorf("Tromsø in December = %+v, want polar night", winter)
+	}
+
+	if !IsDaylight(time.Date(2024, 6, 21, 0, 30, 0, 0, loc), tromsoLat, tromsoLon) {
+		t.Error("not daylight at midnight in the midnight sun")
+	}
+	if IsDaylight(time.Date(2024, 12, 21, 12, 0, 0, 0, loc), tromsoLat, tromsoLon) {
+		t.Error("daylight at noon in the polar night")
+	}
+}
+
+func TestIsDaylight(t *testing.T) {
+	loc := mustLoadLocation(t, "America/New_York")
+	const lat, lon = 40.7128, -74.0060
+
+	for _, tc := range []struct {
+		hour, minute int
+		want         bool
+	}{
+		{hour: 4, minute: 0, want: false},
+		{hour: 6, minute: 0, want: true},
+		{hour: 12, minute: 0, want: true},
+		{hour: 20, minute: 0, want: true},
+		{hour: 21, minute: 0, want: false},
+	} {
+		at := time.Date(2024, 6, 20, tc.hour, tc.minute, 0, 0, loc)
+		if got := IsDaylight(at, lat, lon); got != tc.want {
+			t.Errorf("IsDaylight at %s = %v, want %v", at.Format("15:04"), got, tc.want)
+		}
+	}
+}
+
+func TestSunConfigValidate(t *testing.T) {
+	lat, lon, bad := 40.7, -74.0, 200.0
+
+	for _, tc := range []struct {
+		name string
+		conf SunConfig
+		ok   bool
+	}{
+		{name: "unset", conf: SunConfig{}, ok: true},
+		{name: "both", conf: SunConfig{Latitude: &lat, Longitude: &lon}, ok: true},
+		{name: "latitude only", conf: SunConfig{Latitude: &lat}},
+		{name: "longitude only", conf: SunConfig{Longitude: &lon}},
+		{name: "latitude out of range", conf: SunConfig{Latitude: &bad, Longitude: &lon}},
+		{name: "longitude out of range", conf: SunConfig{Latitude: &lat, Longitude: &bad}},
+	} {
+		if err := tc.conf.Validate(); (err == nil) != tc.ok {
+			t.Errorf("%s: Validate() = %v", tc.name, err)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package weathermetrics
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+/*
+ * Synthetic readings
+ *
+ * Payloads and measurements shaped like rtl_433's Acurite-5n1 output, for
+ * exercising a deployment end to end without a radio.
+ */
+const SYNTHETIC_MODEL = "Acurite-5n1"
+
+func SyntheticTempHumidity(at time.Time, id int, channel string, tempF, humidity float32) []byte {
+	payload, _ := json.Marshal(SyntheticTempHumidityMeasurement(at, id, channel, tempF, humidity))
+	return payload
+}
+
+func SyntheticTempHumidityMeasurement(at time.Time, id int, channel string, tempF, humidity float32) TempHumidityMeasurement {
+	return TempHumidityMeasurement{
+		Timestamp:   at.Format(RTL433_TIME_FORMAT),
+		Model:       SYNTHETIC_MODEL,
+		ID:          id,
+		Channel:     Channel(channel),
+		Temp:        tempF,
+		Humidity:    humidity,
+		Battery:     1,
+		MessageType: TEMP_HUMIDITY_MESSAGE,
+		Mic:         "CHECKSUM",
+	}
+}
+
+func SyntheticWindRain(at time.Time, id int, channel string, windKmh, windDir, rainIn float32) []byte {
+	payload, _ := json.Marshal(SyntheticWindRainMeasurement(at, id, channel, windKmh, windDir, rainIn))
+	return payload
+}
+
+func SyntheticWindRainMeasurement(at time.Time, id int, channel string, windKmh, windDir, rainIn float32) WindRainMeasurement {
+	return WindRainMeasurement{
+		Timestamp:     at.Format(RTL433_TIME_FORMAT),
+		Model:         SYNTHETIC_MODEL,
+		ID:            id,
+		Channel:       Channel(channel),
+		WindSpeed:     windKmh,
+		WindDirection: windDir,
+		RainInches:    rainIn,
+		Battery:       1,
+		MessageType:   WIND_RAIN_MESSAGE,
+		Mic:           "CHECKSUM",
+	}
+}
+
+/*
+ * Synthetic weather
+ *
+ * Believable rather than accurate: temperature follows a daily curve peaking
+ * mid afternoon with humidity moving the other way, wind wanders around a
+ * prevailing direction with the odd gust, and now and then a shower runs the
+ * rain counter up for a while. The same seed gives the same weather for the
+ * same sequence of times.
+ *
+ * SyntheticWeather is not safe for concurrent use.
+ */
+type SyntheticWeather struct {
+	rng *rand.Rand
+
+	direction  float32
+	rainIn     float32
+	showerLeft int
+}
+
+func NewSyntheticWeather(seed int64) *SyntheticWeather {
+	return &SyntheticWeather{rng: rand.New(rand.NewSource(seed)), direction: 225}
+}
+
+// TempHumidity gives the conditions at at's local time of day
+func (w *SyntheticWeather) TempHumidity(at time.Time) (tempF, humidity float32) {
+	hour := float64(at.Hour()) + float64(at.Minute())/60
+	diurnal := math.Cos(2 * math.Pi * (hour - 15) / 24)
+
+	tempF = float32(60 + 12*diurnal + w.rng.NormFloat64()*0.3)
+	humidity = float32(max(min(60-20*diurnal+w.rng.NormFloat64(), 100), 5))
+	return tempF, humidity
+}
+
+// WindRain advances the wind and rain by one reading. gustKmh is the peak
+// since the last reading, now and then well above the average.
+func (w *SyntheticWeather) WindRain() (windKmh, gustKmh, direction, rainIn float32) {
+	windKmh = float32(max(8+w.rng.NormFloat64()*3, 0))
+	gustKmh = windKmh + float32(math.Abs(w.rng.NormFloat64())*3)
+	if w.rng.Float64() < 0.1 {
+		gustKmh += float32(15 + w.rng.Float64()*15)
+	}
+
+	w.direction = float32(math.Mod(float64(w.direction)+w.rng.NormFloat64()*10+360, 360))
+
+	if w.showerLeft == 0 && w.rng.Float64() < 0.02 {
+		w.showerLeft = 10 + w.rng.Intn(40)
+	}
+	if w.showerLeft > 0 {
+		w.showerLeft--
+		w.rainIn += 0.01
+	}
+
+	return windKmh, gustKmh, w.direction, w.rainIn
+}
+
+// ConcreteTopic fills the wildcards in a subscription topic so a message can
+// be published that the subscription will match. A shared subscription's
+// $share/<group> prefix is dropped.
+func ConcreteTopic(topic, fill string) string {
+	if _, filter, ok := SplitSharedTopic(topic); ok {
+		topic = filter
+	}
+
+	levels := strings.Split(topic, "/")
+	for i := range levels {
+		if levels[i] == "+" || levels[i] == "#" {
+			levels[i] = fill
+		}
+	}
+
+	return strings.Join(levels, "/")
+}
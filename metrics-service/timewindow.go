@@ -0,0 +1,152 @@
+package weathermetrics
+
+import (
+	"math"
+	"time"
+)
+
+/*
+ * Time window
+ *
+ * TimeWindowBuffer keeps the values added within the last window, oldest
+ * first, for features that summarise a recent stretch of readings. Values are
+ * expected in time order; pruning drops from the front. Each Add prunes
+ * against the new value's time, and callers that read without adding prune
+ * against their own clock.
+ *
+ * TimeWindowBuffer is not safe for concurrent use; callers hold their own
+ * lock.
+ */
+type TimedValue[T any] struct {
+	At    time.Time
+	Value T
+}
+
+type TimeWindowBuffer[T any] struct {
+	window  time.Duration
+	samples []TimedValue[T]
+}
+
+func NewTimeWindowBuffer[T any](window time.Duration) *TimeWindowBuffer[T] {
+	return &TimeWindowBuffer[T]{window: window}
+}
+
+func (b *TimeWindowBuffer[T]) Add(at time.Time, v T) {
+	b.samples = append(b.samples, TimedValue[T]{At: at, Value: v})
+	b.Prune(at)
+}
+
+// Prune drops values older than the window as of now
+func (b *TimeWindowBuffer[T]) Prune(now time.Time) {
+	cutoff := now.Add(-b.window)
+	i := 0
+	for i < len(b.samples) && b.samples[i].At.Before(cutoff) {
+		i++
+	}
+
+	// Copy down rather than reslice so the backing array doesn't keep
+	// growing while the window slides
+	if i > 0 {
+		b.samples = append(b.samples[:0], b.samples[i:]...)
+	}
+}
+
+// Samples returns the values in the window, oldest first. The slice is only
+// valid until the next Add or Prune.
+func (b *TimeWindowBuffer[T]) Samples() []TimedValue[T] {
+	return b.samples
+}
+
+func (b *TimeWindowBuffer[T]) Len() int {
+	return len(b.samples)
+}
+
+func (b *TimeWindowBuffer[T]) Reset() {
+	b.samples = b.samples[:0]
+}
+
+/*
+ * Aggregates
+ *
+ * Each returns false for an empty window.
+ */
+type Number interface {
+	~int | ~int64 | ~float32 | ~float64
+}
+
+func WindowMean[T Number](b *TimeWindowBuffer[T]) (float64, bool) {
+	if b.Len() == 0 {
+		return 0, false
+	}
+
+	var sum float64
+	for _, s := range b.samples {
+		sum += float64(s.Value)
+	}
+
+	return sum / float64(b.Len()), true
+}
+
+func WindowMax[T Number](b *TimeWindowBuffer[T]) (T, bool) {
+	var peak T
+	if b.Len() == 0 {
+		return peak, false
+	}
+
+	peak = b.samples[0].Value
+	for _, s := range b.samples[1:] {
+		peak = max(peak, s.Value)
+	}
+
+	return peak, true
+}
+
+// WindSample is a speed and a direction in degrees
+type WindSample struct {
+	Speed     float32
+	Direction float32
+}
+
+func WindowMeanDirection(b *TimeWindowBuffer[WindSample]) (float32, bool) {
+	samples := make([]WindSample, b.Len())
+	for i, s := range b.samples {
+		samples[i] = s.Value
+	}
+
+	return MeanDirection(samples)
+}
+
+// MeanDirection vector averages wind directions, weighted by speed, so
+// readings either side of north don't average out to south. If every
+// reading was calm the directions are averaged unweighted. The result is in
+// [0, 360).
+func MeanDirection(samples []WindSample) (float32, bool) {
+	if len(samples) == 0 {
+		return 0, false
+	}
+
+	var x, y, calmX, calmY float64
+	for _, s := range samples {
+		rad := float64(s.Direction) * math.Pi / 180
+		x += float64(s.Speed) * math.Sin(rad)
+		y += float64(s.Speed) * math.Cos(rad)
+		calmX += math.Sin(rad)
+		calmY += math.Cos(rad)
+	}
+
+	if x == 0 && y == 0 {
+		x, y = calmX, calmY
+	}
+
+	direction := float32(math.Atan2(x, y) * 180 / math.Pi)
+	if direction < 0 {
+		direction += 360
+	}
+
+	// Rounding can leave a hair under north at exactly 360
+	if direction >= 360 {
+		direction = 0
+	}
+
+	return direction, true
+}
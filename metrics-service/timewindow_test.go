@@ -0,0 +1,126 @@
+package weathermetrics
+
+import (
+	"testing"
+	"time"
+)
+
+var bufferStart = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func TestTimeWindowPrunesAtBoundary(t *testing.T) {
+	b := NewTimeWindowBuffer[float32](time.Minute)
+	b.Add(bufferStart, 1)
+	b.Add(bufferStart.Add(30*time.Second), 2)
+
+	// A value exactly one window old is still in it
+	b.Add(bufferStart.Add(time.Minute), 3)
+	if b.Len() != 3 {
+		t.Fatalf("Len = %d at the boundary, want 3", b.Len())
+	}
+
+	// A nanosecond later it has aged out
+	b.Prune(bufferStart.Add(time.Minute + time.Nanosecond))
+	if b.Len() != 2 || b.Samples()[0].Value != 2 {
+		t.Fatalf("after the boundary got %v, want the last two", b.Samples())
+	}
+
+	b.Prune(bufferStart.Add(3 * time.Minute))
+	if b.Len() != 0 {
+		t.Errorf("Len = %d once everything aged out, want 0", b.Len())
+	}
+}
+
+func TestTimeWindowKeepsOrderAcrossPrunes(t *testing.T) {
+	b := NewTimeWindowBuffer[int](10 * time.Second)
+	for i := range 100 {
+		b.Add(bufferStart.Add(time.Duration(i)*time.Second), i)
+	}
+
+	samples := b.Samples()
+	if len(samples) != 11 {
+		t.Fatalf("Len = %d, want 11", len(samples))
+	}
+	for i, s := range samples {
+		if s.Value != 89+i {
+			t.Fatalf("samples %v not the newest, oldest first", samples)
+		}
+	}
+}
+
+func TestTimeWindowReset(t *testing.T) {
+	b := NewTimeWindowBuffer[float32](time.Minute)
+	b.Add(bufferStart, 1)
+	b.Reset()
+	if b.Len() != 0 {
+		t.Errorf("Len = %d after Reset", b.Len())
+	}
+	if _, ok := WindowMean(b); ok {
+		t.Error("mean of an empty window")
+	}
+}
+
+func TestWindowMeanAndMax(t *testing.T) {
+	b := NewTimeWindowBuffer[float32](time.Minute)
+	if _, ok := WindowMean(b); ok {
+		t.Error("mean of an empty window")
+	}
+	if _, ok := WindowMax(b); ok {
+		t.Error("max of an empty window")
+	}
+
+	b.Add(bufferStart, 10)
+	b.Add(bufferStart.Add(20*time.Second), -4)
+	b.Add(bufferStart.Add(40*time.Second), 6)
+
+	if mean, ok := WindowMean(b); !ok || mean != 4 {
+		t.Errorf("mean = %v, %v, want 4", mean, ok)
+	}
+	if peak, ok := WindowMax(b); !ok || peak != 10 {
+		t.Errorf("max = %v, %v, want 10", peak, ok)
+	}
+
+	// The peak ages out with its sample
+	b.Add(bufferStart.Add(70*time.Second), 2)
+	if peak, _ := WindowMax(b); peak != 6 {
+		t.Errorf("max = %v after the peak aged out, want 6", peak)
+	}
+	if mean, _ := WindowMean(b); !approxEqual(float32(mean), 4.0/3) {
+		t.Errorf("mean = %v after pruning, want 1.33", mean)
+	}
+}
+
+func TestWindowMaxAllNegative(t *testing.T) {
+	b := NewTimeWindowBuffer[float64](time.Minute)
+	b.Add(bufferStart, -5)
+	b.Add(bufferStart.Add(time.Second), -2)
+	if peak, _ := WindowMax(b); peak != -2 {
+		t.Errorf("max = %v, want -2", peak)
+	}
+}
+
+func TestWindowMeanDirection(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		samples []WindSample
+		want    float32
+	}{
+		{name: "across north", samples: []WindSample{{Speed: 5, Direction: 350}, {Speed: 5, Direction: 10}}, want: 0},
+		{name: "weighted by speed", samples: []WindSample{{Speed: 9, Direction: 90}, {Speed: 1, Direction: 180}}, want: 96.34},
+		{name: "all calm", samples: []WindSample{{Direction: 80}, {Direction: 100}}, want: 90},
+		{name: "calm ignored", samples: []WindSample{{Speed: 3, Direction: 270}, {Direction: 90}}, want: 270},
+	} {
+		b := NewTimeWindowBuffer[WindSample](time.Minute)
+		for i, s := range tc.samples {
+			b.Add(bufferStart.Add(time.Duration(i)*time.Second), s)
+		}
+
+		got, ok := WindowMeanDirection(b)
+		if !ok || !approxEqual(got, tc.want) {
+			t.Errorf("%s: direction = %v, %v, want %v", tc.name, got, ok, tc.want)
+		}
+	}
+
+	if _, ok := WindowMeanDirection(NewTimeWindowBuffer[WindSample](time.Minute)); ok {
+		t.Error("direction of an empty window")
+	}
+}
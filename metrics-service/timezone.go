@@ -0,0 +1,31 @@
+package weathermetrics
+
+import (
+	"fmt"
+	"time"
+)
+
+/*
+ * Config
+ *
+ * The timezone that sensor timestamps are in, and that daily totals and
+ * local time displays use. Shared by every binary so they agree on when a
+ * day starts. Under a prefix the prefixed name wins, e.g. PWS_TZ over TZ.
+ */
+type TimezoneConfig struct {
+	TZ string `envconfig:"TZ" default:"America/New_York"`
+}
+
+func (c TimezoneConfig) Validate() error {
+	_, err := c.Location()
+	return err
+}
+
+func (c TimezoneConfig) Location() (*time.Location, error) {
+	loc, err := time.LoadLocation(c.TZ)
+	if err != nil {
+		return nil, fmt.Errorf("TZ must be an IANA timezone name such as America/New_York, got %q: %w", c.TZ, err)
+	}
+
+	return loc, nil
+}
@@ -0,0 +1,23 @@
+package weathermetrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTimezoneLocation(t *testing.T) {
+	loc, err := TimezoneConfig{TZ: "Europe/Dublin"}.Location()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc.String() != "Europe/Dublin" {
+		t.Errorf("location = %s", loc)
+	}
+}
+
+func TestInvalidTimezoneRejected(t *testing.T) {
+	err := TimezoneConfig{TZ: "Mars/Olympus_Mons"}.Validate()
+	if err == nil || !strings.Contains(err.Error(), `TZ must be an IANA timezone name`) {
+		t.Errorf("got error %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package weathermetrics
+
+import "time"
+
+/*
+ * Config
+ */
+type TrendConfig struct {
+	TrendWindow time.Duration `envconfig:"TREND_WINDOW" default:"3h"`
+
+	// TrendThreshold is the slope, in degrees F per hour, beyond which the
+	// temperature counts as rising or falling
+	TrendThreshold float64 `envconfig:"TREND_THRESHOLD" default:"1"`
+}
+
+/*
+ * Temperature trend
+ *
+ * Fits a least squares line through the readings in the window and reports
+ * 1 (rising), -1 (falling) or 0 (steady) depending on its slope.
+ *
+ * TemperatureTrend is not safe for concurrent use; callers hold their own
+ * lock.
+ */
+type TemperatureTrend struct {
+	conf    TrendConfig
+	samples *TimeWindowBuffer[float64]
+}
+
+func NewTemperatureTrend(conf TrendConfig) *TemperatureTrend {
+	return &TemperatureTrend{conf: conf, samples: NewTimeWindowBuffer[float64](conf.TrendWindow)}
+}
+
+func (t *TemperatureTrend) Add(at time.Time, temp float32) {
+	t.samples.Add(at, float64(temp))
+}
+
+func (t *TemperatureTrend) Trend(now time.Time) int {
+	t.samples.Prune(now)
+	samples := t.samples.Samples()
+	if len(samples) < 2 {
+		return 0
+	}
+
+	// Hours relative to the first sample keep the sums well conditioned
+	origin := samples[0].At
+	var sumX, sumY, sumXY, sumXX float64
+	for _, s := range samples {
+		x := s.At.Sub(origin).Hours()
+		sumX += x
+		sumY += s.Value
+		sumXY += x * s.Value
+		sumXX += x * x
+	}
+
+	n := float64(len(samples))
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return 0
+	}
+
+	slope := (n*sumXY - sumX*sumY) / denominator
+	switch {
+	case slope >= t.conf.TrendThreshold:
+		return 1
+	case slope <= -t.conf.TrendThreshold:
+		return -1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,54 @@
+package weathermetrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTemperatureTrend(t *testing.T) {
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	conf := TrendConfig{TrendWindow: 3 * time.Hour, TrendThreshold: 1}
+
+	for _, tc := range []struct {
+		name  string
+		temps []float32 // one reading every 30 minutes
+		want  int
+	}{
+		{name: "rising", temps: []float32{60, 61, 62, 63, 64}, want: 1},
+		{name: "falling", temps: []float32{70, 69, 68, 67, 66}, want: -1},
+		{name: "steady", temps: []float32{65, 65.2, 64.9, 65.1, 65}, want: 0},
+		{name: "just under threshold", temps: []float32{60, 60.4, 60.8, 61.2, 61.6}, want: 0},
+		{name: "noisy rise", temps: []float32{60, 62, 61, 63, 62.5, 64}, want: 1},
+		{name: "single reading", temps: []float32{60}, want: 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			trend := NewTemperatureTrend(conf)
+			at := start
+			for _, temp := range tc.temps {
+				trend.Add(at, temp)
+				at = at.Add(30 * time.Minute)
+			}
+
+			if got := trend.Trend(at); got != tc.want {
+				t.Errorf("Trend = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTemperatureTrendForgetsOldReadings(t *testing.T) {
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	trend := NewTemperatureTrend(TrendConfig{TrendWindow: 3 * time.Hour, TrendThreshold: 1})
+
+	// A morning rise, then a flat afternoon
+	for i := 0; i < 6; i++ {
+		trend.Add(start.Add(time.Duration(i)*30*time.Minute), float32(55+2*i))
+	}
+	for i := 0; i < 7; i++ {
+		trend.Add(start.Add(4*time.Hour+time.Duration(i)*30*time.Minute), 70)
+	}
+
+	if got := trend.Trend(start.Add(7 * time.Hour)); got != 0 {
+		t.Errorf("Trend = %d once the rise left the window, want 0", got)
+	}
+}
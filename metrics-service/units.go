@@ -0,0 +1,65 @@
+package weathermetrics
+
+/*
+ * Unit conversions
+ *
+ * Every conversion between the sensor's units and the units of the outputs
+ * goes through here rather than being written inline.
+ */
+const (
+	MPH_PER_KMH   = 0.62137119
+	KNOTS_PER_KMH = 0.53995680
+	MM_PER_INCH   = 25.4
+	KMH_PER_MS    = 3.6
+	HPA_PER_INHG  = 33.8639
+	HPA_PER_KPA   = 10
+	KELVIN_OFFSET = 273.15
+)
+
+func FahrenheitToCelsius(f float32) float32 {
+	return (f - 32) * 5 / 9
+}
+
+func CelsiusToFahrenheit(c float32) float32 {
+	return c*9/5 + 32
+}
+
+func FahrenheitToKelvin(f float32) float32 {
+	return FahrenheitToCelsius(f) + KELVIN_OFFSET
+}
+
+func KmhToMph(kmh float32) float32 {
+	return kmh * MPH_PER_KMH
+}
+
+func KmhToKnots(kmh float32) float32 {
+	return kmh * KNOTS_PER_KMH
+}
+
+func MphToKmh(mph float32) float32 {
+	return mph / MPH_PER_KMH
+}
+
+func MsToKmh(ms float32) float32 {
+	return ms * KMH_PER_MS
+}
+
+func InchesToMM(in float32) float32 {
+	return in * MM_PER_INCH
+}
+
+func MMToInches(mm float32) float32 {
+	return mm / MM_PER_INCH
+}
+
+func KPaToHPa(kpa float32) float32 {
+	return kpa * HPA_PER_KPA
+}
+
+func InHgToHPa(inHg float32) float32 {
+	return inHg * HPA_PER_INHG
+}
+
+func HPaToInHg(hpa float32) float32 {
+	return hpa / HPA_PER_INHG
+}
@@ -0,0 +1,108 @@
+package weathermetrics
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func approxEqual(a, b float32) bool {
+	return math.Abs(float64(a-b)) < 0.01
+}
+
+func TestTemperatureConversions(t *testing.T) {
+	for _, tc := range []struct {
+		f, c, k float32
+	}{
+		{f: -40, c: -40, k: 233.15},
+		{f: 32, c: 0, k: 273.15},
+		{f: 68, c: 20, k: 293.15},
+		{f: 212, c: 100, k: 373.15},
+	} {
+		if got := FahrenheitToCelsius(tc.f); !approxEqual(got, tc.c) {
+			t.Errorf("FahrenheitToCelsius(%v) = %v, want %v", tc.f, got, tc.c)
+		}
+		if got := CelsiusToFahrenheit(tc.c); !approxEqual(got, tc.f) {
+			t.Errorf("CelsiusToFahrenheit(%v) = %v, want %v", tc.c, got, tc.f)
+		}
+		if got := FahrenheitToKelvin(tc.f); !approxEqual(got, tc.k) {
+			t.Errorf("FahrenheitToKelvin(%v) = %v, want %v", tc.f, got, tc.k)
+		}
+	}
+}
+
+func TestWindConversions(t *testing.T) {
+	for _, tc := range []struct {
+		kmh, mph, knots, ms float32
+	}{
+		{kmh: 0, mph: 0, knots: 0, ms: 0},
+		{kmh: 1.609344, mph: 1, knots: 0.868976, ms: 0.44704},
+		{kmh: 100, mph: 62.1371, knots: 53.9957, ms: 27.7778},
+	} {
+		if got := KmhToMph(tc.kmh); !approxEqual(got, tc.mph) {
+			t.Errorf("KmhToMph(%v) = %v, want %v", tc.kmh, got, tc.mph)
+		}
+		if got := MphToKmh(tc.mph); !approxEqual(got, tc.kmh) {
+			t.Errorf("MphToKmh(%v) = %v, want %v", tc.mph, got, tc.kmh)
+		}
+		if got := KmhToKnots(tc.kmh); !approxEqual(got, tc.knots) {
+			t.Errorf("KmhToKnots(%v) = %v, want %v", tc.kmh, got, tc.knots)
+		}
+		if got := MsToKmh(tc.ms); !approxEqual(got, tc.kmh) {
+			t.Errorf("MsToKmh(%v) = %v, want %v", tc.ms, got, tc.kmh)
+		}
+	}
+}
+
+func TestRainConversions(t *testing.T) {
+	for _, tc := range []struct {
+		in, mm float32
+	}{
+		{in: 0, mm: 0},
+		{in: 1, mm: 25.4},
+		{in: 0.01, mm: 0.254},
+	} {
+		if got := InchesToMM(tc.in); !approxEqual(got, tc.mm) {
+			t.Errorf("InchesToMM(%v) = %v, want %v", tc.in, got, tc.mm)
+		}
+		if got := MMToInches(tc.mm); !approxEqual(got, tc.in) {
+			t.Errorf("MMToInches(%v) = %v, want %v", tc.mm, got, tc.in)
+		}
+	}
+}
+
+func TestPressureConversions(t *testing.T) {
+	if got := KPaToHPa(101.325); !approxEqual(got, 1013.25) {
+		t.Errorf("KPaToHPa(101.325) = %v, want 1013.25", got)
+	}
+	if got := InHgToHPa(29.92); !approxEqual(got, 1013.21) {
+		t.Errorf("InHgToHPa(29.92) = %v, want 1013.21", got)
+	}
+	if got := HPaToInHg(1013.21); !approxEqual(got, 29.92) {
+		t.Errorf("HPaToInHg(1013.21) = %v, want 29.92", got)
+	}
+}
+
+func TestPressureAlternateUnitsDecodeToHPa(t *testing.T) {
+	for _, tc := range []struct {
+		payload string
+		want    float32
+	}{
+		{payload: `{"message_type":56,"temperature_F":60,"pressure_hPa":1013.2}`, want: 1013.2},
+		{payload: `{"message_type":56,"temperature_F":60,"pressure_kPa":101.32}`, want: 1013.2},
+		{payload: `{"message_type":56,"temperature_F":60,"pressure_inHg":29.92}`, want: 1013.21},
+	} {
+		events, err := DecodePayload([]byte(tc.payload), "")
+		if err != nil {
+			t.Fatalf("%s: %v", tc.payload, err)
+		}
+
+		var m TempHumidityMeasurement
+		if err := json.Unmarshal(events[0], &m); err != nil {
+			t.Fatalf("%s: %v", tc.payload, err)
+		}
+		if !approxEqual(m.PressureHPa, tc.want) {
+			t.Errorf("%s: pressure %v hPa, want %v", tc.payload, m.PressureHPa, tc.want)
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package weathermetrics
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+/*
+ * Config
+ *
+ * RANGE_POLICY picks, per field, what happens to a reading outside its
+ * plausible range: "reject" drops the message, "clamp" pins the value to the
+ * nearest bound. Fields not listed are rejected. Humidity defaults to clamp
+ * because condensation routinely reads a little over 100%.
+ *
+ * REQUIRE_MIC only accepts messages whose "mic" field, the integrity check
+ * rtl_433 used to decode them (e.g. "CRC" or "CHECKSUM"), matches. Empty
+ * accepts any, including messages without one.
+ *
+ * TEMP_MISMATCH decides what happens when a payload carries both
+ * temperature_F and temperature_C and they differ by more than
+ * TEMP_MISMATCH_TOLERANCE degrees F: "ignore" silently uses temperature_F,
+ * "log" uses it but logs the disagreement, "reject" drops the message.
+ *
+ * TIMESTAMP_POLICY does the same for sensor timestamps more than
+ * TIMESTAMP_MAX_FUTURE ahead of or TIMESTAMP_MAX_PAST behind our clock, which
+ * usually means the rtl_433 host's clock is wrong. They are counted under
+ * every policy, so the counter can be watched before choosing one.
+ *
+ * WIND_MAX_KMH caps wind_avg_km_h and wind_max_km_h. RF noise now and then
+ * decodes as a wind speed no storm reaches, and one such gust is enough to
+ * wreck a dashboard's scale and fire a high wind alert. Readings over the
+ * cap follow the wind fields' RANGE_POLICY and are counted either way. The
+ * default is well above any speed a 5n1 can measure.
+ */
+const (
+	RANGE_REJECT = "reject"
+	RANGE_CLAMP  = "clamp"
+)
+
+const (
+	TEMP_MISMATCH_IGNORE = "ignore"
+	TEMP_MISMATCH_LOG    = "log"
+	TEMP_MISMATCH_REJECT = "reject"
+)
+
+const (
+	TIMESTAMP_IGNORE = "ignore"
+	TIMESTAMP_LOG    = "log"
+	TIMESTAMP_REJECT = "reject"
+)
+
+type ValidationConfig struct {
+	RangePolicy map[string]string `envconfig:"RANGE_POLICY" default:"humidity:clamp"`
+	RequireMic  string            `envconfig:"REQUIRE_MIC"`
+
+	TempMismatch          string  `envconfig:"TEMP_MISMATCH" default:"ignore"`
+	TempMismatchTolerance float32 `envconfig:"TEMP_MISMATCH_TOLERANCE" default:"0.5"`
+
+	TimestampPolicy    string        `envconfig:"TIMESTAMP_POLICY" default:"ignore"`
+	TimestampMaxFuture time.Duration `envconfig:"TIMESTAMP_MAX_FUTURE" default:"5m"`
+	TimestampMaxPast   time.Duration `envconfig:"TIMESTAMP_MAX_PAST" default:"24h"`
+
+	WindMaxKmh float32 `envconfig:"WIND_MAX_KMH" default:"320"`
+}
+
+type FieldRange struct {
+	Min float32
+	Max float32
+}
+
+// FieldRanges is keyed by the rtl_433 field name
+var FieldRanges = map[string]FieldRange{
+	"temperature_F": {Min: -40, Max: 158},
+	"humidity":      {Min: 0, Max: 100},
+	"wind_avg_km_h": {Min: 0, Max: math.MaxFloat32},
+	"wind_max_km_h": {Min: 0, Max: math.MaxFloat32},
+	"wind_dir_deg":  {Min: 0, Max: 360},
+	"pressure_hPa":  {Min: 0, Max: 1100},
+}
+
+type Validator struct {
+	policies   map[string]string
+	requireMic string
+
+	tempMismatch          string
+	tempMismatchTolerance float32
+
+	timestampPolicy    string
+	timestampMaxFuture time.Duration
+	timestampMaxPast   time.Duration
+
+	windMaxKmh float32
+
+	negativeRain  atomic.Int64
+	badTimestamps atomic.Int64
+	windOverCap   atomic.Int64
+}
+
+func NewValidator(conf ValidationConfig) (*Validator, error) {
+	for field, policy := range conf.RangePolicy {
+		if _, ok := FieldRanges[field]; !ok {
+			return nil, fmt.Errorf("unknown field %q in RANGE_POLICY", field)
+		}
+
+		if policy != RANGE_REJECT && policy != RANGE_CLAMP {
+			return nil, fmt.Errorf("RANGE_POLICY for %s must be %q or %q, got %q",
+				field, RANGE_REJECT, RANGE_CLAMP, policy)
+		}
+	}
+
+	switch conf.TempMismatch {
+	case TEMP_MISMATCH_IGNORE, TEMP_MISMATCH_LOG, TEMP_MISMATCH_REJECT:
+	default:
+		return nil, fmt.Errorf("TEMP_MISMATCH must be %q, %q or %q, got %q",
+			TEMP_MISMATCH_IGNORE, TEMP_MISMATCH_LOG, TEMP_MISMATCH_REJECT, conf.TempMismatch)
+	}
+
+	if conf.TempMismatchTolerance < 0 {
+		return nil, fmt.Errorf("TEMP_MISMATCH_TOLERANCE must not be negative, got %v", conf.TempMismatchTolerance)
+	}
+
+	switch conf.TimestampPolicy {
+	case TIMESTAMP_IGNORE, TIMESTAMP_LOG, TIMESTAMP_REJECT:
+	default:
+		return nil, fmt.Errorf("TIMESTAMP_POLICY must be %q, %q or %q, got %q",
+			TIMESTAMP_IGNORE, TIMESTAMP_LOG, TIMESTAMP_REJECT, conf.TimestampPolicy)
+	}
+
+	if conf.TimestampMaxFuture < 0 || conf.TimestampMaxPast < 0 {
+		return nil, fmt.Errorf("TIMESTAMP_MAX_FUTURE and TIMESTAMP_MAX_PAST must not be negative, got %s and %s",
+			conf.TimestampMaxFuture, conf.TimestampMaxPast)
+	}
+
+	if conf.WindMaxKmh <= 0 {
+		return nil, fmt.Errorf("WIND_MAX_KMH must be positive, got %v", conf.WindMaxKmh)
+	}
+
+	return &Validator{
+		policies:              conf.RangePolicy,
+		requireMic:            conf.RequireMic,
+		tempMismatch:          conf.TempMismatch,
+		tempMismatchTolerance: conf.TempMismatchTolerance,
+		timestampPolicy:       conf.TimestampPolicy,
+		timestampMaxFuture:    conf.TimestampMaxFuture,
+		timestampMaxPast:      conf.TimestampMaxPast,
+		windMaxKmh:            conf.WindMaxKmh,
+	}, nil
+}
+
+// checkTemperatures compares temperature_F against temperature_C when a
+// payload had both
+func (v *Validator) checkTemperatures(m *TempHumidityMeasurement) error {
+	if m.tempC == nil || v.tempMismatch == TEMP_MISMATCH_IGNORE {
+		return nil
+	}
+
+	converted := CelsiusToFahrenheit(*m.tempC)
+	if float32(math.Abs(float64(converted-m.Temp))) <= v.tempMismatchTolerance {
+		return nil
+	}
+
+	err := fmt.Errorf("temperature_F %v disagrees with temperature_C %v (%vF)", m.Temp, *m.tempC, converted)
+	if v.tempMismatch == TEMP_MISMATCH_REJECT {
+		return err
+	}
+
+	log.Printf("WARNING: %s, using temperature_F", err)
+	return nil
+}
+
+// CheckTimestamp compares a message's timestamp against now. Timestamps that
+// don't parse are left to the caller.
+func (v *Validator) CheckTimestamp(timestamp string, loc *time.Location, now time.Time) error {
+	t, err := ParseMessageTime(timestamp, loc)
+	if err != nil {
+		return nil
+	}
+
+	offset := t.Sub(now)
+	if offset <= v.timestampMaxFuture && -offset <= v.timestampMaxPast {
+		return nil
+	}
+
+	v.badTimestamps.Add(1)
+	err = fmt.Errorf("timestamp %s is %s from now", timestamp, offset.Round(time.Second))
+	switch v.timestampPolicy {
+	case TIMESTAMP_REJECT:
+		return err
+	case TIMESTAMP_LOG:
+		log.Printf("WARNING: %s", err)
+	}
+
+	return nil
+}
+
+// CheckMic returns an error if the message's integrity check isn't the
+// required one
+func (v *Validator) CheckMic(mic string) error {
+	if v.requireMic == "" || mic == v.requireMic {
+		return nil
+	}
+
+	if mic == "" {
+		return fmt.Errorf("no mic, %s required", v.requireMic)
+	}
+
+	return fmt.Errorf("mic %s, %s required", mic, v.requireMic)
+}
+
+// Fields WIND_MAX_KMH applies to
+var windSpeedFields = map[string]bool{
+	"wind_avg_km_h": true,
+	"wind_max_km_h": true,
+}
+
+// fieldRange is the field's entry in FieldRanges with WIND_MAX_KMH applied
+func (v *Validator) fieldRange(field string) (FieldRange, bool) {
+	r, ok := FieldRanges[field]
+	if windSpeedFields[field] {
+		r.Max = min(r.Max, v.windMaxKmh)
+	}
+
+	return r, ok
+}
+
+// Check returns value, clamped if the field's policy allows, or an error if
+// it is out of range and should be rejected
+func (v *Validator) Check(field string, value float32) (float32, error) {
+	r, ok := v.fieldRange(field)
+	if !ok || (value >= r.Min && value <= r.Max) {
+		return value, nil
+	}
+
+	if windSpeedFields[field] && value > r.Max {
+		v.windOverCap.Add(1)
+	}
+
+	if v.policies[field] != RANGE_CLAMP {
+		return value, fmt.Errorf("%s %v outside %v to %v", field, value, r.Min, r.Max)
+	}
+
+	clamped := min(max(value, r.Min), r.Max)
+	log.Printf("Clamping %s %v to %v", field, value, clamped)
+	return clamped, nil
+}
+
+func (v *Validator) ValidateTempHumidity(m *TempHumidityMeasurement) error {
+	if err := v.CheckMic(m.Mic); err != nil {
+		return err
+	}
+
+	if err := v.checkTemperatures(m); err != nil {
+		return err
+	}
+
+	var err error
+	if m.Temp, err = v.Check("temperature_F", m.Temp); err != nil {
+		return err
+	}
+
+	if m.Humidity, err = v.Check("humidity", m.Humidity); err != nil {
+		return err
+	}
+
+	if m.PressureHPa, err = v.Check("pressure_hPa", m.PressureHPa); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (v *Validator) ValidateWindRain(m *WindRainMeasurement) error {
+	if err := v.CheckMic(m.Mic); err != nil {
+		return err
+	}
+
+	var err error
+	if m.WindSpeed, err = v.Check("wind_avg_km_h", m.WindSpeed); err != nil {
+		return err
+	}
+
+	if m.WindGust, err = v.Check("wind_max_km_h", m.WindGust); err != nil {
+		return err
+	}
+
+	if m.WindDirection, err = v.Check("wind_dir_deg", m.WindDirection); err != nil {
+		return err
+	}
+
+	// A negative total is a firmware fault rather than a counter reset. It
+	// is dropped regardless of RANGE_POLICY: clamped to 0 it would look like
+	// a reset to the rain accumulator, and the next good reading would be
+	// counted as new rain.
+	if m.RainInches < 0 {
+		log.Printf("WARNING: dropping negative rain_in %v", m.RainInches)
+		m.RainMissing = true
+		v.negativeRain.Add(1)
+	}
+
+	return nil
+}
+
+// BadTimestamps is the number of messages whose timestamp was outside
+// TIMESTAMP_MAX_FUTURE/TIMESTAMP_MAX_PAST, whatever the policy
+func (v *Validator) BadTimestamps() int64 {
+	return v.badTimestamps.Load()
+}
+
+// WindOverCap is the number of wind readings over WIND_MAX_KMH, whether
+// clamped or rejected
+func (v *Validator) WindOverCap() int64 {
+	return v.windOverCap.Load()
+}
+
+// NegativeRain is the number of negative rain_in readings dropped
+func (v *Validator) NegativeRain() int64 {
+	return v.negativeRain.Load()
+}
@@ -0,0 +1,310 @@
+package weathermetrics
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// validationConfig is ValidationConfig's defaults
+func validationConfig() ValidationConfig {
+	return ValidationConfig{
+		RangePolicy:           map[string]string{"humidity": RANGE_CLAMP},
+		TempMismatch:          TEMP_MISMATCH_IGNORE,
+		TempMismatchTolerance: 0.5,
+		TimestampPolicy:       TIMESTAMP_IGNORE,
+		TimestampMaxFuture:    5 * time.Minute,
+		TimestampMaxPast:      24 * time.Hour,
+		WindMaxKmh:            320,
+	}
+}
+
+func newValidator(t *testing.T, conf ValidationConfig) *Validator {
+	t.Helper()
+
+	v, err := NewValidator(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestHumidityOverRangeClampedByDefault(t *testing.T) {
+	v := newValidator(t, validationConfig())
+
+	m := TempHumidityMeasurement{Temp: 60, Humidity: 101}
+	if err := v.ValidateTempHumidity(&m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Humidity != 100 {
+		t.Errorf("humidity 101 became %v, want 100", m.Humidity)
+	}
+}
+
+func TestHumidityOverRangeRejected(t *testing.T) {
+	conf := validationConfig()
+	conf.RangePolicy = map[string]string{"humidity": RANGE_REJECT}
+	v := newValidator(t, conf)
+
+	m := TempHumidityMeasurement{Temp: 60, Humidity: 101}
+	if err := v.ValidateTempHumidity(&m); err == nil {
+		t.Error("humidity 101 accepted under reject")
+	}
+}
+
+func TestFieldsWithoutPolicyRejected(t *testing.T) {
+	v := newValidator(t, validationConfig())
+
+	m := TempHumidityMeasurement{Temp: 200, Humidity: 50}
+	if err := v.ValidateTempHumidity(&m); err == nil {
+		t.Error("temperature_F 200 accepted")
+	}
+}
+
+func TestRangePolicyValidated(t *testing.T) {
+	for name, policies := range map[string]map[string]string{
+		"unknown field":  {"dew_point": RANGE_CLAMP},
+		"unknown policy": {"humidity": "ignore"},
+	} {
+		conf := validationConfig()
+		conf.RangePolicy = policies
+		if _, err := NewValidator(conf); err == nil {
+			t.Errorf("%s: RANGE_POLICY %v accepted", name, policies)
+		}
+	}
+}
+
+func TestCheckMic(t *testing.T) {
+	for _, tc := range []struct {
+		require, mic string
+		ok           bool
+	}{
+		{require: "", mic: "CHECKSUM", ok: true},
+		{require: "", mic: "CRC", ok: true},
+		{require: "", mic: "", ok: true},
+		{require: "CRC", mic: "CRC", ok: true},
+		{require: "CRC", mic: "CHECKSUM", ok: false},
+		{require: "CRC", mic: "", ok: false},
+	} {
+		conf := validationConfig()
+		conf.RequireMic = tc.require
+		v := newValidator(t, conf)
+
+		if err := v.CheckMic(tc.mic); (err == nil) != tc.ok {
+			t.Errorf("REQUIRE_MIC %q, mic %q: got %v", tc.require, tc.mic, err)
+		}
+	}
+}
+
+func TestNegativeRainDroppedAndCounted(t *testing.T) {
+	v := newValidator(t, validationConfig())
+
+	m := WindRainMeasurement{WindSpeed: 10, RainInches: -0.5}
+	if err := v.ValidateWindRain(&m); err != nil {
+		t.Fatal(err)
+	}
+	if !m.RainMissing {
+		t.Error("rain_in -0.5 kept")
+	}
+
+	ok := WindRainMeasurement{RainInches: 0.5}
+	if err := v.ValidateWindRain(&ok); err != nil || ok.RainInches != 0.5 || ok.RainMissing {
+		t.Errorf("rain_in 0.5 became %v missing %v, %v", ok.RainInches, ok.RainMissing, err)
+	}
+
+	if n := v.NegativeRain(); n != 1 {
+		t.Errorf("NegativeRain() = %d, want 1", n)
+	}
+}
+
+func TestNegativeRainDroppedUnderRejectPolicy(t *testing.T) {
+	conf := validationConfig()
+	conf.RangePolicy = map[string]string{"humidity": RANGE_REJECT, "wind_avg_km_h": RANGE_REJECT}
+	v := newValidator(t, conf)
+
+	m := WindRainMeasurement{RainInches: -0.01}
+	if err := v.ValidateWindRain(&m); err != nil || !m.RainMissing {
+		t.Errorf("negative rain under reject: missing %v, %v", m.RainMissing, err)
+	}
+}
+
+// A clamped 0 would read as a counter reset and the next good reading as
+// new rain
+func TestNegativeRainLeavesDailyTotalAlone(t *testing.T) {
+	v := newValidator(t, validationConfig())
+	rain := NewRainAccumulator(time.UTC, 0.02, 0)
+	var conditions CurrentConditions
+	at := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	for i, reading := range []float32{0.23, 0.23, -0.5, 0.23} {
+		m := WindRainMeasurement{WindSpeed: 5, RainInches: reading}
+		if err := v.ValidateWindRain(&m); err != nil {
+			t.Fatal(err)
+		}
+		conditions.ApplyWindRain(m)
+		if !m.RainMissing {
+			rain.Observe(m.RainInches, at.Add(time.Duration(i)*time.Minute))
+		}
+	}
+
+	if got := rain.Daily(at.Add(5 * time.Minute)); got != 0 {
+		t.Errorf("daily rain = %v, want 0", got)
+	}
+	if conditions.RainInches != 0.23 {
+		t.Errorf("rain_in = %v, want the last good 0.23", conditions.RainInches)
+	}
+}
+
+func TestTempMismatch(t *testing.T) {
+	// 70F is 21.1C; 25C is 77F
+	const inconsistent = `{"message_type":56,"temperature_F":70,"temperature_C":25,"humidity":50}`
+	const consistent = `{"message_type":56,"temperature_F":70,"temperature_C":21.1,"humidity":50}`
+
+	for _, tc := range []struct {
+		policy, payload string
+		ok              bool
+	}{
+		{policy: TEMP_MISMATCH_IGNORE, payload: inconsistent, ok: true},
+		{policy: TEMP_MISMATCH_LOG, payload: inconsistent, ok: true},
+		{policy: TEMP_MISMATCH_REJECT, payload: inconsistent, ok: false},
+		{policy: TEMP_MISMATCH_REJECT, payload: consistent, ok: true},
+	} {
+		logs := captureLog(t)
+		conf := validationConfig()
+		conf.TempMismatch = tc.policy
+		v := newValidator(t, conf)
+
+		var m TempHumidityMeasurement
+		if err := json.Unmarshal([]byte(tc.payload), &m); err != nil {
+			t.Fatal(err)
+		}
+
+		err := v.ValidateTempHumidity(&m)
+		if (err == nil) != tc.ok {
+			t.Errorf("%s %s: got %v", tc.policy, tc.payload, err)
+		}
+		if tc.ok && m.Temp != 70 {
+			t.Errorf("%s: temperature %v, want temperature_F's 70", tc.policy, m.Temp)
+		}
+
+		logged := strings.Contains(logs.String(), "disagrees with temperature_C")
+		if logged != (tc.policy == TEMP_MISMATCH_LOG) {
+			t.Errorf("%s %s: logged %v:\n%s", tc.policy, tc.payload, logged, logs)
+		}
+	}
+}
+
+func TestTempMismatchOnlyWithBothFields(t *testing.T) {
+	conf := validationConfig()
+	conf.TempMismatch = TEMP_MISMATCH_REJECT
+	v := newValidator(t, conf)
+
+	m := TempHumidityMeasurement{Temp: 70, Humidity: 50}
+	if err := v.ValidateTempHumidity(&m); err != nil {
+		t.Errorf("temperature_F alone rejected: %v", err)
+	}
+}
+
+func TestCheckTimestampPolicies(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	future := now.Add(10 * time.Minute).Format(RTL433_TIME_FORMAT)
+	farPast := now.Add(-48 * time.Hour).Format(RTL433_TIME_FORMAT)
+
+	for _, policy := range []string{TIMESTAMP_IGNORE, TIMESTAMP_LOG, TIMESTAMP_REJECT} {
+		conf := validationConfig()
+		conf.TimestampPolicy = policy
+		v := newValidator(t, conf)
+		logs := captureLog(t)
+
+		for _, timestamp := range []string{future, farPast} {
+			err := v.CheckTimestamp(timestamp, time.UTC, now)
+			if rejected := err != nil; rejected != (policy == TIMESTAMP_REJECT) {
+				t.Errorf("%s: %s gave error %v", policy, timestamp, err)
+			}
+		}
+
+		// Counted whatever the policy; the policy only decides what else
+		// happens
+		if got := v.BadTimestamps(); got != 2 {
+			t.Errorf("%s: counted %d bad timestamps, want 2", policy, got)
+		}
+
+		logged := strings.Count(logs.String(), "WARNING: timestamp")
+		if want := map[bool]int{true: 2, false: 0}[policy == TIMESTAMP_LOG]; logged != want {
+			t.Errorf("%s: logged %d warnings, want %d", policy, logged, want)
+		}
+	}
+}
+
+func TestCheckTimestampWithinTolerance(t *testing.T) {
+	conf := validationConfig()
+	conf.TimestampPolicy = TIMESTAMP_REJECT
+	v := newValidator(t, conf)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, at := range []time.Time{
+		now,
+		now.Add(5 * time.Minute),
+		now.Add(-24 * time.Hour),
+	} {
+		if err := v.CheckTimestamp(at.Format(RTL433_TIME_FORMAT), time.UTC, now); err != nil {
+			t.Errorf("%s rejected: %s", at, err)
+		}
+	}
+
+	// Unparseable timestamps are left to the caller
+	if err := v.CheckTimestamp("yesterday", time.UTC, now); err != nil {
+		t.Errorf("unparseable timestamp rejected: %s", err)
+	}
+	if got := v.BadTimestamps(); got != 0 {
+		t.Errorf("counted %d bad timestamps, want 0", got)
+	}
+}
+
+func TestWindOverCapRejectedByDefault(t *testing.T) {
+	v := newValidator(t, validationConfig())
+
+	m := WindRainMeasurement{WindSpeed: 500, WindGust: 20}
+	if err := v.ValidateWindRain(&m); err == nil {
+		t.Error("500 km/h accepted")
+	}
+	if n := v.WindOverCap(); n != 1 {
+		t.Errorf("WindOverCap = %d, want 1", n)
+	}
+
+	// At the cap is fine
+	m = WindRainMeasurement{WindSpeed: 320, WindGust: 320}
+	if err := v.ValidateWindRain(&m); err != nil {
+		t.Errorf("320 km/h rejected: %s", err)
+	}
+	if n := v.WindOverCap(); n != 1 {
+		t.Errorf("WindOverCap = %d after a reading at the cap, want 1", n)
+	}
+}
+
+func TestWindOverCapClamped(t *testing.T) {
+	conf := validationConfig()
+	conf.WindMaxKmh = 150
+	conf.RangePolicy = map[string]string{"wind_avg_km_h": RANGE_CLAMP, "wind_max_km_h": RANGE_CLAMP}
+	v := newValidator(t, conf)
+
+	m := WindRainMeasurement{WindSpeed: 40, WindGust: 200}
+	if err := v.ValidateWindRain(&m); err != nil {
+		t.Fatal(err)
+	}
+	if m.WindSpeed != 40 || m.WindGust != 150 {
+		t.Errorf("got %v gusting %v, want 40 gusting the 150 cap", m.WindSpeed, m.WindGust)
+	}
+	if n := v.WindOverCap(); n != 1 {
+		t.Errorf("WindOverCap = %d, want 1", n)
+	}
+}
+
+func TestWindMaxKmhValidated(t *testing.T) {
+	conf := validationConfig()
+	conf.WindMaxKmh = 0
+	if _, err := NewValidator(conf); err == nil {
+		t.Error("WIND_MAX_KMH=0 accepted")
+	}
+}
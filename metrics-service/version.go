@@ -0,0 +1,27 @@
+package weathermetrics
+
+/*
+ * Build info
+ *
+ * These are overridden at build time, e.g.
+ *   go build -ldflags "-X github.com/mckeowbc/weather-metrics.Version=v0.5"
+ */
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildTime = "unknown"
+)
+
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+}
+
+func GetBuildInfo() BuildInfo {
+	return BuildInfo{
+		Version:   Version,
+		Commit:    Commit,
+		BuildTime: BuildTime,
+	}
+}
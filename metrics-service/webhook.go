@@ -0,0 +1,163 @@
+package weathermetrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+/*
+ * Config
+ */
+type WebhookConfig struct {
+	URL string `envconfig:"WEBHOOK_URL"`
+
+	// Headers are added to every request, e.g.
+	// "Authorization:Bearer abc123,X-Station:backyard"
+	Headers map[string]string `envconfig:"WEBHOOK_HEADERS" secret:"true"`
+	Timeout time.Duration     `envconfig:"WEBHOOK_TIMEOUT" default:"10s"`
+	Retries int               `envconfig:"WEBHOOK_RETRIES" default:"3"`
+}
+
+func (c WebhookConfig) Enabled() bool {
+	return c.URL != ""
+}
+
+func (c WebhookConfig) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+
+	if c.Timeout <= 0 {
+		return fmt.Errorf("WEBHOOK_TIMEOUT must be positive, got %s", c.Timeout)
+	}
+
+	if c.Retries < 0 {
+		return fmt.Errorf("WEBHOOK_RETRIES must not be negative, got %d", c.Retries)
+	}
+
+	return nil
+}
+
+/*
+ * Webhook sink
+ *
+ * POSTs the merged conditions as JSON to URL after every update, or every
+ * OUTPUT_INTERVAL when that is set. Write only stashes the conditions; Run
+ * does the sending, so a slow endpoint never holds up ingest. Only the newest
+ * conditions are kept, and updates that arrive while a POST is in flight
+ * replace each other rather than queueing.
+ *
+ * Failed POSTs are retried with backoff, then dropped with a warning; the
+ * next update carries the current state anyway.
+ */
+type WebhookSink struct {
+	conf   WebhookConfig
+	client *http.Client
+
+	m       sync.Mutex
+	pending *CurrentConditions
+	wake    chan struct{}
+}
+
+func NewWebhookSink(conf WebhookConfig, userAgent string) *WebhookSink {
+	return &WebhookSink{
+		conf:   conf,
+		client: NewHTTPClient(userAgent, conf.Timeout),
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+func (s *WebhookSink) Write(c CurrentConditions, at time.Time) {
+	s.m.Lock()
+	s.pending = &c
+	s.m.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends each update until stop is closed
+func (s *WebhookSink) Run(stop <-chan struct{}) {
+	for {
+		select {
+		case <-s.wake:
+			s.flush()
+		case <-stop:
+			s.flush()
+			return
+		}
+	}
+}
+
+func (s *WebhookSink) flush() {
+	s.m.Lock()
+	pending := s.pending
+	s.pending = nil
+	s.m.Unlock()
+
+	if pending == nil {
+		return
+	}
+
+	body, err := json.Marshal(pending)
+	if err != nil {
+		log.Printf("webhook: could not encode conditions: %s", err)
+		return
+	}
+
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		retry, err := s.post(body)
+		if err == nil {
+			return
+		}
+
+		if !retry || attempt >= s.conf.Retries {
+			log.Printf("WARNING: webhook: dropping update: %s", err)
+			return
+		}
+
+		log.Printf("WARNING: webhook: %s, retrying in %s", err, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post sends one update and reports whether a failure is worth retrying
+func (s *WebhookSink) post(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, s.conf.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range s.conf.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		// The error repeats the URL, token and all
+		if urlErr, ok := err.(*url.Error); ok {
+			urlErr.URL = RedactURL(urlErr.URL)
+		}
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+
+	// A 4xx other than 429 will fail the same way again
+	retry := resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("%s returned %s", RedactURL(s.conf.URL), resp.Status)
+}
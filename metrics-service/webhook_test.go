@@ -0,0 +1,174 @@
+package weathermetrics
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookRequest is what the test server saw of one POST
+type webhookRequest struct {
+	header     http.Header
+	conditions CurrentConditions
+}
+
+// webhookServer stands in for the endpoint, answering with each status in
+// turn and then 200
+type webhookServer struct {
+	*httptest.Server
+
+	m        sync.Mutex
+	statuses []int
+	requests []webhookRequest
+}
+
+func newWebhookServer(t *testing.T, statuses ...int) *webhookServer {
+	t.Helper()
+
+	s := &webhookServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		var c CurrentConditions
+		if err := json.Unmarshal(body, &c); err != nil {
+			t.Errorf("POST body isn't conditions JSON: %s: %s", err, body)
+		}
+
+		s.m.Lock()
+		defer s.m.Unlock()
+		if r.Method != http.MethodPost {
+			t.Errorf("got %s, want POST", r.Method)
+		}
+		s.requests = append(s.requests, webhookRequest{header: r.Header, conditions: c})
+
+		status := http.StatusOK
+		if len(s.statuses) > 0 {
+			status, s.statuses = s.statuses[0], s.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *webhookServer) Requests() []webhookRequest {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return append([]webhookRequest(nil), s.requests...)
+}
+
+// sendWebhook writes c and runs the sink until it has flushed
+func sendWebhook(conf WebhookConfig, c CurrentConditions) {
+	sink := NewWebhookSink(conf, "test-agent/1.0")
+	sink.Write(c, time.Now())
+
+	stop := make(chan struct{})
+	close(stop)
+	sink.Run(stop)
+}
+
+func TestWebhookPostsConditions(t *testing.T) {
+	server := newWebhookServer(t)
+
+	sendWebhook(WebhookConfig{
+		URL:     server.URL,
+		Headers: map[string]string{"Authorization": "Bearer abc123", "X-Station": "backyard"},
+		Timeout: time.Second,
+	}, CurrentConditions{ID: 1026, Temp: 69.5, Humidity: 97})
+
+	requests := server.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("%d requests, want 1", len(requests))
+	}
+
+	got := requests[0]
+	if got.conditions.ID != 1026 || got.conditions.Temp != 69.5 || got.conditions.Humidity != 97 {
+		t.Errorf("posted %+v", got.conditions)
+	}
+	for name, want := range map[string]string{
+		"Content-Type":  "application/json",
+		"Authorization": "Bearer abc123",
+		"X-Station":     "backyard",
+		"User-Agent":    "test-agent/1.0",
+	} {
+		if value := got.header.Get(name); value != want {
+			t.Errorf("%s = %q, want %q", name, value, want)
+		}
+	}
+}
+
+func TestWebhookRetriesServerErrors(t *testing.T) {
+	server := newWebhookServer(t, http.StatusServiceUnavailable)
+
+	sendWebhook(WebhookConfig{URL: server.URL, Timeout: time.Second, Retries: 1}, CurrentConditions{Temp: 70})
+
+	if n := len(server.Requests()); n != 2 {
+		t.Errorf("%d requests, want a retry after the 503", n)
+	}
+}
+
+func TestWebhookDoesNotRetryClientErrors(t *testing.T) {
+	server := newWebhookServer(t, http.StatusBadRequest)
+	logs := captureLog(t)
+
+	sendWebhook(WebhookConfig{URL: server.URL, Timeout: time.Second, Retries: 3}, CurrentConditions{Temp: 70})
+
+	if n := len(server.Requests()); n != 1 {
+		t.Errorf("%d requests, want the 400 not retried", n)
+	}
+	if !strings.Contains(logs.String(), "WARNING: webhook: dropping update") {
+		t.Errorf("no warning logged:\n%s", logs)
+	}
+}
+
+func TestWebhookWriteDoesNotBlock(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(server.Close)
+
+	sink := NewWebhookSink(WebhookConfig{URL: server.URL, Timeout: 5 * time.Second}, "")
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		sink.Run(stop)
+		close(done)
+	}()
+
+	// Cleanups run last first: let the POST finish, then stop the sink
+	t.Cleanup(func() { <-done })
+	t.Cleanup(func() { close(stop) })
+	t.Cleanup(func() { close(release) })
+
+	// The first POST hangs; the writes behind it must still return at once
+	start := time.Now()
+	for i := range 100 {
+		sink.Write(CurrentConditions{Temp: float32(i)}, start)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("writes took %s behind a slow endpoint", elapsed)
+	}
+}
+
+func TestWebhookConfigValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		conf WebhookConfig
+		ok   bool
+	}{
+		{name: "disabled", conf: WebhookConfig{}, ok: true},
+		{name: "enabled", conf: WebhookConfig{URL: "http://hook", Timeout: time.Second, Retries: 3}, ok: true},
+		{name: "zero timeout", conf: WebhookConfig{URL: "http://hook"}},
+		{name: "negative retries", conf: WebhookConfig{URL: "http://hook", Timeout: time.Second, Retries: -1}},
+	} {
+		if err := tc.conf.Validate(); (err == nil) != tc.ok {
+			t.Errorf("%s: Validate() = %v", tc.name, err)
+		}
+	}
+}
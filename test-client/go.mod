@@ -3,9 +3,12 @@ module weather-metrics
 go 1.24.4
 
 require (
-	github.com/eclipse/paho.mqtt.golang v1.5.0 // indirect
+	github.com/eclipse/paho.mqtt.golang v1.5.0
+	github.com/kelseyhightower/envconfig v1.4.0
+)
+
+require (
 	github.com/gorilla/websocket v1.5.3 // indirect
-	github.com/kelseyhightower/envconfig v1.4.0 // indirect
 	github.com/sethvargo/go-envconfig v1.3.0 // indirect
 	golang.org/x/net v0.27.0 // indirect
 	golang.org/x/sync v0.7.0 // indirect